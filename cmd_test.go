@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lunaria/lunaria"
+)
+
+func TestColorize(t *testing.T) {
+	defer func(writers map[io.Writer]bool) { colorWriters = writers }(colorWriters)
+
+	var colored, plain bytes.Buffer
+	colorWriters = map[io.Writer]bool{&colored: true, &plain: false}
+	testCases := []struct {
+		level string
+		code  string
+	}{
+		{"error", "\033[31m"},
+		{"warning", "\033[33m"},
+		{"success", "\033[32m"},
+		{"bold", "\033[1m"},
+	}
+	for _, tc := range testCases {
+		got := colorize(&colored, tc.level, "message")
+		if !strings.HasPrefix(got, tc.code) || !strings.HasSuffix(got, ansiReset) {
+			t.Errorf("colorize(%q) = %q, expected ANSI code %q", tc.level, got, tc.code)
+		}
+	}
+
+	if got := colorize(&colored, "unknown", "message"); got != "message" {
+		t.Errorf("Expected unknown level to be left plain, got %q", got)
+	}
+
+	// Each stream is colored on its own, e.g. errors on a terminal while
+	// stdout is redirected
+	if got := colorize(&plain, "error", "message"); got != "message" {
+		t.Errorf("Expected no ANSI codes when color is disabled, got %q", got)
+	}
+}
+
+func TestColorSupportedHonoursOptOut(t *testing.T) {
+	if colorSupported(true, os.Stderr) {
+		t.Error("Expected --no-color to disable color")
+	}
+	if colorSupported(false, &bytes.Buffer{}) {
+		t.Error("Expected a non-terminal stream to disable color")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if colorSupported(false, os.Stderr) {
+		t.Error("Expected NO_COLOR to disable color")
+	}
+}
+
+// writeScript writes a small Lunaria script to a temporary file
+func writeScript(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.xml")
+	if err := os.WriteFile(path, []byte(`<set var="x" local="true">42</set>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunPrintsToStdout(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{writeScript(t)}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	if got := stdout.String(); got != "local x = 42\n" {
+		t.Errorf("Unexpected stdout: %q", got)
+	}
+}
+
+func TestRunOutputFlag(t *testing.T) {
+	for _, flagName := range []string{"-o", "--output"} {
+		t.Run(flagName, func(t *testing.T) {
+			input := writeScript(t)
+			output := filepath.Join(t.TempDir(), "out", "script.lua")
+
+			// Flags may follow the input file
+			var stdout, stderr bytes.Buffer
+			if code := run([]string{input, flagName, output}, nil, &stdout, &stderr); code != 0 {
+				t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+			}
+
+			content, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatalf("Expected output file: %v", err)
+			}
+			if string(content) != "local x = 42" {
+				t.Errorf("Unexpected output file content: %q", content)
+			}
+			if !strings.Contains(stdout.String(), "Compiled "+input+" -> "+output) {
+				t.Errorf("Expected success message, got: %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunRejectsPositionalOutput(t *testing.T) {
+	input := writeScript(t)
+	output := filepath.Join(t.TempDir(), "out.lua")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{input, output}, nil, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "-o") {
+		t.Errorf("Expected error to point at -o, got: %q", stderr.String())
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Error("Expected no output file to be written")
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		args  []string
+		stdin string
+		code  int
+	}{
+		{"Missing file", []string{filepath.Join(t.TempDir(), "missing.xml")}, "", 1},
+		{"Unknown flag", []string{"--bogus"}, "", 2},
+		{"Output without value", []string{"script.xml", "-o"}, "", 2},
+		{"Unknown target", []string{"--target", "lua54", "-"}, "<print>1</print>", 2},
+		{"Luau-only feature for Lua 5.1", []string{"--emit-lua51", "-"}, `<while test="true"><continue/></while>`, 1},
+		{"Invalid stdin", []string{"-"}, "<set var=", 1},
+		{"Unknown tag on stdin", []string{"-"}, "<bogus/>", 1},
+		{"Missing plugin", []string{"--plugin", filepath.Join(t.TempDir(), "missing.so"), "-"}, "<print>1</print>", 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tc.args, strings.NewReader(tc.stdin), &stdout, &stderr); code != tc.code {
+				t.Errorf("Expected exit code %d, got %d", tc.code, code)
+			}
+			if stderr.Len() == 0 {
+				t.Error("Expected an error message on stderr")
+			}
+		})
+	}
+}
+
+func TestRunHelpDocumentsOutputFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"--help"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	if !strings.Contains(stdout.String(), "-o, --output <FILE>") {
+		t.Errorf("Expected help to document -o/--output, got:\n%s", stdout.String())
+	}
+}
+
+func TestRun(t *testing.T) {
+	script := writeScript(t)
+
+	testCases := []struct {
+		name     string
+		args     []string
+		stdin    string
+		contains string
+	}{
+		{"No arguments shows help", nil, "", "USAGE:"},
+		{"Help flag", []string{"-h"}, "", "USAGE:"},
+		{"Help command", []string{"help"}, "", "USAGE:"},
+		{"Version flag", []string{"--version"}, "", "Lunaria " + lunaria.Version},
+		{"Version command", []string{"version"}, "", "Lunaria " + lunaria.Version},
+		{"Examples", []string{"examples"}, "", "Compiles to:"},
+		{"File", []string{script}, "", "local x = 42"},
+		{"Stdin", []string{"-"}, `<print>"Hello"</print>`, `print("Hello")`},
+		{"Minified stdin", []string{"--minify", "-"}, `<if test="a"><print>b</print></if>`, "if a then\nprint(b)\nend"},
+		{"Strict", []string{"--strict", "-"}, `<script><set var="x">1</set></script>`, "--!strict\nx = 1"},
+		{"Lua 5.1 target", []string{"--target", "lua51", "-"}, `<typeof var="t">x</typeof>`, "t = type(x)"},
+		{"Emit Lua 5.1", []string{"--emit-lua51", "-"}, `<typeof var="t">x</typeof>`, "t = type(x)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tc.args, strings.NewReader(tc.stdin), &stdout, &stderr); code != 0 {
+				t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+			}
+
+			if !strings.Contains(stdout.String(), tc.contains) {
+				t.Errorf("Expected output to contain %q, got:\n%s", tc.contains, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunStdinToOutputFile(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.lua")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-", "-o", output}, strings.NewReader(`<set var="y">1</set>`), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Expected output file: %v", err)
+	}
+	if string(content) != "y = 1" {
+		t.Errorf("Unexpected output file content: %q", content)
+	}
+}
+
+func TestRunIncludeRelativeToFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.xml"), []byte(`<include src="part.xml"/>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "part.xml"), []byte(`<set var="x">1</set>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{filepath.Join(dir, "main.xml")}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "x = 1\n" {
+		t.Errorf("Unexpected output: %q", stdout.String())
+	}
+}
+
+func TestRunFmt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.xml")
+	if err := os.WriteFile(path, []byte(`<script><set  local="true" var="x">42</set></script>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := "<script>\n  <set local=\"true\" var=\"x\">42</set>\n</script>\n"
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"fmt", path}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if stdout.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"fmt", "-w", path}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != expected {
+		t.Errorf("Expected the file to be rewritten in place, got %q (error: %v)", content, err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Expected no output when writing in place, got %q", stdout.String())
+	}
+
+	if code := run([]string{"fmt", "-"}, strings.NewReader("<script>"), &stdout, &stderr); code != 1 {
+		t.Errorf("Expected exit code 1 for invalid XML, got %d", code)
+	}
+}
+
+func TestCompileBatch(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.xml":     `<set var="a">1</set>`,
+		"b.xml":     `<bogus/>`,
+		"c.lunaria": `<include src="a.xml"/>`,
+		"notes.txt": `not xml`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := compileBatch(filepath.Join(dir, "*"), lunaria.DefaultCompileOptions(), nil)
+	if err != nil {
+		t.Fatalf("compileBatch failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got: %v", results)
+	}
+
+	if r := results[0]; r.File != filepath.Join(dir, "a.xml") || r.Output != filepath.Join(dir, "a.lua") || r.Err != nil {
+		t.Errorf("Unexpected result for a.xml: %+v", r)
+	}
+	if r := results[1]; r.File != filepath.Join(dir, "b.xml") || r.Output != "" || r.Err == nil || !strings.Contains(r.Err.Error(), "unknown tag: bogus") {
+		t.Errorf("Unexpected result for b.xml: %+v", r)
+	}
+	if r := results[2]; r.Output != filepath.Join(dir, "c.lua") || r.Err != nil {
+		t.Errorf("Unexpected result for c.lunaria: %+v", r)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "c.lua")); err != nil || string(content) != "a = 1" {
+		t.Errorf("Expected c.lua to be written, got %q (error: %v)", content, err)
+	}
+
+	if _, err := compileBatch(filepath.Join(dir, "*.none"), lunaria.DefaultCompileOptions(), nil); err == nil {
+		t.Error("Expected an error for a pattern matching no files")
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"ok.xml": `<set var="x">1</set>`, "bad.xml": `<bogus/>`} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"batch", filepath.Join(dir, "*.xml")}, nil, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "ok.lua") {
+		t.Errorf("Expected ok.xml to be reported as compiled, got:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "bad.xml: unknown tag: bogus") || !strings.Contains(stderr.String(), "1 of 2 files failed") {
+		t.Errorf("Expected bad.xml to be reported as failed, got:\n%s", stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"batch", filepath.Join(dir, "ok.xml")}, nil, &stdout, &stderr); code != 0 {
+		t.Errorf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+}
+
+func TestRunPrintsWarnings(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	xml := `<script><set var="a" local="true">1</set><set var="a" local="true">2</set></script>`
+	if code := run([]string{"-"}, strings.NewReader(xml), &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	if !strings.Contains(stderr.String(), "Warning: set: local 'a' is redeclared") {
+		t.Errorf("Expected redeclaration warning on stderr, got: %q", stderr.String())
+	}
+	if strings.Contains(stdout.String(), "Warning") {
+		t.Errorf("Expected warnings to stay off stdout, got: %q", stdout.String())
+	}
+}
+
+func TestRunReportsSyntaxErrorLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.xml")
+	source := "<script>\n  <set var=\"x\">1</set>\n\t<print>hi</prin>\n</script>\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{path}, nil, &stdout, &stderr); code != 1 {
+		t.Fatalf("Expected exit code 1, got %d", code)
+	}
+
+	lines := strings.Split(strings.TrimRight(stderr.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected message, source line and caret, got:\n%s", stderr.String())
+	}
+	if !strings.HasPrefix(lines[0], "error: "+path+": ") || !strings.Contains(lines[0], "line 3") {
+		t.Errorf("Unexpected error line: %q", lines[0])
+	}
+	if lines[1] != "    3 | \t<print>hi</prin>" {
+		t.Errorf("Unexpected source line: %q", lines[1])
+	}
+	if lines[2] != "      | \t^" {
+		t.Errorf("Unexpected caret line: %q", lines[2])
+	}
+}
+
+func TestReportCompileErrorColors(t *testing.T) {
+	defer func(writers map[io.Writer]bool) { colorWriters = writers }(colorWriters)
+
+	var out bytes.Buffer
+	colorWriters = map[io.Writer]bool{&out: true}
+	reportCompileError(&out, "script.xml", `<set var="x"></set>`, &lunaria.CompileError{Tag: "set", Message: "set command requires a value"})
+
+	expected := "\033[31merror:\033[0m \033[1mscript.xml\033[0m: set: set command requires a value\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffOp is one line of an edit script: kept (' '), removed ('-') or added ('+')
+type diffOp struct {
+	kind byte
+	line string
+	a, b int // zero-based positions in the old and new text before this line
+}
+
+// diffLines computes a line edit script turning a into b using a longest
+// common subsequence. Common leading and trailing lines are matched first
+// so the quadratic part only covers the region that changed.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(midA), len(midB)
+
+	// lcs[i][j] is the LCS length of midA[i:] and midB[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{' ', a[i], i, i})
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && midA[i] == midB[j]:
+			ops = append(ops, diffOp{' ', midA[i], prefix + i, prefix + j})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			// Prefer removals so they are listed before additions
+			ops = append(ops, diffOp{'-', midA[i], prefix + i, prefix + j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', midB[j], prefix + i, prefix + j})
+			j++
+		}
+	}
+	for k := 0; k < suffix; k++ {
+		ai, bi := len(a)-suffix+k, len(b)-suffix+k
+		ops = append(ops, diffOp{' ', a[ai], ai, bi})
+	}
+	return ops
+}
+
+// unifiedDiff renders the differences between the lines of a and b in
+// unified format with the given number of context lines, or returns ""
+// when they are equal
+func unifiedDiff(fromName, toName string, a, b []string, context int) string {
+	ops := diffLines(a, b)
+
+	var changes []int
+	for k, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, k)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for c := 0; c < len(changes); {
+		// Merge changes whose context would overlap into one hunk
+		last := c
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*context {
+			last++
+		}
+		from := max(changes[c]-context, 0)
+		to := min(changes[last]+context+1, len(ops))
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(ops[from].a, aCount), hunkRange(ops[from].b, bCount))
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+
+		c = last + 1
+	}
+	return out.String()
+}
+
+// hunkRange formats the start,count range of a hunk header. An empty range
+// names the line before it, as diff(1) does.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits text into lines, treating a final newline as a
+// terminator rather than the start of an empty line
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
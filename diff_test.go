@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{
+			name:     "Equal",
+			a:        "x\ny",
+			b:        "x\ny",
+			expected: "",
+		},
+		{
+			name: "Changed line",
+			a:    "a\nb\nc",
+			b:    "a\nB\nc",
+			expected: `--- old
++++ new
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`,
+		},
+		{
+			name: "Added to empty",
+			a:    "",
+			b:    "one\ntwo",
+			expected: `--- old
++++ new
+@@ -0,0 +1,2 @@
++one
++two
+`,
+		},
+		{
+			name: "Separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten",
+			expected: `--- old
++++ new
+@@ -1,2 +1,2 @@
+-1
++one
+ 2
+@@ -9,2 +9,2 @@
+ 9
+-10
++ten
+`,
+		},
+		{
+			name: "Nearby changes share a hunk",
+			a:    "1\n2\n3\n4\n5",
+			b:    "1\ntwo\n3\nfour\n5",
+			expected: `--- old
++++ new
+@@ -1,5 +1,5 @@
+ 1
+-2
++two
+ 3
+-4
++four
+ 5
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := unifiedDiff("old", "new", splitLines(tc.a), splitLines(tc.b), 1)
+			if got != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "script.xml")
+	output := filepath.Join(dir, "script.lua")
+	if err := os.WriteFile(input, []byte(`<set var="x" local="true">42</set>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	// No output file yet: everything is new
+	if code := run([]string{"diff", input}, nil, &stdout, &stderr); code != 1 {
+		t.Fatalf("Expected exit code 1 for missing output, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "--- /dev/null") || !strings.Contains(stdout.String(), "+local x = 42") {
+		t.Errorf("Unexpected diff for missing output:\n%s", stdout.String())
+	}
+
+	// Up to date, including a trailing newline added by an editor
+	if err := os.WriteFile(output, []byte("local x = 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if code := run([]string{"diff", input}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0 when up to date, got %d:\n%s", code, stdout.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Expected no output when up to date, got:\n%s", stdout.String())
+	}
+
+	// Stale output, compared through -o
+	stale := filepath.Join(dir, "stale.lua")
+	if err := os.WriteFile(stale, []byte("local x = 41"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if code := run([]string{"diff", input, "-o", stale}, nil, &stdout, &stderr); code != 1 {
+		t.Fatalf("Expected exit code 1 for stale output, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "-local x = 41\n+local x = 42\n") {
+		t.Errorf("Unexpected diff for stale output:\n%s", stdout.String())
+	}
+	if content, _ := os.ReadFile(stale); string(content) != "local x = 41" {
+		t.Error("Expected diff not to overwrite the output file")
+	}
+}
+
+func TestRunDiffErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{"No input", []string{"diff"}},
+		{"Too many inputs", []string{"diff", "a.xml", "b.xml"}},
+		{"Missing input", []string{"diff", filepath.Join(t.TempDir(), "missing.xml")}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tc.args, nil, &stdout, &stderr); code != 2 {
+				t.Errorf("Expected exit code 2, got %d", code)
+			}
+		})
+	}
+}
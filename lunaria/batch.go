@@ -0,0 +1,139 @@
+package lunaria
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// BatchError reports a file that failed to compile in CompileAll
+type BatchError struct {
+	File string
+	Err  error
+}
+
+// Error implements the error interface
+func (e BatchError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+// Unwrap returns the underlying compilation error
+func (e BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchResult is the outcome of compiling one file of a batch: the path
+// its output was written to, or the error that stopped it
+type BatchResult struct {
+	File   string
+	Output string
+	Err    error
+}
+
+// CompileAll compiles several XML documents in parallel, keyed by file
+// name. Each document is compiled on its own Clone of c, using at most
+// runtime.GOMAXPROCS(0) goroutines. The result holds the Luau output of
+// every file that compiled; the errors of the rest are sorted by file name.
+// Handlers must not be registered on c while CompileAll runs.
+func (c *Compiler) CompileAll(inputs map[string]string) (map[string]string, []BatchError) {
+	files := make(chan string)
+	outputs := make(map[string]string, len(inputs))
+	var errs []BatchError
+	var mu sync.Mutex
+
+	workers := min(runtime.GOMAXPROCS(0), len(inputs))
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				output, err := c.Clone().CompileFromString(inputs[file])
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, BatchError{File: file, Err: err})
+				} else {
+					outputs[file] = output
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for file := range inputs {
+		files <- file
+	}
+	close(files)
+	wg.Wait()
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].File < errs[j].File })
+	return outputs, errs
+}
+
+// CompileAll compiles several XML documents in parallel using the default
+// compiler
+func CompileAll(inputs map[string]string) (map[string]string, []BatchError) {
+	return defaultCompiler.CompileAll(inputs)
+}
+
+// CompileGlob compiles every Lunaria file matching the glob pattern to the
+// .lua file beside it, one after another, and returns the outcome of each
+// in file name order. Unless BaseDir is set, includes resolve relative to
+// each file. The error is only for a malformed pattern or one matching no
+// files.
+func (c *Compiler) CompileGlob(pattern string) ([]BatchResult, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match pattern: %s", pattern)
+	}
+
+	var results []BatchResult
+	for _, filename := range matches {
+		if !IsLunariaFile(filename) {
+			continue
+		}
+
+		result := BatchResult{File: filename}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+
+		compiler := c.Clone()
+		if compiler.options.BaseDir == "" {
+			compiler.options.BaseDir = filepath.Dir(filename)
+		}
+		output, err := compiler.CompileFromString(string(data))
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+
+		outputFile := OutputFilename(filename)
+		if err := WriteFile(outputFile, output); err != nil {
+			result.Err = fmt.Errorf("saving %s: %w", outputFile, err)
+		} else {
+			result.Output = outputFile
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// CompileGlob compiles every Lunaria file matching pattern using the
+// default compiler
+func CompileGlob(pattern string) ([]BatchResult, error) {
+	return defaultCompiler.CompileGlob(pattern)
+}
@@ -0,0 +1,174 @@
+package lunaria
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileAll(t *testing.T) {
+	inputs := map[string]string{
+		"a.xml":      `<set var="a" local="true">1</set>`,
+		"b.xml":      `<print>"b"</print>`,
+		"bad.xml":    `<set var="x"`,
+		"broken.xml": `<unknown-tag/>`,
+	}
+
+	outputs, errs := CompileAll(inputs)
+
+	expected := map[string]string{
+		"a.xml": "local a = 1",
+		"b.xml": `print("b")`,
+	}
+	if len(outputs) != len(expected) {
+		t.Errorf("Expected %d outputs, got: %v", len(expected), outputs)
+	}
+	for file, want := range expected {
+		if outputs[file] != want {
+			t.Errorf("%s: expected %q, got %q", file, want, outputs[file])
+		}
+	}
+
+	if len(errs) != 2 || errs[0].File != "bad.xml" || errs[1].File != "broken.xml" {
+		t.Fatalf("Expected errors for bad.xml and broken.xml, got: %v", errs)
+	}
+	if errs[1].Error() != "broken.xml: unknown tag: unknown-tag" {
+		t.Errorf("Unexpected error message: %s", errs[1].Error())
+	}
+}
+
+func TestCompileAllUsesCompilerHandlers(t *testing.T) {
+	compiler := NewCompilerWithOptions(CompileOptions{Semicolons: true})
+	compiler.Register("ping", func(node Node, c *Compiler) (string, error) {
+		return c.getIndent() + "ping()", nil
+	})
+
+	inputs := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		inputs[fmt.Sprintf("%d.xml", i)] = `<ping/>`
+	}
+	inputs["chunk.xml"] = `<vararg var="x"/>`
+	inputs["nested.xml"] = `<function name="f"><vararg var="x" local="true"/></function>`
+
+	outputs, errs := compiler.CompileAll(inputs)
+	for i := 0; i < 20; i++ {
+		if got := outputs[fmt.Sprintf("%d.xml", i)]; got != "ping();" {
+			t.Errorf("Expected ping();, got %q", got)
+		}
+	}
+
+	if outputs["chunk.xml"] != "x = ...;" {
+		t.Errorf("Expected chunk-level vararg to compile, got %q", outputs["chunk.xml"])
+	}
+
+	// Each file gets a fresh clone, so state cannot leak between files
+	var compileErr *CompileError
+	if len(errs) != 1 || errs[0].File != "nested.xml" || !errors.As(errs[0], &compileErr) {
+		t.Errorf("Expected one wrapped CompileError for nested.xml, got: %v", errs)
+	}
+}
+
+func TestCompileAllEmpty(t *testing.T) {
+	outputs, errs := CompileAll(nil)
+	if len(outputs) != 0 || len(errs) != 0 {
+		t.Errorf("Expected no results, got %v, %v", outputs, errs)
+	}
+}
+
+// benchmarkInputs builds n copies of a representative script
+func benchmarkInputs(n int) map[string]string {
+	inputs := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		inputs[fmt.Sprintf("script%d.xml", i)] = fmt.Sprintf(`<script>
+  <set var="count" local="true">%d</set>
+  <function name="step" params="n">
+    <for var="i" from="1" to="n">
+      <if test="i %% 2 == 0"><set var="count">count + i</set></if>
+    </for>
+    <return>count</return>
+  </function>
+  <print>Count: {{count}}</print>
+</script>`, i)
+	}
+	return inputs
+}
+
+func BenchmarkCompileAll(b *testing.B) {
+	inputs := benchmarkInputs(100)
+
+	b.Run("Parallel", func(b *testing.B) {
+		compiler := NewCompiler()
+		for i := 0; i < b.N; i++ {
+			if _, errs := compiler.CompileAll(inputs); len(errs) != 0 {
+				b.Fatal(errs)
+			}
+		}
+	})
+
+	b.Run("Sequential", func(b *testing.B) {
+		compiler := NewCompiler()
+		for i := 0; i < b.N; i++ {
+			for _, input := range inputs {
+				if _, err := compiler.CompileFromString(input); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func TestCompileGlob(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/a.xml":      `<include src="part.xml"/>`,
+		"src/b.lunaria":  `<bogus/>`,
+		"src/part.xml":   `<set var="x">1</set>`,
+		"src/readme.txt": `not xml`,
+		"other/part.xml": `<set var="x">2</set>`,
+		"src/sub/c.xml":  `<print>1</print>`,
+	})
+
+	results, err := NewCompiler().CompileGlob(filepath.Join(dir, "src", "*"))
+	if err != nil {
+		t.Fatalf("CompileGlob failed: %v", err)
+	}
+
+	src := filepath.Join(dir, "src")
+	expected := []BatchResult{
+		{File: filepath.Join(src, "a.xml"), Output: filepath.Join(src, "a.lua")},
+		{File: filepath.Join(src, "b.lunaria")},
+		{File: filepath.Join(src, "part.xml"), Output: filepath.Join(src, "part.lua")},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got: %v", len(expected), results)
+	}
+	for i, r := range results {
+		if r.File != expected[i].File || r.Output != expected[i].Output || (r.Err != nil) != (expected[i].Output == "") {
+			t.Errorf("Expected %+v, got %+v", expected[i], r)
+		}
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "unknown tag: bogus") {
+		t.Errorf("Expected unknown tag error, got: %v", results[1].Err)
+	}
+	if data, err := os.ReadFile(filepath.Join(src, "a.lua")); err != nil || string(data) != "x = 1" {
+		t.Errorf("Expected a.lua to include src/part.xml, got %q (error: %v)", data, err)
+	}
+
+	// An explicit BaseDir takes precedence over each file's directory
+	compiler := NewCompilerWithOptions(CompileOptions{BaseDir: filepath.Join(dir, "other")})
+	if _, err := compiler.CompileGlob(filepath.Join(src, "a.xml")); err != nil {
+		t.Fatalf("CompileGlob failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(src, "a.lua")); err != nil || string(data) != "x = 2" {
+		t.Errorf("Expected a.lua to include other/part.xml, got %q (error: %v)", data, err)
+	}
+
+	if _, err := CompileGlob(filepath.Join(dir, "*.none")); err == nil {
+		t.Error("Expected an error for a pattern matching no files")
+	}
+	if _, err := CompileGlob("["); err == nil {
+		t.Error("Expected an error for a malformed pattern")
+	}
+}
@@ -0,0 +1,128 @@
+package lunaria
+
+import "fmt"
+
+// bitwiseBinaryOps maps the two-operand bitwise tags to their bit32 function
+var bitwiseBinaryOps = map[string]string{
+	"bit-and":    "band",
+	"bit-or":     "bor",
+	"bit-xor":    "bxor",
+	"bit-lshift": "lshift",
+	"bit-rshift": "rshift",
+}
+
+// registerBitwiseCommands registers commands wrapping the bit32 library
+func (c *Compiler) registerBitwiseCommands() {
+	for tag, fn := range bitwiseBinaryOps {
+		// <bit-and a="x" b="y"/> etc. - bit32.<fn>(a, b)
+		c.RegisterWithSpec(tag, HandlerSpec{
+			Attributes: []string{"a", "b", "var", "local"},
+			Required:   []string{"a", "b"},
+		}, func(node Node, compiler *Compiler) (string, error) {
+			a, err := bitOperand(tag, node, "a")
+			if err != nil {
+				return "", err
+			}
+			b, err := bitOperand(tag, node, "b")
+			if err != nil {
+				return "", err
+			}
+
+			if err := requireBit32(tag, compiler); err != nil {
+				return "", err
+			}
+
+			return compileAssignment(tag, node, compiler, fmt.Sprintf("bit32.%s(%s, %s)", fn, a, b))
+		})
+	}
+
+	// <bit-not> command - bit32.bnot(a)
+	c.RegisterWithSpec("bit-not", HandlerSpec{
+		Attributes: []string{"a", "var", "local"},
+		Required:   []string{"a"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		a, err := bitOperand("bit-not", node, "a")
+		if err != nil {
+			return "", err
+		}
+
+		if err := requireBit32("bit-not", compiler); err != nil {
+			return "", err
+		}
+
+		return compileAssignment("bit-not", node, compiler, fmt.Sprintf("bit32.bnot(%s)", a))
+	})
+
+	// <bit-extract> command - bit32.extract(a, field, width)
+	c.RegisterWithSpec("bit-extract", HandlerSpec{
+		Attributes: []string{"a", "field", "width", "var", "local"},
+		Required:   []string{"a", "field"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		a, err := bitOperand("bit-extract", node, "a")
+		if err != nil {
+			return "", err
+		}
+		field, err := bitOperand("bit-extract", node, "field")
+		if err != nil {
+			return "", err
+		}
+
+		if err := requireBit32("bit-extract", compiler); err != nil {
+			return "", err
+		}
+
+		return compileAssignment("bit-extract", node, compiler, fmt.Sprintf("bit32.extract(%s, %s%s)", a, field, bitWidth(node)))
+	})
+
+	// <bit-replace> command - bit32.replace(a, b, field, width)
+	c.RegisterWithSpec("bit-replace", HandlerSpec{
+		Attributes: []string{"a", "b", "field", "width", "var", "local"},
+		Required:   []string{"a", "b", "field"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		a, err := bitOperand("bit-replace", node, "a")
+		if err != nil {
+			return "", err
+		}
+		b, err := bitOperand("bit-replace", node, "b")
+		if err != nil {
+			return "", err
+		}
+		field, err := bitOperand("bit-replace", node, "field")
+		if err != nil {
+			return "", err
+		}
+
+		if err := requireBit32("bit-replace", compiler); err != nil {
+			return "", err
+		}
+
+		return compileAssignment("bit-replace", node, compiler, fmt.Sprintf("bit32.replace(%s, %s, %s%s)", a, b, field, bitWidth(node)))
+	})
+}
+
+// requireBit32 rejects the bitwise commands for Lua 5.1, which has no bit32
+// library
+func requireBit32(tag string, compiler *Compiler) error {
+	if compiler.targetDialect() == TargetLua51 {
+		return &CompileError{Tag: tag, Message: "bit32 is not available in Lua 5.1"}
+	}
+	return nil
+}
+
+// bitOperand returns a required operand attribute of a bitwise command
+func bitOperand(tag string, node Node, attr string) (string, error) {
+	value := GetAttr(node, attr)
+	if value == "" {
+		return "", fmt.Errorf("%s command requires '%s' attribute", tag, attr)
+	}
+	return value, nil
+}
+
+// bitWidth returns the optional width argument of extract and replace,
+// which bit32 defaults to 1 when omitted
+func bitWidth(node Node) string {
+	if width := GetAttr(node, "width"); width != "" {
+		return ", " + width
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package lunaria
+
+import "testing"
+
+func TestBitwiseCommands(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"And", `<bit-and var="masked" local="true" a="flags" b="0xFF"/>`, "local masked = bit32.band(flags, 0xFF)"},
+		{"Or", `<bit-or var="flags" a="flags" b="FLAG_ADMIN"/>`, "flags = bit32.bor(flags, FLAG_ADMIN)"},
+		{"Xor", `<bit-xor a="x" b="y"/>`, "bit32.bxor(x, y)"},
+		{"Not", `<bit-not var="inverted" local="true" a="mask"/>`, "local inverted = bit32.bnot(mask)"},
+		{"Left shift", `<bit-lshift var="high" local="true" a="value" b="8"/>`, "local high = bit32.lshift(value, 8)"},
+		{"Right shift", `<bit-rshift var="low" a="value" b="24"/>`, "low = bit32.rshift(value, 24)"},
+		{"Extract", `<bit-extract var="red" local="true" a="color" field="16" width="8"/>`, "local red = bit32.extract(color, 16, 8)"},
+		{"Extract single bit", `<bit-extract a="flags" field="3"/>`, "bit32.extract(flags, 3)"},
+		{"Replace", `<bit-replace var="color" a="color" b="blue" field="0" width="8"/>`, "color = bit32.replace(color, blue, 0, 8)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestBitwiseErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Missing b", `<bit-and a="x"/>`},
+		{"Missing a", `<bit-not var="y"/>`},
+		{"Missing field", `<bit-extract a="x" width="2"/>`},
+		{"Replace missing value", `<bit-replace a="x" field="0"/>`},
+		{"Invalid var", `<bit-or var="1x" a="x" b="y"/>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+}
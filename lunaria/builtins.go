@@ -1,7 +1,11 @@
 package lunaria
 
 import (
+	"encoding/xml"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -13,22 +17,47 @@ func (c *Compiler) registerBuiltins() {
 	c.registerDataCommands()
 	c.registerIOCommands()
 	c.registerUtilityCommands()
+	c.registerFunctionalCommands()
+	c.registerStringCommands()
+	c.registerBitwiseCommands()
+	c.registerIncludeCommands()
+	c.registerRobloxCommands()
+	c.registerMacroCommands()
+	c.registerModuleCommands()
 }
 
 // registerVariableCommands registers variable-related commands
 func (c *Compiler) registerVariableCommands() {
 	// <set> command
-	c.Register("set", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("set", HandlerSpec{
+		Attributes: []string{"var", "vars", "local", "scope", "suppress-warning"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		if HasAttr(node, "vars") {
+			return compileMultiSet(node, compiler)
+		}
+
+		switch scope := GetAttr(node, "scope"); scope {
+		case "", "local":
+		case "global":
+			if GetBoolAttr(node, "local") {
+				return "", fmt.Errorf("set command cannot be both local and global")
+			}
+			return compileGlobal("set", node, compiler)
+		default:
+			return "", fmt.Errorf("set command has unknown scope: %s", scope)
+		}
+
 		varName := GetAttr(node, "var")
 		if varName == "" {
 			return "", fmt.Errorf("set command requires 'var' attribute")
 		}
 
-		if !IsValidIdentifier(varName) {
-			return "", fmt.Errorf("invalid variable name: %s", varName)
+		isLocal := GetBoolAttr(node, "local") || GetAttr(node, "scope") == "local"
+		if err := checkSetTarget(varName, isLocal); err != nil {
+			return "", err
 		}
 
-		isLocal := GetBoolAttr(node, "local")
 		value := strings.TrimSpace(node.Content)
 
 		if value == "" {
@@ -38,99 +67,1067 @@ func (c *Compiler) registerVariableCommands() {
 		prefix := ""
 		if isLocal {
 			prefix = "local "
+			compiler.declareLocal("set", varName)
 		}
 
 		return fmt.Sprintf("%s%s%s = %s", compiler.getIndent(), prefix, varName, value), nil
 	})
-}
 
-// registerControlFlowCommands registers control flow commands
-func (c *Compiler) registerControlFlowCommands() {
-	// <if> command
-	c.Register("if", func(node Node, compiler *Compiler) (string, error) {
-		test := GetAttr(node, "test")
-		if test == "" {
-			return "", fmt.Errorf("if command requires 'test' attribute")
+	// <global> command - explicit global assignment, discouraged in Luau
+	c.RegisterWithSpec("global", HandlerSpec{
+		Attributes: []string{"var", "suppress-warning"},
+		Required:   []string{"var"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		return compileGlobal("global", node, compiler)
+	})
+
+	// <lazy> command - x = x or default, for lazy initialization and
+	// defaulting optional parameters. The default also replaces a value of
+	// false, so the idiom does not suit variables that may be false.
+	c.RegisterWithSpec("lazy", HandlerSpec{
+		Attributes: []string{"var", "default", "local"},
+		Required:   []string{"var", "default"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		varName := GetAttr(node, "var")
+		if varName == "" {
+			return "", fmt.Errorf("lazy command requires 'var' attribute")
+		}
+		value := GetAttr(node, "default")
+		if value == "" {
+			return "", fmt.Errorf("lazy command requires 'default' attribute")
 		}
 
-		result := fmt.Sprintf("%sif %s then\n", compiler.getIndent(), test)
+		isLocal := GetBoolAttr(node, "local")
+		switch {
+		case isLocal && !IsValidIdentifier(varName):
+			return "", fmt.Errorf("invalid variable name: %s", varName)
+		case !IsValidLValue(varName):
+			return "", fmt.Errorf("invalid assignment target: %s", varName)
+		}
 
-		compiler.indent++
+		prefix := ""
+		if isLocal {
+			prefix = "local "
+			compiler.declareLocal("lazy", varName)
+		}
+
+		return fmt.Sprintf("%s%s%s = %s or %s", compiler.getIndent(), prefix, varName, varName, value), nil
+	})
+
+	// <unset> command - assigns nil to a variable or table field
+	c.RegisterWithSpec("unset", HandlerSpec{
+		Attributes: []string{"var"},
+		Required:   []string{"var"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		target := GetAttr(node, "var")
+		if target == "" {
+			return "", fmt.Errorf("unset command requires 'var' attribute")
+		}
+
+		if !IsValidLValue(target) {
+			return "", fmt.Errorf("invalid assignment target: %s", target)
+		}
+
+		return fmt.Sprintf("%s%s = nil", compiler.getIndent(), target), nil
+	})
+
+	// <destructure> command - extracts table fields into variables
+	c.RegisterWithSpec("destructure", HandlerSpec{
+		Attributes: []string{"from", "local"},
+		Required:   []string{"from"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		from := GetAttr(node, "from")
+		if from == "" {
+			return "", fmt.Errorf("destructure command requires 'from' attribute")
+		}
+
+		defaultLocal := GetBoolAttr(node, "local")
+		isLocal := defaultLocal
+		var lines []string
 		for _, child := range node.Nodes {
-			childCode, err := compiler.compileNode(child)
+			if child.XMLName.Local != "bind" {
+				continue
+			}
+
+			field := GetAttr(child, "field")
+			varName := GetAttrWithDefault(child, "var", field)
+			if field == "" {
+				return "", fmt.Errorf("bind requires 'field' attribute")
+			}
+			if !IsValidIdentifier(varName) {
+				return "", fmt.Errorf("invalid variable name: %s", varName)
+			}
+
+			// Bindings may override 'local' but must all agree
+			bindLocal := defaultLocal
+			if HasAttr(child, "local") {
+				bindLocal = GetBoolAttr(child, "local")
+			}
+			if len(lines) == 0 {
+				isLocal = bindLocal
+			} else if bindLocal != isLocal {
+				return "", fmt.Errorf("destructure cannot mix local and non-local bindings")
+			}
+
+			access, err := fieldAccess(from, field)
 			if err != nil {
 				return "", err
 			}
-			if childCode != "" {
-				result += childCode + "\n"
+
+			prefix := ""
+			if isLocal {
+				prefix = "local "
+				compiler.declareLocal("destructure", varName)
 			}
+			lines = append(lines, fmt.Sprintf("%s%s%s = %s%s", compiler.getIndent(), prefix, varName, access, compiler.terminator()))
 		}
-		compiler.indent--
 
-		result += compiler.getIndent() + "end"
-		return result, nil
+		if len(lines) == 0 {
+			return "", fmt.Errorf("destructure command requires at least one <bind>")
+		}
+
+		return strings.Join(lines, "\n"), nil
+	})
+
+	// <bind> command (used within destructure blocks)
+	c.RegisterWithSpec("bind", HandlerSpec{
+		Attributes: []string{"field", "var", "local", "name", "expr"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		// Bindings are processed by the parent command
+		return "", nil
 	})
 
-	// <elseif> command (used within if blocks)
-	c.Register("elseif", func(node Node, compiler *Compiler) (string, error) {
+	// <augmented-assign> command - x = x op value
+	c.RegisterWithSpec("augmented-assign", HandlerSpec{
+		Attributes: []string{"var", "op", "value"},
+		Required:   []string{"var", "op", "value"},
+	}, augmentedAssign("augmented-assign", "", ""))
+
+	// <increment> command - x = x + 1
+	c.RegisterWithSpec("increment", HandlerSpec{
+		Attributes: []string{"var", "op", "value"},
+		Required:   []string{"var"},
+	}, augmentedAssign("increment", "+", "1"))
+
+	// <decrement> command - x = x - 1
+	c.RegisterWithSpec("decrement", HandlerSpec{
+		Attributes: []string{"var", "op", "value"},
+		Required:   []string{"var"},
+	}, augmentedAssign("decrement", "-", "1"))
+}
+
+// compileGlobal compiles an explicit global write to an identifier or to a
+// field such as _G.Config. Bare globals are usually accidental, so they
+// warn unless suppress-warning="true"; writes through _G are explicit
+// enough already and never warn.
+func compileGlobal(tag string, node Node, compiler *Compiler) (string, error) {
+	target := GetAttr(node, "var")
+	if target == "" {
+		return "", fmt.Errorf("%s command requires 'var' attribute", tag)
+	}
+
+	if !IsValidLValue(target) {
+		return "", fmt.Errorf("invalid assignment target: %s", target)
+	}
+
+	value := strings.TrimSpace(node.Content)
+	if value == "" {
+		return "", fmt.Errorf("%s command requires a value", tag)
+	}
+
+	viaG := strings.HasPrefix(target, "_G.") || strings.HasPrefix(target, "_G[")
+	if !viaG && !GetBoolAttr(node, "suppress-warning") {
+		compiler.warn(tag, "'%s' is assigned as a global; prefer a local unless it must be shared", target)
+	}
+
+	return fmt.Sprintf("%s%s = %s", compiler.getIndent(), target, value), nil
+}
+
+// interpolateString compiles text with placeholders found by p into a
+// string literal, one line per source line with the XML indentation
+// removed. It emits a Luau backtick string when backticks is set and a
+// tostring concatenation otherwise.
+func interpolateString(text string, backticks bool, p *placeholders) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+
+	if backticks {
+		return "`" + p.interpolateBackticks(text) + "`"
+	}
+	return `"` + p.interpolate(text) + `"`
+}
+
+// compileMultiSet compiles <set vars="a, b">1, 2</set> into a multiple
+// assignment. Targets and values must pair up, except that a call or ...
+// in last position may supply all of the remaining values.
+func compileMultiSet(node Node, compiler *Compiler) (string, error) {
+	if HasAttr(node, "var") {
+		return "", fmt.Errorf("set command cannot have both 'var' and 'vars' attributes")
+	}
+
+	targets := SplitParameters(GetAttr(node, "vars"))
+	if len(targets) == 0 {
+		return "", fmt.Errorf("set command requires at least one name in 'vars'")
+	}
+	isLocal := GetBoolAttr(node, "local")
+	for _, target := range targets {
+		if err := checkSetTarget(target, isLocal); err != nil {
+			return "", err
+		}
+	}
+
+	values := SplitParameters(strings.TrimSpace(node.Content))
+	if len(values) == 0 {
+		return "", fmt.Errorf("set command requires a value")
+	}
+	if len(values) > len(targets) || (len(values) < len(targets) && !isMultiValue(values[len(values)-1])) {
+		return "", fmt.Errorf("set command assigns %d values to %d variables", len(values), len(targets))
+	}
+
+	prefix := ""
+	if isLocal {
+		prefix = "local "
+		for _, target := range targets {
+			compiler.declareLocal("set", target)
+		}
+	}
+
+	return fmt.Sprintf("%s%s%s = %s", compiler.getIndent(), prefix, strings.Join(targets, ", "), strings.Join(values, ", ")), nil
+}
+
+// checkSetTarget validates an assignment target of <set>. Table fields
+// such as t.field or t["key"] can be assigned, but only a plain name can
+// be declared local.
+func checkSetTarget(target string, isLocal bool) error {
+	if IsValidIdentifier(target) {
+		return nil
+	}
+	if !IsValidLValue(target) {
+		return fmt.Errorf("invalid variable name: %s", target)
+	}
+	if isLocal {
+		return fmt.Errorf("set command cannot declare table field %s as local", target)
+	}
+	return nil
+}
+
+// isMultiValue reports whether expr can produce several values: a vararg
+// or a function call. A parenthesized expression such as (f()) is truncated
+// to one value by Luau and so does not count.
+func isMultiValue(expr string) bool {
+	if expr == "..." {
+		return true
+	}
+	return finalCallParen(expr) > 0
+}
+
+// finalCallParen returns the index of the '(' matching the ')' that ends
+// expr, or -1 if expr does not end with a balanced ')'
+func finalCallParen(expr string) int {
+	if !strings.HasSuffix(expr, ")") {
+		return -1
+	}
+
+	var opens []int
+	var inString bool
+	var stringChar byte
+	last := -1
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == stringChar {
+				inString = false
+			}
+		case c == '"' || c == '\'':
+			inString = true
+			stringChar = c
+		case c == '(':
+			opens = append(opens, i)
+		case c == ')':
+			if len(opens) == 0 {
+				return -1
+			}
+			last = opens[len(opens)-1]
+			opens = opens[:len(opens)-1]
+		}
+	}
+	return last
+}
+
+// prefixExpression returns expr in a form that can be indexed or called,
+// such as the object of a method call: names, field accesses and calls are
+// kept as they are and anything else is parenthesized
+func prefixExpression(expr string) string {
+	if isPrefixExpression(expr) {
+		return expr
+	}
+	return "(" + expr + ")"
+}
+
+// isPrefixExpression reports whether expr is an lvalue, a parenthesized
+// expression or a function or method call on one
+func isPrefixExpression(expr string) bool {
+	if IsValidLValue(expr) {
+		return true
+	}
+
+	open := finalCallParen(expr)
+	switch {
+	case open == 0:
+		return true
+	case open < 0:
+		return false
+	}
+
+	head := strings.TrimSpace(expr[:open])
+	if colon := strings.LastIndex(head, ":"); colon != -1 && IsValidIdentifier(head[colon+1:]) {
+		head = head[:colon]
+	}
+	return isPrefixExpression(head)
+}
+
+// appendVararg adds ... to a parameter list. When any parameter carries a
+// type annotation the vararg is typed too, as varType or any.
+func appendVararg(params, varType string) (string, error) {
+	list := SplitParameters(params)
+	if hasVararg(params) {
+		return "", fmt.Errorf("function parameters already end with ...")
+	}
+
+	typed := varType != ""
+	for _, param := range list {
+		if strings.Contains(param, ":") {
+			typed = true
+		}
+	}
+
+	vararg := "..."
+	if typed {
+		if varType == "" {
+			varType = "any"
+		}
+		vararg += ": " + varType
+	}
+	return strings.Join(append(list, vararg), ", "), nil
+}
+
+// hasVararg reports whether a parameter list ends with ...
+func hasVararg(params string) bool {
+	list := SplitParameters(params)
+	return len(list) > 0 && strings.HasPrefix(list[len(list)-1], "...")
+}
+
+// outputArg converts the content of an output command to its argument: a
+// string when it has {{...}} placeholders, otherwise the expression as
+// written. Both ways, control characters in string literals are escaped.
+func outputArg(content string, compiler *Compiler) string {
+	if compiler.interpolation().in(content) {
+		return interpolateString(content, compiler.options.BacktickStrings && compiler.targetDialect() == TargetLuau, compiler.interpolation())
+	}
+	return EscapeLiterals(content)
+}
+
+// errorMessage converts the content of an <error> to its message. Content
+// with placeholders is interpolated, and a single string literal or a
+// tostring or string.format call is passed through. Any other content is
+// the text of the message and is quoted, unless expr="true" marks it as an
+// expression to pass as written.
+func errorMessage(content string, node Node, compiler *Compiler) string {
+	if compiler.interpolation().in(content) || isStringExpression(content) || GetBoolAttr(node, "expr") {
+		return outputArg(content, compiler)
+	}
+	return interpolateString(content, false, compiler.interpolation())
+}
+
+// compileOutputCommand compiles a call to the output function name. Content
+// with {{...}} placeholders becomes an interpolated string; other content
+// is passed through as an expression. <print> and <warn> may instead take
+// separate <arg> children. <error> also takes a stack 'level' (default 1)
+// and can re-raise a caught error held in 'var' instead of taking content.
+func compileOutputCommand(name string, node Node, compiler *Compiler) (string, error) {
+	content := strings.TrimSpace(node.Content)
+	if name != "error" && len(node.Nodes) > 0 {
+		return compileOutputArgs(name, node, compiler)
+	}
+	reraise := name == "error" && HasAttr(node, "var")
+
+	switch {
+	case reraise && content != "":
+		return "", fmt.Errorf("error command cannot have both 'var' attribute and content")
+	case reraise:
+		content = GetAttr(node, "var")
+		if !IsValidIdentifier(content) {
+			return "", fmt.Errorf("invalid variable name: %s", content)
+		}
+	case content == "" && name == "error":
+		return "", fmt.Errorf("error command requires content or 'var' attribute")
+	case content == "":
+		return "", fmt.Errorf("%s command requires content", name)
+	}
+
+	args := content
+	if !reraise {
+		if err := compiler.checkInterpolation(name, content); err != nil {
+			return "", err
+		}
+		if name == "error" {
+			args = errorMessage(content, node, compiler)
+		} else {
+			args = outputArg(content, compiler)
+		}
+	}
+
+	if name == "error" {
+		args += ", " + GetAttrWithDefault(node, "level", "1")
+	}
+
+	return fmt.Sprintf("%s%s(%s)", compiler.getIndent(), name, args), nil
+}
+
+// compileOutputArgs compiles a <print> or <warn> with <arg> children into a
+// call passing each as its own argument. Any content is passed first, as an
+// expression like the content of <call>.
+func compileOutputArgs(name string, node Node, compiler *Compiler) (string, error) {
+	var args []string
+	if content := strings.TrimSpace(node.Content); content != "" {
+		args = append(args, content)
+	}
+	for _, child := range node.Nodes {
+		if child.XMLName.Local != "arg" {
+			return "", fmt.Errorf("%s command can only have <arg> children, got <%s>", name, child.XMLName.Local)
+		}
+	}
+	childArgs, err := compileArgs(node, compiler, false)
+	if err != nil {
+		return "", err
+	}
+	args = append(args, childArgs...)
+	if len(args) == 0 {
+		return "", fmt.Errorf("%s command requires content", name)
+	}
+
+	for i, arg := range args {
+		args[i] = EscapeLiterals(arg)
+	}
+	return fmt.Sprintf("%s%s(%s)", compiler.getIndent(), name, JoinWithCommas(args)), nil
+}
+
+// compileValue returns the expression held by node: either its trimmed
+// content or its single child command compiled inline, such as a nested
+// <table>, <array> or lambda <function>
+func compileValue(node Node, compiler *Compiler) (string, error) {
+	content := strings.TrimSpace(node.Content)
+
+	switch len(node.Nodes) {
+	case 0:
+		return content, nil
+	case 1:
+		if content != "" {
+			return "", fmt.Errorf("%s cannot have both content and a child element", node.XMLName.Local)
+		}
+		return compiler.compileNode(node.Nodes[0])
+	default:
+		return "", fmt.Errorf("%s can have at most one child element", node.XMLName.Local)
+	}
+}
+
+// compileBranch compiles an <elseif> or <else> branch of an <if>: its
+// header at the if's indentation followed by its statements
+func compileBranch(node Node, compiler *Compiler) (string, error) {
+	tag := node.XMLName.Local
+
+	var result string
+	if tag == "elseif" {
 		test := GetAttr(node, "test")
 		if test == "" {
 			return "", fmt.Errorf("elseif command requires 'test' attribute")
 		}
+		result = fmt.Sprintf("%selseif %s then\n", compiler.getIndent(), test)
+	} else {
+		result = compiler.getIndent() + "else\n"
+	}
+
+	warnStrayText(tag, node, compiler)
+
+	compiler.indent++
+	compiler.pushScope()
+	body, err := compiler.CompileChildren(node)
+	if err != nil {
+		return "", err
+	}
+	if body != "" {
+		result += body + "\n"
+	}
+	compiler.popScope()
+	compiler.indent--
+
+	return result, nil
+}
 
-		result := fmt.Sprintf("%selseif %s then\n", compiler.getIndent(), test)
+// compileFunctionBody compiles the children of node as the body of a
+// function taking params, one level deeper than the current indentation.
+// The result is empty or ends with a newline.
+func compileFunctionBody(node Node, compiler *Compiler, params string) (string, error) {
+	// Loops outside the function do not enclose its body
+	outerLoopDepth := compiler.loopDepth
+	outerVararg := compiler.inVarargFunction
+	compiler.loopDepth = 0
+	compiler.inVarargFunction = hasVararg(params)
+	compiler.functionDepth++
+	compiler.indent++
+	compiler.pushScope()
+	body, err := compiler.CompileChildren(node)
+	if err != nil {
+		return "", err
+	}
+	if body != "" {
+		body += "\n"
+	}
+	compiler.popScope()
+	compiler.indent--
+	compiler.functionDepth--
+	compiler.inVarargFunction = outerVararg
+	compiler.loopDepth = outerLoopDepth
+	return body, nil
+}
 
-		compiler.indent++
-		for _, child := range node.Nodes {
-			childCode, err := compiler.compileNode(child)
-			if err != nil {
-				return "", err
+// compileArgs compiles the <arg> children of node in order. An <arg> holds
+// either an expression as text or a single expression tag such as <ipairs>.
+// When allowNamed is set and the args carry a 'name' they are instead
+// gathered into a single options table argument, { name = value, ... }.
+// Named args cannot be mixed with positional ones, including the content of
+// node, and must have a value.
+func compileArgs(node Node, compiler *Compiler, allowNamed bool) ([]string, error) {
+	var args, fields []string
+	named := map[string]bool{}
+	positional := strings.TrimSpace(node.Content) != ""
+	for _, child := range node.Nodes {
+		if child.XMLName.Local != "arg" {
+			continue
+		}
+
+		name := GetAttr(child, "name")
+		if HasAttr(child, "name") {
+			if !allowNamed {
+				return nil, fmt.Errorf("%s does not accept named arguments", node.XMLName.Local)
 			}
-			if childCode != "" {
-				result += childCode + "\n"
+			if !IsValidIdentifier(name) {
+				return nil, fmt.Errorf("invalid argument name: %s", name)
 			}
+			if named[name] {
+				return nil, fmt.Errorf("%s has duplicate argument '%s'", node.XMLName.Local, name)
+			}
+			named[name] = true
+		} else {
+			positional = true
+		}
+		if positional && len(named) > 0 {
+			return nil, fmt.Errorf("%s cannot mix named and positional arguments", node.XMLName.Local)
 		}
-		compiler.indent--
 
-		return result, nil
-	})
+		arg, err := compileValue(child, compiler)
+		if err != nil {
+			return nil, err
+		}
+		if arg == "" {
+			if name != "" {
+				return nil, fmt.Errorf("%s argument '%s' requires a value", node.XMLName.Local, name)
+			}
+			continue
+		}
+		if name != "" {
+			fields = append(fields, name+" = "+arg)
+		} else {
+			args = append(args, arg)
+		}
+	}
+
+	if len(named) > 0 {
+		return []string{"{ " + strings.Join(fields, ", ") + " }"}, nil
+	}
+	return args, nil
+}
+
+// hasNamedArgs reports whether any <arg> child of node has a 'name'
+func hasNamedArgs(node Node) bool {
+	for _, child := range node.Nodes {
+		if child.XMLName.Local == "arg" && HasAttr(child, "name") {
+			return true
+		}
+	}
+	return false
+}
+
+// genericForNames returns the loop variables of a generic <for>: 'key'
+// and 'value', with _ standing in for a missing key, or the deprecated
+// comma-separated 'var' such as var="k, v"
+func genericForNames(node Node, compiler *Compiler) ([]string, error) {
+	var names []string
+	if varName := GetAttr(node, "var"); varName != "" {
+		names = SplitParameters(varName)
+		if len(names) > 1 {
+			compiler.warn("for", "var=\"%s\" is deprecated; use the 'key' and 'value' attributes", varName)
+		}
+	} else {
+		key := GetAttrWithDefault(node, "key", "_")
+		names = []string{key}
+		if HasAttr(node, "value") {
+			names = append(names, GetAttr(node, "value"))
+		}
+	}
+
+	for _, name := range names {
+		if !IsValidIdentifier(name) {
+			return nil, fmt.Errorf("invalid variable name: %s", name)
+		}
+	}
+	return names, nil
+}
+
+// checkForBounds statically checks numeric for loop bounds when they are
+// all literals. A zero step never terminates and is rejected; a step that
+// moves away from the limit only warns, since the loop is merely dead code.
+func checkForBounds(compiler *Compiler, from, to, step string) error {
+	if !IsNumberLiteral(step) {
+		return nil
+	}
+
+	stepVal := ParseFloat(step)
+	if stepVal == 0 {
+		return fmt.Errorf("for command 'step' must not be zero")
+	}
+
+	if !IsNumberLiteral(from) || !IsNumberLiteral(to) {
+		return nil
+	}
+
+	fromVal, toVal := ParseFloat(from), ParseFloat(to)
+	switch {
+	case stepVal > 0 && fromVal > toVal:
+		compiler.warn("for", "loop from %s to %s with step %s never executes; use step=\"-%s\" to count down", from, to, step, step)
+	case stepVal < 0 && fromVal < toVal:
+		compiler.warn("for", "loop from %s to %s with step %s never executes", from, to, step)
+	}
+	return nil
+}
+
+// isDescendingRange reports whether from and to are number literals with
+// from above to
+func isDescendingRange(from, to string) bool {
+	return IsNumberLiteral(from) && IsNumberLiteral(to) && ParseFloat(from) > ParseFloat(to)
+}
+
+// templatePlaceholder matches {name} placeholders in <string-interpolate>
+// templates
+var templatePlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// tableEntry is a keyed <entry> or, when key is empty, a positional <item>
+// of a <table>
+type tableEntry struct {
+	key   string
+	value string
+}
+
+// compileTableBody compiles the <entry> and <item> children of node into a
+// table constructor, keeping keyed and positional values in source order.
+// With sort="keys" positional values come first in source order, followed
+// by keyed entries ordered by key: numeric keys in numeric order, then all
+// other keys lexically.
+func compileTableBody(node Node, compiler *Compiler) (string, error) {
+	// Values are compiled at entry indentation so nested tables line up
+	compiler.indent++
+	var entries []tableEntry
+	for _, child := range node.Nodes {
+		var key string
+		switch child.XMLName.Local {
+		case "entry":
+			if key = GetAttr(child, "key"); key == "" {
+				continue
+			}
+		case "item":
+		default:
+			continue
+		}
+
+		value, err := compileValue(child, compiler)
+		if err != nil {
+			return "", err
+		}
+		if value != "" {
+			entries = append(entries, tableEntry{key: key, value: value})
+		}
+	}
+	compiler.indent--
+
+	switch order := GetAttr(node, "sort"); order {
+	case "":
+	case "keys":
+		sort.SliceStable(entries, func(i, j int) bool {
+			a, b := entries[i].key, entries[j].key
+			if a == "" || b == "" {
+				return a == "" && b != ""
+			}
+			return lessTableKey(a, b)
+		})
+	default:
+		return "", fmt.Errorf("table command has unknown sort order: %s", order)
+	}
+
+	if len(entries) == 0 {
+		return "{}", nil
+	}
+
+	compiler.indent++
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		if entry.key == "" {
+			lines[i] = compiler.getIndent() + entry.value
+		} else if IsValidIdentifier(entry.key) {
+			lines[i] = fmt.Sprintf("%s%s = %s", compiler.getIndent(), entry.key, entry.value)
+		} else {
+			lines[i] = fmt.Sprintf("%s[%s] = %s", compiler.getIndent(), WrapInQuotes(entry.key), entry.value)
+		}
+	}
+	compiler.indent--
+
+	body := strings.Join(lines, ",\n")
+	if !compiler.options.NoTrailingComma {
+		body += ","
+	}
+
+	return "{\n" + body + "\n" + compiler.getIndent() + "}", nil
+}
+
+// lessTableKey orders numeric keys numerically before all other keys,
+// which are compared lexically
+func lessTableKey(a, b string) bool {
+	aNumeric, bNumeric := IsNumberLiteral(a), IsNumberLiteral(b)
+	switch {
+	case aNumeric && bNumeric:
+		return ParseFloat(a) < ParseFloat(b)
+	case aNumeric != bNumeric:
+		return aNumeric
+	default:
+		return a < b
+	}
+}
+
+// fieldAccess appends a dotted field path such as "pos.x" to base, using
+// bracket indexing for segments that are not identifiers
+func fieldAccess(base, path string) (string, error) {
+	result := base
+	for _, part := range strings.Split(path, ".") {
+		switch {
+		case part == "":
+			return "", fmt.Errorf("invalid field path: %s", path)
+		case IsValidIdentifier(part):
+			result += "." + part
+		case IsNumberLiteral(part):
+			result += "[" + part + "]"
+		default:
+			result += `["` + EscapeString(part) + `"]`
+		}
+	}
+	return result, nil
+}
+
+// primitiveTypes are the names type and typeof return for Luau values
+var primitiveTypes = map[string]bool{
+	"nil": true, "boolean": true, "number": true, "string": true, "table": true,
+	"function": true, "thread": true, "userdata": true, "buffer": true, "vector": true,
+}
+
+// robloxTypes are the Roblox datatype names typeof returns
+var robloxTypes = map[string]bool{
+	"Instance": true, "Vector2": true, "Vector3": true, "Vector2int16": true, "Vector3int16": true,
+	"CFrame": true, "Color3": true, "ColorSequence": true, "ColorSequenceKeypoint": true,
+	"BrickColor": true, "UDim": true, "UDim2": true, "Rect": true, "Region3": true,
+	"Ray": true, "NumberRange": true, "NumberSequence": true, "NumberSequenceKeypoint": true,
+	"TweenInfo": true, "Enum": true, "EnumItem": true, "Enums": true, "Axes": true,
+	"Faces": true, "PhysicalProperties": true, "Random": true, "DateTime": true,
+	"RBXScriptSignal": true, "RBXScriptConnection": true, "Font": true, "Content": true,
+}
+
+// lua51Functions maps Luau library functions to their Lua 5.1 names
+var lua51Functions = map[string]string{
+	"table.unpack": "unpack",
+	"typeof":       "type",
+}
+
+// lua51Call rewrites a <call> of a Luau-only function for Lua 5.1.
+// task.spawn(f, ...) runs f as a coroutine, like coroutine.wrap(f)(...);
+// the rest of the task library has no equivalent.
+func lua51Call(name string, args []string) (string, []string, error) {
+	if renamed, ok := lua51Functions[name]; ok {
+		return renamed, args, nil
+	}
+	if name == "task.spawn" {
+		if len(args) == 0 {
+			return "", nil, &CompileError{Tag: "call", Message: "task.spawn requires a function argument"}
+		}
+		return fmt.Sprintf("coroutine.wrap(%s)", args[0]), args[1:], nil
+	}
+	if strings.HasPrefix(name, "task.") {
+		return "", nil, &CompileError{Tag: "call", Message: fmt.Sprintf("%s is not available in Lua 5.1", name)}
+	}
+	return name, args, nil
+}
+
+// decimalPattern matches an unsigned decimal number with an optional
+// fraction and exponent, such as 42, 3.14, .5 or 1e6
+var decimalPattern = regexp.MustCompile(`^(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// formatNumber rewrites the number literal value in base "dec", "hex" or
+// "bin", or in its own notation when base is empty, inserting an
+// underscore every group digits of the integer part when group > 0.
+// Only integers can change base.
+func formatNumber(value, base string, group int) (string, error) {
+	digits := strings.ReplaceAll(strings.TrimSpace(value), "_", "")
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	notation := "dec"
+	var n uint64
+	var err error
+	switch lower := strings.ToLower(digits); {
+	case strings.HasPrefix(lower, "0x"):
+		notation = "hex"
+		n, err = strconv.ParseUint(lower[2:], 16, 64)
+	case strings.HasPrefix(lower, "0b"):
+		notation = "bin"
+		n, err = strconv.ParseUint(lower[2:], 2, 64)
+	case decimalPattern.MatchString(digits):
+		n, err = strconv.ParseUint(digits, 10, 64)
+	default:
+		return "", fmt.Errorf("invalid number: %s", value)
+	}
+	isInteger := err == nil
+	if !isInteger && notation != "dec" {
+		return "", fmt.Errorf("invalid number: %s", value)
+	}
+
+	if base == "" {
+		base = notation
+	}
+	prefix := ""
+	switch base {
+	case "dec":
+		if isInteger {
+			digits = strconv.FormatUint(n, 10)
+		}
+	case "hex", "bin":
+		if !isInteger {
+			return "", fmt.Errorf("number %s cannot be written in %s as it is not an integer", value, base)
+		}
+		if base == "hex" {
+			prefix, digits = "0x", strings.ToUpper(strconv.FormatUint(n, 16))
+		} else {
+			prefix, digits = "0b", strconv.FormatUint(n, 2)
+		}
+	default:
+		return "", fmt.Errorf("number command has unknown base: %s (expected dec, hex or bin)", base)
+	}
+
+	if group > 0 {
+		// Only the integer part is grouped, e.g. 1_000.5 or 1_000e3
+		end := -1
+		if base == "dec" {
+			end = strings.IndexAny(digits, ".eE")
+		}
+		if end == -1 {
+			end = len(digits)
+		}
+		digits = groupDigits(digits[:end], group) + digits[end:]
+	}
+	return sign + prefix + digits, nil
+}
+
+// groupDigits inserts an underscore every size digits, counting from the right
+func groupDigits(digits string, size int) string {
+	var b strings.Builder
+	for i, r := range digits {
+		if i > 0 && (len(digits)-i)%size == 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// augmentedOperators lists the binary operators accepted by <augmented-assign>
+var augmentedOperators = []string{"+", "-", "*", "/", "..", "%", "^"}
+
+// augmentedAssign builds a handler emitting `var = var op value`, since
+// Luau has no compound assignment operators
+func augmentedAssign(tag, defaultOp, defaultValue string) Handler {
+	return func(node Node, compiler *Compiler) (string, error) {
+		varName := GetAttr(node, "var")
+		if varName == "" {
+			return "", fmt.Errorf("%s command requires 'var' attribute", tag)
+		}
+
+		if !IsValidIdentifier(varName) {
+			return "", fmt.Errorf("invalid variable name: %s", varName)
+		}
+
+		op := GetAttrWithDefault(node, "op", defaultOp)
+		if op == "" {
+			return "", fmt.Errorf("%s command requires 'op' attribute", tag)
+		}
+
+		valid := false
+		for _, candidate := range augmentedOperators {
+			if op == candidate {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", fmt.Errorf("%s command has unknown operator: %s", tag, op)
+		}
 
-	// <else> command (used within if blocks)
-	c.Register("else", func(node Node, compiler *Compiler) (string, error) {
-		result := fmt.Sprintf("%selse\n", compiler.getIndent())
+		value := GetAttrWithDefault(node, "value", defaultValue)
+		if value == "" {
+			return "", fmt.Errorf("%s command requires 'value' attribute", tag)
+		}
+
+		return fmt.Sprintf("%s%s = %s %s %s", compiler.getIndent(), varName, varName, op, value), nil
+	}
+}
+
+// registerControlFlowCommands registers control flow commands
+func (c *Compiler) registerControlFlowCommands() {
+	// <if> command - consumes its <elseif> and <else> children as the
+	// branches of one if chain. The then branch is either the statements
+	// before them or wrapped in a <then> section.
+	c.RegisterWithSpec("if", HandlerSpec{
+		Attributes: []string{"test"},
+		Required:   []string{"test"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		test := GetAttr(node, "test")
+		if test == "" {
+			return "", fmt.Errorf("if command requires 'test' attribute")
+		}
+
+		result := fmt.Sprintf("%sif %s then\n", compiler.getIndent(), test)
+
+		warnStrayText("if", node, compiler)
 
 		compiler.indent++
+		compiler.pushScope()
+		branch := "if"
 		for _, child := range node.Nodes {
-			childCode, err := compiler.compileNode(child)
-			if err != nil {
-				return "", err
-			}
-			if childCode != "" {
-				result += childCode + "\n"
+			switch child.XMLName.Local {
+			case "elseif", "else":
+				if branch == "else" {
+					return "", fmt.Errorf("%s cannot follow else in an if block", child.XMLName.Local)
+				}
+				branch = child.XMLName.Local
+
+				compiler.popScope()
+				compiler.indent--
+				header, err := compileBranch(child, compiler)
+				if err != nil {
+					return "", err
+				}
+				result += header
+				compiler.indent++
+				compiler.pushScope()
+			case "then":
+				if branch != "if" {
+					return "", fmt.Errorf("then must come before the elseif and else branches of an if block")
+				}
+				warnStrayText("then", child, compiler)
+				body, err := compiler.CompileChildren(child)
+				if err != nil {
+					return "", err
+				}
+				if body != "" {
+					result += body + "\n"
+				}
+			default:
+				if branch != "if" {
+					return "", fmt.Errorf("statements in an if block must come before its elseif and else branches")
+				}
+				childCode, err := compiler.compileStatement(child)
+				if err != nil {
+					return "", err
+				}
+				if childCode != "" {
+					result += childCode + "\n"
+				}
 			}
 		}
+		compiler.popScope()
 		compiler.indent--
 
+		result += compiler.getIndent() + "end"
 		return result, nil
 	})
 
+	// <then>, <elseif> and <else> commands - only valid as sections of an
+	// <if>, which compiles them itself
+	sections := map[string]HandlerSpec{
+		"then":   {Body: true},
+		"elseif": {Attributes: []string{"test"}, Required: []string{"test"}, Body: true},
+		"else":   {Body: true},
+	}
+	for tag, spec := range sections {
+		c.RegisterWithSpec(tag, spec, func(node Node, compiler *Compiler) (string, error) {
+			return "", &CompileError{Tag: tag, Message: tag + " must be inside an if block"}
+		})
+	}
+
 	// <for> command
-	c.Register("for", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("for", HandlerSpec{
+		Attributes: []string{"var", "from", "to", "step", "key", "value", "in"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		varName := GetAttr(node, "var")
 		from := GetAttr(node, "from")
 		to := GetAttr(node, "to")
 		step := GetAttrWithDefault(node, "step", "1")
 
-		if varName == "" {
-			return "", fmt.Errorf("for command requires 'var' attribute")
+		keyed := HasAttr(node, "key") || HasAttr(node, "value")
+		if varName != "" && keyed {
+			return "", fmt.Errorf("for command cannot have both 'var' and 'key'/'value' attributes")
 		}
-
-		if !IsValidIdentifier(varName) {
-			return "", fmt.Errorf("invalid variable name: %s", varName)
+		if varName == "" && !keyed {
+			return "", fmt.Errorf("for command requires 'var' or 'key'/'value' attributes")
 		}
 
 		var result string
 		if from != "" && to != "" {
+			if keyed {
+				return "", fmt.Errorf("numeric for loop takes 'var', not 'key'/'value'")
+			}
+			names := SplitParameters(varName)
+			if len(names) != 1 {
+				return "", fmt.Errorf("numeric for loop takes a single variable, got: %s", strings.Join(names, ", "))
+			}
+			if varName = names[0]; !IsValidIdentifier(varName) {
+				return "", fmt.Errorf("invalid variable name: %s", varName)
+			}
+
+			if !HasAttr(node, "step") && compiler.options.AutoReverseFor && isDescendingRange(from, to) {
+				step = "-1"
+			}
+
+			if err := checkForBounds(compiler, from, to, step); err != nil {
+				return "", err
+			}
+
 			// Numeric for loop
 			if step != "1" {
 				result = fmt.Sprintf("%sfor %s = %s, %s, %s do\n", compiler.getIndent(), varName, from, to, step)
@@ -143,122 +1140,469 @@ func (c *Compiler) registerControlFlowCommands() {
 			if iterator == "" {
 				return "", fmt.Errorf("for command requires either 'from'/'to' or 'in' attributes")
 			}
-			result = fmt.Sprintf("%sfor %s in %s do\n", compiler.getIndent(), varName, iterator)
+			names, err := genericForNames(node, compiler)
+			if err != nil {
+				return "", err
+			}
+			result = fmt.Sprintf("%sfor %s in %s do\n", compiler.getIndent(), strings.Join(names, ", "), iterator)
 		}
 
+		warnStrayText("for", node, compiler)
+
 		compiler.indent++
-		for _, child := range node.Nodes {
-			childCode, err := compiler.compileNode(child)
-			if err != nil {
-				return "", err
+		compiler.loopDepth++
+		compiler.pushScope()
+		body, err := compiler.CompileChildren(node)
+		if err != nil {
+			return "", err
+		}
+		if body != "" {
+			result += body + "\n"
+		}
+		compiler.popScope()
+		compiler.loopDepth--
+		compiler.indent--
+
+		result += compiler.getIndent() + "end"
+		return result, nil
+	})
+
+	// <ipairs> and <pairs> commands - iterator expressions over a table
+	for _, iterator := range []string{"ipairs", "pairs"} {
+		c.RegisterWithSpec(iterator, HandlerSpec{
+			Attributes: []string{"table", "var", "local"},
+			Required:   []string{"table"},
+		}, func(node Node, compiler *Compiler) (string, error) {
+			table := GetAttr(node, "table")
+			if table == "" {
+				return "", fmt.Errorf("%s command requires 'table' attribute", iterator)
+			}
+
+			return compileAssignment(iterator, node, compiler, fmt.Sprintf("%s(%s)", iterator, table))
+		})
+	}
+
+	// <while> command
+	c.RegisterWithSpec("while", HandlerSpec{
+		Attributes: []string{"test"},
+		Required:   []string{"test"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		test := GetAttr(node, "test")
+		if test == "" {
+			return "", fmt.Errorf("while command requires 'test' attribute")
+		}
+
+		result := fmt.Sprintf("%swhile %s do\n", compiler.getIndent(), test)
+
+		warnStrayText("while", node, compiler)
+
+		compiler.indent++
+		compiler.loopDepth++
+		compiler.pushScope()
+		body, err := compiler.CompileChildren(node)
+		if err != nil {
+			return "", err
+		}
+		if body != "" {
+			result += body + "\n"
+		}
+		compiler.popScope()
+		compiler.loopDepth--
+		compiler.indent--
+
+		result += compiler.getIndent() + "end"
+		return result, nil
+	})
+
+	// <repeat> command
+	c.RegisterWithSpec("repeat", HandlerSpec{
+		Attributes: []string{"until"},
+		Required:   []string{"until"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		until := GetAttr(node, "until")
+		if until == "" {
+			return "", fmt.Errorf("repeat command requires 'until' attribute")
+		}
+
+		result := fmt.Sprintf("%srepeat\n", compiler.getIndent())
+
+		warnStrayText("repeat", node, compiler)
+
+		compiler.indent++
+		compiler.loopDepth++
+		compiler.pushScope()
+		body, err := compiler.CompileChildren(node)
+		if err != nil {
+			return "", err
+		}
+		if body != "" {
+			result += body + "\n"
+		}
+		compiler.popScope()
+		compiler.loopDepth--
+		compiler.indent--
+
+		result += fmt.Sprintf("%suntil %s", compiler.getIndent(), until)
+		return result, nil
+	})
+
+	// <break> command
+	c.RegisterWithSpec("break", HandlerSpec{}, func(node Node, compiler *Compiler) (string, error) {
+		if compiler.loopDepth == 0 {
+			return "", &CompileError{Tag: "break", Message: "break must be inside a loop"}
+		}
+		return compiler.getIndent() + "break", nil
+	})
+
+	// <continue> command
+	c.RegisterWithSpec("continue", HandlerSpec{}, func(node Node, compiler *Compiler) (string, error) {
+		if compiler.loopDepth == 0 {
+			return "", &CompileError{Tag: "continue", Message: "continue must be inside a loop"}
+		}
+		if compiler.targetDialect() == TargetLua51 {
+			return "", &CompileError{Tag: "continue", Message: "continue is not available in Lua 5.1"}
+		}
+		return compiler.getIndent() + "continue", nil
+	})
+
+	// <when-type> command - if typeof(var) == "type" then ... end, with the
+	// same elseif and else branches as <if>
+	c.RegisterWithSpec("when-type", HandlerSpec{
+		Attributes: []string{"var", "is"},
+		Required:   []string{"var", "is"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		value := GetAttr(node, "var")
+		typeName := GetAttr(node, "is")
+		if value == "" {
+			return "", fmt.Errorf("when-type command requires 'var' attribute")
+		}
+		if typeName == "" {
+			return "", fmt.Errorf("when-type command requires 'is' attribute")
+		}
+
+		fn := "typeof"
+		switch {
+		case compiler.targetDialect() == TargetLua51 && robloxTypes[typeName]:
+			return "", &CompileError{Tag: "when-type", Message: "Roblox type " + typeName + " is not available in Lua 5.1"}
+		case compiler.targetDialect() == TargetLua51:
+			fn = "type"
+		}
+		if !primitiveTypes[typeName] && !robloxTypes[typeName] {
+			return "", &CompileError{Tag: "when-type", Message: "unknown type: " + typeName}
+		}
+
+		test := fmt.Sprintf(`%s(%s) == "%s"`, fn, value, typeName)
+		return compiler.compileNode(Node{
+			XMLName: xml.Name{Local: "if"},
+			Attrs:   []xml.Attr{{Name: xml.Name{Local: "test"}, Value: test}},
+			Content: node.Content,
+			Nodes:   node.Nodes,
+		})
+	})
+
+	// <nil-check> command - if var ~= nil then ... end, raising the
+	// else-error message otherwise
+	c.RegisterWithSpec("nil-check", HandlerSpec{
+		Attributes: []string{"var", "else-error"},
+		Required:   []string{"var"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		value := GetAttr(node, "var")
+		if value == "" {
+			return "", fmt.Errorf("nil-check command requires 'var' attribute")
+		}
+
+		nodes := node.Nodes
+		if HasAttr(node, "else-error") {
+			for _, child := range node.Nodes {
+				if child.XMLName.Local == "elseif" || child.XMLName.Local == "else" {
+					return "", fmt.Errorf("nil-check command cannot have both 'else-error' attribute and %s branches", child.XMLName.Local)
+				}
 			}
-			if childCode != "" {
-				result += childCode + "\n"
+
+			message := GetAttr(node, "else-error")
+			if !compiler.interpolation().in(message) {
+				message = `"` + EscapeString(message) + `"`
 			}
+			raise := Node{XMLName: xml.Name{Local: "error"}, Content: message}
+			nodes = append(append([]Node(nil), nodes...), Node{XMLName: xml.Name{Local: "else"}, Nodes: []Node{raise}})
+		}
+
+		return compiler.compileNode(Node{
+			XMLName: xml.Name{Local: "if"},
+			Attrs:   []xml.Attr{{Name: xml.Name{Local: "test"}, Value: value + " ~= nil"}},
+			Content: node.Content,
+			Nodes:   nodes,
+		})
+	})
+
+	// <guard> command - early exit when test holds, e.g.
+	// <guard test="x == nil" return="nil"/> or <guard test="..."><error>...</error></guard>
+	c.RegisterWithSpec("guard", HandlerSpec{
+		Attributes: []string{"test", "return"},
+		Required:   []string{"test"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		test := GetAttr(node, "test")
+		if test == "" {
+			return "", fmt.Errorf("guard command requires 'test' attribute")
 		}
+
+		hasReturn := HasAttr(node, "return")
+		if hasReturn == (len(node.Nodes) > 0) {
+			return "", fmt.Errorf("guard command requires exactly one of 'return' attribute or a child action")
+		}
+
+		if hasReturn {
+			action := "return"
+			if value := GetAttr(node, "return"); value != "" {
+				action += " " + value
+			}
+			return fmt.Sprintf("%sif %s then %s end", compiler.getIndent(), test, action), nil
+		}
+
+		warnStrayText("guard", node, compiler)
+
+		compiler.indent++
+		compiler.pushScope()
+		body, err := compiler.CompileChildren(node)
+		if err != nil {
+			return "", err
+		}
+		compiler.popScope()
 		compiler.indent--
 
-		result += compiler.getIndent() + "end"
-		return result, nil
-	})
+		// A single-line action stays on the guard's line
+		if !strings.Contains(body, "\n") {
+			return fmt.Sprintf("%sif %s then %s end", compiler.getIndent(), test, strings.TrimSpace(body)), nil
+		}
+		return fmt.Sprintf("%sif %s then\n%s\n%send", compiler.getIndent(), test, body, compiler.getIndent()), nil
+	})
+
+	// <once> command - runs its body the first time it is reached, guarded
+	// by a flag named by 'flag' or numbered _lunariaOnce_N. The flag is
+	// declared at chunk scope so that a <once> inside a function or loop
+	// still runs only once.
+	c.RegisterWithSpec("once", HandlerSpec{
+		Attributes: []string{"flag"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		flag := GetAttr(node, "flag")
+		if flag == "" {
+			compiler.onceCount++
+			flag = fmt.Sprintf("_lunariaOnce_%d", compiler.onceCount)
+		}
+		if !IsValidIdentifier(flag) {
+			return "", fmt.Errorf("invalid variable name: %s", flag)
+		}
+		compiler.hoistLocal("once", flag, "false")
+
+		result := fmt.Sprintf("%sif not %s then\n", compiler.getIndent(), flag)
+
+		warnStrayText("once", node, compiler)
+
+		compiler.indent++
+		compiler.pushScope()
+		result += fmt.Sprintf("%s%s = true%s\n", compiler.getIndent(), flag, compiler.terminator())
+		body, err := compiler.CompileChildren(node)
+		if err != nil {
+			return "", err
+		}
+		if body != "" {
+			result += body + "\n"
+		}
+		compiler.popScope()
+		compiler.indent--
+
+		return result + compiler.getIndent() + "end", nil
+	})
+}
+
+// memoizeKey and memoizeResult are the locals of a <memoize> function
+// holding its composite cache key and its computed result
+const (
+	memoizeKey    = "_lunariaKey"
+	memoizeResult = "_lunariaResult"
+)
+
+// registerFunctionCommands registers function-related commands
+func (c *Compiler) registerFunctionCommands() {
+	// <function> command
+	c.RegisterWithSpec("function", HandlerSpec{
+		Attributes: []string{"name", "params", "local", "lambda", "variadic", "variadic-type"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		name := GetAttr(node, "name")
+		params := GetAttrWithDefault(node, "params", "")
+		isLocal := GetBoolAttr(node, "local")
+		isLambda := GetBoolAttr(node, "lambda")
+
+		// Lua 5.1 has no type annotations
+		if compiler.targetDialect() == TargetLua51 && (strings.Contains(params, ":") || HasAttr(node, "variadic-type")) {
+			return "", &CompileError{Tag: "function", Message: "type annotations are not available in Lua 5.1"}
+		}
 
-	// <while> command
-	c.Register("while", func(node Node, compiler *Compiler) (string, error) {
-		test := GetAttr(node, "test")
-		if test == "" {
-			return "", fmt.Errorf("while command requires 'test' attribute")
+		if GetBoolAttr(node, "variadic") {
+			var err error
+			if params, err = appendVararg(params, GetAttr(node, "variadic-type")); err != nil {
+				return "", err
+			}
 		}
 
-		result := fmt.Sprintf("%swhile %s do\n", compiler.getIndent(), test)
+		var result string
+		if isLambda {
+			// Anonymous function, assigned to 'var' or used inline
+			if name != "" {
+				compiler.warn("function", "'name' is ignored for lambda function %s", name)
+			}
+			result = fmt.Sprintf("function(%s)\n", params)
+		} else {
+			if name == "" {
+				return "", fmt.Errorf("function command requires 'name' attribute")
+			}
 
-		compiler.indent++
-		for _, child := range node.Nodes {
-			childCode, err := compiler.compileNode(child)
-			if err != nil {
-				return "", err
+			if !IsValidIdentifier(name) {
+				return "", fmt.Errorf("invalid function name: %s", name)
 			}
-			if childCode != "" {
-				result += childCode + "\n"
+
+			prefix := ""
+			if isLocal {
+				prefix = "local "
+				compiler.declareLocal("function", name)
 			}
+
+			result = fmt.Sprintf("%s%sfunction %s(%s)\n", compiler.getIndent(), prefix, name, params)
 		}
-		compiler.indent--
 
-		result += compiler.getIndent() + "end"
+		body, err := compileFunctionBody(node, compiler, params)
+		if err != nil {
+			return "", err
+		}
+		result += body + compiler.getIndent() + "end"
+
+		if isLambda {
+			return compileAssignment("function", node, compiler, result)
+		}
 		return result, nil
 	})
 
-	// <repeat> command
-	c.Register("repeat", func(node Node, compiler *Compiler) (string, error) {
-		until := GetAttr(node, "until")
-		if until == "" {
-			return "", fmt.Errorf("repeat command requires 'until' attribute")
+	// <spawn> command - runs its children in a new thread, with
+	// task.spawn(function() ... end) or, with mode="coroutine",
+	// coroutine.wrap(function() ... end)(). Lua 5.1 has no task library,
+	// so both modes use a coroutine there.
+	c.RegisterWithSpec("spawn", HandlerSpec{
+		Attributes: []string{"mode"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		mode := GetAttrWithDefault(node, "mode", "task")
+		if mode != "task" && mode != "coroutine" {
+			return "", fmt.Errorf("invalid spawn mode: %s (expected task or coroutine)", mode)
+		}
+		if compiler.targetDialect() == TargetLua51 {
+			mode = "coroutine"
 		}
 
-		result := fmt.Sprintf("%srepeat\n", compiler.getIndent())
+		warnStrayText("spawn", node, compiler)
 
-		compiler.indent++
-		for _, child := range node.Nodes {
-			childCode, err := compiler.compileNode(child)
-			if err != nil {
-				return "", err
-			}
-			if childCode != "" {
-				result += childCode + "\n"
-			}
+		body, err := compileFunctionBody(node, compiler, "")
+		if err != nil {
+			return "", err
+		}
+		if body == "" {
+			compiler.warn("spawn", "spawn has no body and does nothing")
 		}
-		compiler.indent--
-
-		result += fmt.Sprintf("%suntil %s", compiler.getIndent(), until)
-		return result, nil
-	})
 
-	// <break> command
-	c.Register("break", func(node Node, compiler *Compiler) (string, error) {
-		return compiler.getIndent() + "break", nil
+		fn := "function()\n" + body + compiler.getIndent() + "end"
+		if mode == "coroutine" {
+			return fmt.Sprintf("%scoroutine.wrap(%s)()", compiler.getIndent(), fn), nil
+		}
+		return fmt.Sprintf("%stask.spawn(%s)", compiler.getIndent(), fn), nil
 	})
-}
 
-// registerFunctionCommands registers function-related commands
-func (c *Compiler) registerFunctionCommands() {
-	// <function> command
-	c.Register("function", func(node Node, compiler *Compiler) (string, error) {
+	// <memoize> command - a function whose results are cached by its
+	// key-param arguments in a local _cache_name table. Its children
+	// compute the result, which must not be nil to be cached.
+	c.RegisterWithSpec("memoize", HandlerSpec{
+		Attributes: []string{"name", "key-param", "local"},
+		Required:   []string{"name", "key-param"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		name := GetAttr(node, "name")
-		params := GetAttrWithDefault(node, "params", "")
-		isLocal := GetBoolAttr(node, "local")
-
 		if name == "" {
-			return "", fmt.Errorf("function command requires 'name' attribute")
+			return "", fmt.Errorf("memoize command requires 'name' attribute")
 		}
-
 		if !IsValidIdentifier(name) {
 			return "", fmt.Errorf("invalid function name: %s", name)
 		}
-
+		keys := SplitParameters(GetAttr(node, "key-param"))
+		if len(keys) == 0 {
+			return "", fmt.Errorf("memoize command requires 'key-param' attribute")
+		}
+		// The cache is numbered like the flags of <once>, and the generated
+		// locals use a prefix reserved for the compiler, so none of them
+		// can shadow a parameter
+		compiler.memoizeCount++
+		cache := GenerateVariableName("_cache_"+name+"_", compiler.memoizeCount)
+		for _, key := range keys {
+			if !IsValidIdentifier(key) {
+				return "", fmt.Errorf("invalid parameter name: %s", key)
+			}
+			if key == cache || key == memoizeKey || key == memoizeResult {
+				return "", &CompileError{Tag: "memoize", Message: fmt.Sprintf("parameter %s collides with a name generated by memoize", key)}
+			}
+		}
+		compiler.declareLocal("memoize", cache)
 		prefix := ""
-		if isLocal {
+		if GetBoolAttr(node, "local") {
 			prefix = "local "
+			compiler.declareLocal("memoize", name)
 		}
 
-		result := fmt.Sprintf("%s%sfunction %s(%s)\n", compiler.getIndent(), prefix, name, params)
+		// Every line is built here rather than by compileStatement, so each
+		// statement takes its own terminator
+		end := compiler.terminator()
+		indent := compiler.getIndent()
+		result := fmt.Sprintf("%slocal %s = {}%s\n%s%sfunction %s(%s)\n", indent, cache, end, indent, prefix, name, strings.Join(keys, ", "))
 
+		// Several keys combine into one string, separated by a byte that
+		// tostring never produces
 		compiler.indent++
-		for _, child := range node.Nodes {
-			childCode, err := compiler.compileNode(child)
-			if err != nil {
-				return "", err
-			}
-			if childCode != "" {
-				result += childCode + "\n"
+		inner := compiler.getIndent()
+		key := keys[0]
+		if len(keys) > 1 {
+			parts := make([]string, len(keys))
+			for i, k := range keys {
+				parts[i] = "tostring(" + k + ")"
 			}
+			key = memoizeKey
+			result += fmt.Sprintf("%slocal %s = %s%s\n", inner, key, strings.Join(parts, ` .. "\0" .. `), end)
 		}
+		compiler.indent++
+		hit := fmt.Sprintf("%sreturn %s[%s]%s\n", compiler.getIndent(), cache, key, end)
 		compiler.indent--
+		result += fmt.Sprintf("%sif %s[%s] ~= nil then\n%s%send\n", inner, cache, key, hit, inner)
 
-		result += compiler.getIndent() + "end"
-		return result, nil
+		warnStrayText("memoize", node, compiler)
+		body, err := compileFunctionBody(node, compiler, strings.Join(keys, ", "))
+		if err != nil {
+			return "", err
+		}
+		result += fmt.Sprintf("%slocal %s = (function()\n%s%send)()%s\n", inner, memoizeResult, body, inner, end)
+		result += fmt.Sprintf("%s%s[%s] = %s%s\n%sreturn %s%s\n", inner, cache, key, memoizeResult, end, inner, memoizeResult, end)
+		compiler.indent--
+
+		return result + indent + "end", nil
 	})
 
 	// <call> command
-	c.Register("call", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("call", HandlerSpec{
+		Attributes: []string{"name"},
+		Required:   []string{"name"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		name := GetAttr(node, "name")
 		if name == "" {
 			return "", fmt.Errorf("call command requires 'name' attribute")
@@ -271,12 +1615,15 @@ func (c *Compiler) registerFunctionCommands() {
 		}
 
 		// Process child nodes as arguments
-		for _, child := range node.Nodes {
-			if child.XMLName.Local == "arg" {
-				argValue := strings.TrimSpace(child.Content)
-				if argValue != "" {
-					args = append(args, argValue)
-				}
+		childArgs, err := compileArgs(node, compiler, true)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, childArgs...)
+
+		if compiler.targetDialect() == TargetLua51 {
+			if name, args, err = lua51Call(name, args); err != nil {
+				return "", err
 			}
 		}
 
@@ -284,8 +1631,48 @@ func (c *Compiler) registerFunctionCommands() {
 		return fmt.Sprintf("%s%s(%s)", compiler.getIndent(), name, argsStr), nil
 	})
 
+	// <self-call> command - method call with colon syntax
+	c.RegisterWithSpec("self-call", HandlerSpec{
+		Attributes: []string{"object", "method", "args", "var", "local"},
+		Required:   []string{"object", "method"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		object := GetAttr(node, "object")
+		method := GetAttr(node, "method")
+		if object == "" {
+			return "", fmt.Errorf("self-call command requires 'object' attribute")
+		}
+		if method == "" {
+			return "", fmt.Errorf("self-call command requires 'method' attribute")
+		}
+		if !IsValidIdentifier(method) {
+			return "", fmt.Errorf("invalid method name: %s", method)
+		}
+
+		args := SplitParameters(GetAttr(node, "args"))
+		if len(args) > 0 && hasNamedArgs(node) {
+			return "", fmt.Errorf("self-call cannot mix named and positional arguments")
+		}
+		if content := strings.TrimSpace(node.Content); content != "" {
+			args = append(args, content)
+		}
+		childArgs, err := compileArgs(node, compiler, true)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, childArgs...)
+
+		expr := fmt.Sprintf("%s:%s(%s)", object, method, JoinWithCommas(args))
+		if GetAttr(node, "var") == "" {
+			return compiler.getIndent() + expr, nil
+		}
+		return compileAssignment("self-call", node, compiler, expr)
+	})
+
 	// <return> command
-	c.Register("return", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("return", HandlerSpec{
+		Body: true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		content := strings.TrimSpace(node.Content)
 		if content == "" {
 			return compiler.getIndent() + "return", nil
@@ -293,8 +1680,51 @@ func (c *Compiler) registerFunctionCommands() {
 		return fmt.Sprintf("%sreturn %s", compiler.getIndent(), content), nil
 	})
 
+	// <upvalue> command - documents the variables a function captures
+	c.RegisterWithSpec("upvalue", HandlerSpec{
+		Attributes: []string{"captures"},
+		Required:   []string{"captures"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		names := SplitParameters(GetAttr(node, "captures"))
+		if len(names) == 0 {
+			return "", fmt.Errorf("upvalue command requires 'captures' attribute")
+		}
+		for _, name := range names {
+			if !IsValidIdentifier(name) {
+				return "", fmt.Errorf("invalid variable name: %s", name)
+			}
+		}
+
+		if compiler.functionDepth == 0 {
+			compiler.warn("upvalue", "upvalue annotation outside a function has nothing to capture")
+		}
+		if compiler.options.Minify {
+			return "", nil
+		}
+
+		return fmt.Sprintf("%s-- captures: %s", compiler.getIndent(), strings.Join(names, ", ")), nil
+	})
+
+	// <vararg> command - the ... of the enclosing variadic function
+	c.RegisterWithSpec("vararg", HandlerSpec{
+		Attributes: []string{"pack", "var", "local"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		if !compiler.inVarargFunction {
+			return "", &CompileError{Tag: "vararg", Message: "vararg can only be used inside a variadic function"}
+		}
+
+		expr := "..."
+		if GetBoolAttr(node, "pack") {
+			expr = "{...}"
+		}
+		return compileAssignment("vararg", node, compiler, expr)
+	})
+
 	// <arg> command (used within call blocks)
-	c.Register("arg", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("arg", HandlerSpec{
+		Attributes: []string{"name"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		// Args are processed by the parent call command
 		return "", nil
 	})
@@ -303,72 +1733,34 @@ func (c *Compiler) registerFunctionCommands() {
 // registerDataCommands registers data structure commands
 func (c *Compiler) registerDataCommands() {
 	// <table> command
-	c.Register("table", func(node Node, compiler *Compiler) (string, error) {
-		varName := GetAttr(node, "var")
-		isLocal := GetBoolAttr(node, "local")
-
-		prefix := ""
-		if isLocal {
-			prefix = "local "
-		}
-
-		if varName != "" {
-			if !IsValidIdentifier(varName) {
-				return "", fmt.Errorf("invalid variable name: %s", varName)
-			}
-
-			result := fmt.Sprintf("%s%s%s = {\n", compiler.getIndent(), prefix, varName)
-
-			compiler.indent++
-			for _, child := range node.Nodes {
-				if child.XMLName.Local == "entry" {
-					key := GetAttr(child, "key")
-					value := strings.TrimSpace(child.Content)
-					if key != "" && value != "" {
-						if IsValidIdentifier(key) {
-							result += fmt.Sprintf("%s%s = %s,\n", compiler.getIndent(), key, value)
-						} else {
-							result += fmt.Sprintf("%s[%s] = %s,\n", compiler.getIndent(), WrapInQuotes(key), value)
-						}
-					}
-				}
-			}
-			compiler.indent--
-
-			result += compiler.getIndent() + "}"
-			return result, nil
-		}
-
-		// Inline table
-		result := "{\n"
-		compiler.indent++
-		for _, child := range node.Nodes {
-			if child.XMLName.Local == "entry" {
-				key := GetAttr(child, "key")
-				value := strings.TrimSpace(child.Content)
-				if key != "" && value != "" {
-					if IsValidIdentifier(key) {
-						result += fmt.Sprintf("%s%s = %s,\n", compiler.getIndent(), key, value)
-					} else {
-						result += fmt.Sprintf("%s[%s] = %s,\n", compiler.getIndent(), WrapInQuotes(key), value)
-					}
-				}
-			}
+	c.RegisterWithSpec("table", HandlerSpec{
+		Attributes: []string{"var", "local", "sort"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		body, err := compileTableBody(node, compiler)
+		if err != nil {
+			return "", err
 		}
-		compiler.indent--
-		result += compiler.getIndent() + "}"
 
-		return result, nil
+		// Inline table when 'var' is absent
+		return compileAssignment("table", node, compiler, body)
 	})
 
 	// <entry> command (used within table blocks)
-	c.Register("entry", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("entry", HandlerSpec{
+		Attributes: []string{"key"},
+		Required:   []string{"key"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		// Entries are processed by the parent table command
 		return "", nil
 	})
 
 	// <array> command for creating arrays
-	c.Register("array", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("array", HandlerSpec{
+		Attributes: []string{"var", "local"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		varName := GetAttr(node, "var")
 		isLocal := GetBoolAttr(node, "local")
 
@@ -399,14 +1791,68 @@ func (c *Compiler) registerDataCommands() {
 			if !IsValidIdentifier(varName) {
 				return "", fmt.Errorf("invalid variable name: %s", varName)
 			}
+			if isLocal {
+				compiler.declareLocal("array", varName)
+			}
 			return fmt.Sprintf("%s%s%s = {%s}", compiler.getIndent(), prefix, varName, arrayContent), nil
 		}
 
 		return fmt.Sprintf("{%s}", arrayContent), nil
 	})
 
+	// <number> command - a number literal in decimal, hex or binary,
+	// optionally grouped with underscores and typed
+	c.RegisterWithSpec("number", HandlerSpec{
+		Attributes: []string{"value", "group", "base", "type", "var", "local"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		value := GetAttrWithDefault(node, "value", strings.TrimSpace(node.Content))
+		if value == "" {
+			return "", fmt.Errorf("number command requires 'value' attribute")
+		}
+
+		group := 0
+		if HasAttr(node, "group") {
+			var err error
+			if group, err = strconv.Atoi(GetAttr(node, "group")); err != nil || group < 1 {
+				return "", fmt.Errorf("number command has invalid group: %s", GetAttr(node, "group"))
+			}
+		}
+
+		literal, err := formatNumber(value, GetAttr(node, "base"), group)
+		if err != nil {
+			return "", err
+		}
+
+		varType := GetAttr(node, "type")
+		if compiler.targetDialect() == TargetLua51 {
+			if strings.Contains(literal, "_") || strings.HasPrefix(strings.TrimPrefix(literal, "-"), "0b") {
+				return "", &CompileError{Tag: "number", Message: "digit separators and binary literals are not available in Lua 5.1"}
+			}
+			if varType != "" {
+				return "", &CompileError{Tag: "number", Message: "type annotations are not available in Lua 5.1"}
+			}
+		}
+
+		if varType == "" {
+			return compileAssignment("number", node, compiler, literal)
+		}
+
+		varName := GetAttr(node, "var")
+		if varName == "" || !GetBoolAttr(node, "local") {
+			return "", fmt.Errorf("number command needs a local 'var' to annotate with a type")
+		}
+		if !IsValidIdentifier(varName) {
+			return "", fmt.Errorf("invalid variable name: %s", varName)
+		}
+		compiler.declareLocal("number", varName)
+		return fmt.Sprintf("%slocal %s: %s = %s", compiler.getIndent(), varName, varType, literal), nil
+	})
+
 	// <item> command (used within array blocks)
-	c.Register("item", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("item", HandlerSpec{
+		Body: true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		// Items are processed by the parent array command
 		return "", nil
 	})
@@ -415,100 +1861,272 @@ func (c *Compiler) registerDataCommands() {
 // registerIOCommands registers input/output commands
 func (c *Compiler) registerIOCommands() {
 	// <print> command
-	c.Register("print", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("print", HandlerSpec{
+		Body: true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		return compileOutputCommand("print", node, compiler)
+	})
+
+	// <warn> command
+	c.RegisterWithSpec("warn", HandlerSpec{
+		Body: true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		return compileOutputCommand("warn", node, compiler)
+	})
+
+	// <error> command
+	c.RegisterWithSpec("error", HandlerSpec{
+		Attributes: []string{"var", "level", "expr"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		return compileOutputCommand("error", node, compiler)
+	})
+
+	// <istring> command - Luau backtick string interpolation, which falls
+	// back to concatenation for Lua 5.1
+	c.RegisterWithSpec("istring", HandlerSpec{
+		Attributes: []string{"var", "local"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		content := strings.TrimSpace(node.Content)
 		if content == "" {
-			return "", fmt.Errorf("print command requires content")
+			return "", fmt.Errorf("istring command requires content")
 		}
 
-		// Handle interpolation
-		if strings.Contains(content, "{{") {
-			interpolated := Interpolate(content)
-			return fmt.Sprintf("%sprint(\"%s\")", compiler.getIndent(), interpolated), nil
+		if err := compiler.checkInterpolation("istring", content); err != nil {
+			return "", err
 		}
 
-		return fmt.Sprintf("%sprint(%s)", compiler.getIndent(), content), nil
+		backticks := compiler.targetDialect() == TargetLuau
+		return compileAssignment("istring", node, compiler, interpolateString(content, backticks, compiler.interpolation()))
 	})
 
-	// <warn> command
-	c.Register("warn", func(node Node, compiler *Compiler) (string, error) {
-		content := strings.TrimSpace(node.Content)
-		if content == "" {
-			return "", fmt.Errorf("warn command requires content")
+	// <string-interpolate> command - template with {name} placeholders
+	// filled from <bind name="..." expr="..."/> children
+	c.RegisterWithSpec("string-interpolate", HandlerSpec{
+		Attributes: []string{"template", "var", "local"},
+		Required:   []string{"template"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		template := GetAttr(node, "template")
+		if !HasAttr(node, "template") {
+			return "", fmt.Errorf("string-interpolate command requires 'template' attribute")
+		}
+
+		binds := make(map[string]string)
+		var order []string
+		for _, child := range node.Nodes {
+			if child.XMLName.Local != "bind" {
+				continue
+			}
+			name, expr := GetAttr(child, "name"), GetAttr(child, "expr")
+			if name == "" || expr == "" {
+				return "", fmt.Errorf("bind in string-interpolate requires 'name' and 'expr' attributes")
+			}
+			if _, exists := binds[name]; exists {
+				return "", fmt.Errorf("duplicate binding: %s", name)
+			}
+			binds[name] = expr
+			order = append(order, name)
+		}
+
+		var parts []string
+		used := make(map[string]bool)
+		last := 0
+		for _, m := range templatePlaceholder.FindAllStringSubmatchIndex(template, -1) {
+			name := template[m[2]:m[3]]
+			expr, ok := binds[name]
+			if !ok {
+				return "", fmt.Errorf("template placeholder {%s} has no binding", name)
+			}
+			used[name] = true
+			if literal := template[last:m[0]]; literal != "" {
+				parts = append(parts, `"`+EscapeString(literal)+`"`)
+			}
+			parts = append(parts, "tostring("+expr+")")
+			last = m[1]
+		}
+		if literal := template[last:]; literal != "" || len(parts) == 0 {
+			parts = append(parts, `"`+EscapeString(literal)+`"`)
 		}
 
-		// Handle interpolation
-		if strings.Contains(content, "{{") {
-			interpolated := Interpolate(content)
-			return fmt.Sprintf("%swarn(\"%s\")", compiler.getIndent(), interpolated), nil
+		for _, name := range order {
+			if !used[name] {
+				compiler.warn("string-interpolate", "binding '%s' is not used by the template", name)
+			}
 		}
 
-		return fmt.Sprintf("%swarn(%s)", compiler.getIndent(), content), nil
+		return compileAssignment("string-interpolate", node, compiler, strings.Join(parts, " .. "))
 	})
 
-	// <error> command
-	c.Register("error", func(node Node, compiler *Compiler) (string, error) {
-		content := strings.TrimSpace(node.Content)
-		if content == "" {
-			return "", fmt.Errorf("error command requires content")
+	// <format> command - string.format with <arg> children
+	c.RegisterWithSpec("format", HandlerSpec{
+		Attributes: []string{"template", "var", "local"},
+		Required:   []string{"template"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		template := GetAttr(node, "template")
+		if template == "" {
+			return "", fmt.Errorf("format command requires 'template' attribute")
+		}
+
+		args := []string{`"` + EscapeString(template) + `"`}
+		childArgs, err := compileArgs(node, compiler, false)
+		if err != nil {
+			return "", err
 		}
+		args = append(args, childArgs...)
 
-		level := GetAttrWithDefault(node, "level", "1")
+		expr := fmt.Sprintf("string.format(%s)", JoinWithCommas(args))
+		return compileAssignment("format", node, compiler, expr)
+	})
+
+	// <concat> command - joins <part> children, optionally with a separator
+	c.RegisterWithSpec("concat", HandlerSpec{
+		Attributes: []string{"sep", "var", "local"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		var parts []string
+		for _, child := range node.Nodes {
+			if child.XMLName.Local == "part" {
+				if part := strings.TrimSpace(child.Content); part != "" {
+					parts = append(parts, WrapInQuotes(part))
+				}
+			}
+		}
+
+		if len(parts) == 0 {
+			return "", fmt.Errorf("concat command requires at least one <part> child")
+		}
 
-		// Handle interpolation
-		if strings.Contains(content, "{{") {
-			interpolated := Interpolate(content)
-			return fmt.Sprintf("%serror(\"%s\", %s)", compiler.getIndent(), interpolated, level), nil
+		var expr string
+		if HasAttr(node, "sep") {
+			sep := `"` + EscapeString(GetAttr(node, "sep")) + `"`
+			expr = fmt.Sprintf("table.concat({%s}, %s)", strings.Join(parts, ", "), sep)
+		} else {
+			expr = strings.Join(parts, " .. ")
 		}
 
-		return fmt.Sprintf("%serror(%s, %s)", compiler.getIndent(), content, level), nil
+		return compileAssignment("concat", node, compiler, expr)
+	})
+
+	// <part> command (used within concat blocks)
+	c.RegisterWithSpec("part", HandlerSpec{
+		Body: true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		// Parts are processed by the parent concat command
+		return "", nil
 	})
 }
 
 // registerUtilityCommands registers utility commands
 func (c *Compiler) registerUtilityCommands() {
 	// <raw> command - pass-through Luau
-	c.Register("raw", func(node Node, compiler *Compiler) (string, error) {
-		content := strings.TrimSpace(node.Content)
+	c.RegisterWithSpec("raw", HandlerSpec{
+		Body: true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		// Strip the indentation the block has in the XML source, then
+		// apply the current indentation to each line
+		content := DedentLines(node.Content)
 		if content == "" {
 			return "", nil
 		}
 
-		// Apply current indentation to each line
 		return IndentLines(content, compiler.getIndent()), nil
 	})
 
 	// <comment> command
-	c.Register("comment", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("comment", HandlerSpec{
+		Attributes: []string{"block"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		content := strings.TrimSpace(node.Content)
-		if content == "" {
+		if content == "" || compiler.options.Minify {
 			return "", nil
 		}
 
+		// Block comments indent only their delimiters, leaving content as
+		// written. The bracket level grows until the content cannot close it.
+		if GetBoolAttr(node, "block") {
+			indent := compiler.getIndent()
+			level := strings.Repeat("=", longestBracketLevel(content))
+			return fmt.Sprintf("%s--[%s[\n%s\n%s]%s]", indent, level, content, indent, level), nil
+		}
+
 		comment := FormatComment(content)
 		return IndentLines(comment, compiler.getIndent()), nil
 	})
 
+	// <pragma> command - Luau type checking mode, hoisted to the first line
+	c.RegisterWithSpec("pragma", HandlerSpec{
+		Attributes: []string{"mode"},
+		Required:   []string{"mode"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		mode := GetAttr(node, "mode")
+		switch mode {
+		case "strict", "nonstrict", "nocheck":
+		case "":
+			return "", fmt.Errorf("pragma command requires 'mode' attribute")
+		default:
+			return "", fmt.Errorf("pragma command has unknown mode: %s", mode)
+		}
+
+		// The StrictMode option takes precedence over the document
+		if strict, _ := compiler.strictDirective(); strict != "" {
+			if mode != strict {
+				compiler.warn("pragma", "pragma %s overridden by CompileOptions.StrictMode %s", mode, strict)
+			}
+			return "", nil
+		}
+		if compiler.pragma != "" {
+			compiler.warn("pragma", "duplicate pragma %s ignored; already using %s", mode, compiler.pragma)
+			return "", nil
+		}
+
+		compiler.pragma = mode
+		return "", nil
+	})
+
 	// <assert> command
-	c.Register("assert", func(node Node, compiler *Compiler) (string, error) {
+	c.RegisterWithSpec("assert", HandlerSpec{
+		Attributes: []string{"test"},
+		Required:   []string{"test"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		condition := GetAttr(node, "test")
 		if condition == "" {
 			return "", fmt.Errorf("assert command requires 'test' attribute")
 		}
 
+		// A message with {{...}} placeholders is interpolated like <print>
 		message := strings.TrimSpace(node.Content)
-		if message != "" {
-			return fmt.Sprintf("%sassert(%s, %s)", compiler.getIndent(), condition, WrapInQuotes(message)), nil
+		switch {
+		case compiler.interpolation().in(message):
+			if err := compiler.checkInterpolation("assert", message); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%sassert(%s, %s)", compiler.getIndent(), condition, outputArg(message, compiler)), nil
+		case message != "":
+			return fmt.Sprintf("%sassert(%s, %s)", compiler.getIndent(), condition, EscapeLiterals(WrapInQuotes(message))), nil
 		}
 
 		return fmt.Sprintf("%sassert(%s)", compiler.getIndent(), condition), nil
 	})
 
-	// <typeof> command
-	c.Register("typeof", func(node Node, compiler *Compiler) (string, error) {
+	// <typeof> command - Lua 5.1 only has type, which does not know
+	// Roblox types
+	c.RegisterWithSpec("typeof", HandlerSpec{
+		Attributes: []string{"var", "local"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
 		varName := GetAttr(node, "var")
 		value := strings.TrimSpace(node.Content)
 
+		fn := "typeof"
+		if compiler.targetDialect() == TargetLua51 {
+			fn = "type"
+		}
+
 		if varName == "" && value == "" {
 			return "", fmt.Errorf("typeof command requires either 'var' attribute or content")
 		}
@@ -527,12 +2145,153 @@ func (c *Compiler) registerUtilityCommands() {
 			prefix := ""
 			if isLocal {
 				prefix = "local "
+				compiler.declareLocal("typeof", varName)
 			}
 
-			return fmt.Sprintf("%s%s%s = typeof(%s)", compiler.getIndent(), prefix, varName, expr), nil
+			return fmt.Sprintf("%s%s%s = %s(%s)", compiler.getIndent(), prefix, varName, fn, expr), nil
 		}
 
 		// Return typeof expression directly
-		return fmt.Sprintf("typeof(%s)", value), nil
+		return fmt.Sprintf("%s(%s)", fn, value), nil
+	})
+
+	// <instanceof> command - object:IsA("Class") for Roblox instances, or
+	// typeof(object) == "class" with roblox="false"
+	c.RegisterWithSpec("instanceof", HandlerSpec{
+		Attributes: []string{"var", "class", "roblox", "result", "local"},
+		Required:   []string{"var", "class"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		object := GetAttr(node, "var")
+		class := GetAttr(node, "class")
+		if object == "" {
+			return "", fmt.Errorf("instanceof command requires 'var' attribute")
+		}
+		if class == "" {
+			return "", fmt.Errorf("instanceof command requires 'class' attribute")
+		}
+		object = prefixExpression(object)
+
+		var expr string
+		if GetAttrWithDefault(node, "roblox", "true") == "true" {
+			expr = fmt.Sprintf(`%s:IsA("%s")`, object, EscapeString(class))
+		} else {
+			fn := "typeof"
+			if compiler.targetDialect() == TargetLua51 {
+				fn = "type"
+			}
+			expr = fmt.Sprintf(`%s(%s) == "%s"`, fn, object, EscapeString(class))
+		}
+
+		result := GetAttr(node, "result")
+		if result == "" {
+			return expr, nil
+		}
+		if !IsValidIdentifier(result) {
+			return "", fmt.Errorf("invalid variable name: %s", result)
+		}
+
+		prefix := ""
+		if GetBoolAttr(node, "local") {
+			prefix = "local "
+			compiler.declareLocal("instanceof", result)
+		}
+		return fmt.Sprintf("%s%s%s = %s", compiler.getIndent(), prefix, result, expr), nil
+	})
+
+	// <not> command - not (expr)
+	c.RegisterWithSpec("not", HandlerSpec{
+		Attributes: []string{"var", "local"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		expr := strings.TrimSpace(node.Content)
+		if expr == "" {
+			return "", fmt.Errorf("not command requires an expression")
+		}
+
+		return compileAssignment("not", node, compiler, "not ("+expr+")")
+	})
+
+	// <bool> command - coerces a value to true or false
+	c.RegisterWithSpec("bool", HandlerSpec{
+		Attributes: []string{"var", "local"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		expr := strings.TrimSpace(node.Content)
+		if expr == "" {
+			return "", fmt.Errorf("bool command requires an expression")
+		}
+
+		return compileAssignment("bool", node, compiler, "not not ("+expr+")")
+	})
+
+	// <length> command - #operand
+	c.RegisterWithSpec("length", HandlerSpec{
+		Attributes: []string{"var", "local"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		operand := strings.TrimSpace(node.Content)
+		if operand == "" {
+			return "", fmt.Errorf("length command requires an operand")
+		}
+
+		// Parenthesize anything more complex than a variable or field
+		if !IsValidLValue(operand) {
+			operand = "(" + operand + ")"
+		}
+
+		return compileAssignment("length", node, compiler, "#"+operand)
+	})
+
+	// <ternary> command - (test and a or b)
+	//
+	// This is the standard Luau idiom, so it shares its caveat: when the
+	// 'then' expression is false or nil the 'else-val' expression is used.
+	c.RegisterWithSpec("ternary", HandlerSpec{
+		Attributes: []string{"test", "then", "else-val", "var", "local"},
+		Required:   []string{"test", "then", "else-val"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		test := GetAttr(node, "test")
+		thenExpr := GetAttr(node, "then")
+		elseExpr := GetAttr(node, "else-val")
+
+		if test == "" {
+			return "", fmt.Errorf("ternary command requires 'test' attribute")
+		}
+		if thenExpr == "" || elseExpr == "" {
+			return "", fmt.Errorf("ternary command requires 'then' and 'else-val' attributes")
+		}
+
+		expr := fmt.Sprintf("(%s and %s or %s)", test, thenExpr, elseExpr)
+		return compileAssignment("ternary", node, compiler, expr)
 	})
 }
+
+// warnStrayText warns about text mixed in with the statements of a block.
+// Only child elements are compiled there, so any text other than
+// formatting whitespace would otherwise vanish from the output silently.
+func warnStrayText(tag string, node Node, compiler *Compiler) {
+	if text := strings.TrimSpace(node.Content); text != "" {
+		compiler.warn(tag, "ignoring text content %q; wrap Luau code in <raw>", text)
+	}
+}
+
+// compileAssignment assigns expr to the node's 'var' attribute (honouring
+// 'local'), or returns expr unchanged for inline use when 'var' is absent
+func compileAssignment(tag string, node Node, compiler *Compiler, expr string) (string, error) {
+	varName := GetAttr(node, "var")
+	if varName == "" {
+		return expr, nil
+	}
+
+	if !IsValidIdentifier(varName) {
+		return "", fmt.Errorf("invalid variable name: %s", varName)
+	}
+
+	prefix := ""
+	if GetBoolAttr(node, "local") {
+		prefix = "local "
+		compiler.declareLocal(tag, varName)
+	}
+
+	return fmt.Sprintf("%s%s%s = %s", compiler.getIndent(), prefix, varName, expr), nil
+}
@@ -0,0 +1,110 @@
+package lunaria
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Cache stores compiled Luau output keyed by a hash of its input
+type Cache interface {
+	Get(key string) (string, bool)
+	Set(key, value string)
+}
+
+// WithCache makes the compiler consult cache before compiling and store
+// results after. Warnings are not cached, so a cache hit reports none.
+// Compilers with a fallback handler, middleware or a replaced handler do
+// not use the cache.
+func (c *Compiler) WithCache(cache Cache) *Compiler {
+	c.cache = cache
+	return c
+}
+
+// cacheable reports whether compilations may use the cache. The key only
+// covers the tag names, so compilers whose handlers differ in behavior
+// rather than in name, through a fallback, middleware or a replaced
+// handler, could serve each other's output and bypass the cache instead.
+func (c *Compiler) cacheable() bool {
+	return c.cache != nil && c.fallback == nil && len(c.middleware) == 0 && !c.replaced
+}
+
+// cacheKey hashes the XML input together with the registered tag names and
+// options, since either can change the output for the same input
+func (c *Compiler) cacheKey(s string) string {
+	names := make([]string, 0, len(c.handlers))
+	for name := range c.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	h.Write([]byte(s))
+	for _, name := range names {
+		h.Write([]byte{0})
+		h.Write([]byte(name))
+	}
+	fmt.Fprintf(h, "\x00%+v", c.options)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MemoryCache is a Cache held in memory, safe for concurrent use
+type MemoryCache struct {
+	mu      sync.RWMutex
+	entries map[string]string
+}
+
+// NewMemoryCache creates an empty in-memory cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]string)}
+}
+
+// Get returns the cached value for key
+func (m *MemoryCache) Get(key string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.entries[key]
+	return value, ok
+}
+
+// Set stores value under key
+func (m *MemoryCache) Set(key, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = value
+}
+
+// FileCache is a Cache storing one file per entry in a directory, so
+// results survive between runs
+type FileCache struct {
+	dir string
+}
+
+// NewFileCache creates a cache backed by dir, which is created on first write
+func NewFileCache(dir string) *FileCache {
+	return &FileCache{dir: dir}
+}
+
+// Get returns the cached value for key
+func (f *FileCache) Get(key string) (string, bool) {
+	data, err := os.ReadFile(f.path(key))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// Set stores value under key. Write failures only cost a future cache miss,
+// so they are ignored.
+func (f *FileCache) Set(key, value string) {
+	_ = WriteFile(f.path(key), value)
+}
+
+// path returns the file holding key
+func (f *FileCache) path(key string) string {
+	return filepath.Join(f.dir, key+".lua")
+}
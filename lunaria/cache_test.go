@@ -0,0 +1,143 @@
+package lunaria
+
+import "testing"
+
+func TestCacheHitSkipsParsing(t *testing.T) {
+	cache := NewMemoryCache()
+	compiler := NewCompiler().WithCache(cache)
+
+	// Invalid XML only compiles successfully if the cached result is used
+	xml := `<not valid xml`
+	cache.Set(compiler.cacheKey(xml), "cached()")
+
+	result, err := compiler.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Expected cache hit, got error: %v", err)
+	}
+	if result != "cached()" {
+		t.Errorf("Expected cached result, got: %s", result)
+	}
+}
+
+func TestCacheStoresResults(t *testing.T) {
+	for name, cache := range map[string]Cache{
+		"memory": NewMemoryCache(),
+		"file":   NewFileCache(t.TempDir()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			compiler := NewCompiler().WithCache(cache)
+			xml := `<set var="x" local="true">42</set>`
+
+			result, err := compiler.CompileFromString(xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			cached, ok := cache.Get(compiler.cacheKey(xml))
+			if !ok || cached != result {
+				t.Errorf("Expected %q to be cached, got %q (found: %v)", result, cached, ok)
+			}
+		})
+	}
+}
+
+func TestCacheKeyDependsOnHandlersAndOptions(t *testing.T) {
+	xml := `<set var="x">1</set>`
+	compiler := NewCompiler()
+	base := compiler.cacheKey(xml)
+
+	compiler.Register("custom", func(node Node, c *Compiler) (string, error) {
+		return "", nil
+	})
+	withHandler := compiler.cacheKey(xml)
+	if withHandler == base {
+		t.Error("Expected registering a handler to change the cache key")
+	}
+
+	compiler.SetOptions(CompileOptions{Minify: true})
+	if compiler.cacheKey(xml) == withHandler {
+		t.Error("Expected changing options to change the cache key")
+	}
+
+	if base != NewCompiler().cacheKey(xml) {
+		t.Error("Expected identical compilers to produce identical keys")
+	}
+}
+
+func TestCacheSkippedWithFallback(t *testing.T) {
+	cache := NewMemoryCache()
+	first := NewCompiler().WithCache(cache)
+	first.SetFallback(func(node Node, c *Compiler) (string, error) {
+		return "first()", nil
+	})
+	second := first.Clone()
+	second.SetFallback(func(node Node, c *Compiler) (string, error) {
+		return "second()", nil
+	})
+
+	xml := `<unknown/>`
+	if _, err := first.CompileFromString(xml); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if _, ok := cache.Get(first.cacheKey(xml)); ok {
+		t.Error("Expected output of a compiler with a fallback not to be cached")
+	}
+
+	result, err := second.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if result != "second()" {
+		t.Errorf("Expected the clone's fallback output, got: %s", result)
+	}
+}
+
+func TestCacheSkippedWithReplacedHandler(t *testing.T) {
+	cache := NewMemoryCache()
+	first := NewCompiler().WithCache(cache)
+	second := first.Clone()
+	second.Register("print", func(node Node, c *Compiler) (string, error) {
+		return "myprint()", nil
+	})
+
+	xml := `<print>"hi"</print>`
+	if _, err := first.CompileFromString(xml); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	result, err := second.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if result != "myprint()" {
+		t.Errorf("Expected the replaced handler's output, got: %s", result)
+	}
+}
+
+func TestCacheSkippedWithMiddleware(t *testing.T) {
+	cache := NewMemoryCache()
+	first := NewCompiler().WithCache(cache)
+	second := first.Clone()
+	second.Use(func(node Node, next Handler) Handler {
+		return func(node Node, c *Compiler) (string, error) {
+			return "-- wrapped", nil
+		}
+	})
+
+	xml := `<print>"hi"</print>`
+	if _, err := first.CompileFromString(xml); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	result, err := second.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if result != "-- wrapped" {
+		t.Errorf("Expected the middleware's output, got: %s", result)
+	}
+}
+
+func TestCacheUsedByNewCompiler(t *testing.T) {
+	if compiler := NewCompiler().WithCache(NewMemoryCache()); !compiler.cacheable() {
+		t.Error("Expected a compiler with only built-in handlers to use the cache")
+	}
+}
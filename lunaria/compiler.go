@@ -1,12 +1,17 @@
 package lunaria
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 )
 
+// Version is the version of the Lunaria language implemented by this package
+const Version = "1.0.0"
+
 // Node represents a parsed XML node
 type Node struct {
 	XMLName xml.Name
@@ -18,16 +23,276 @@ type Node struct {
 // Handler is a function that processes a specific XML tag
 type Handler func(node Node, compiler *Compiler) (string, error)
 
+// HandlerSpec describes the attributes and content a tag accepts, for tools
+// such as editors that complete or validate documents. It is documentation
+// only: handlers still check their own attributes.
+type HandlerSpec struct {
+	// Attributes lists every attribute the tag reads
+	Attributes []string
+
+	// Required lists the attributes the tag cannot do without. Attributes
+	// that are only needed in some forms of the tag are not listed.
+	Required []string
+
+	// Body reports whether the tag takes text content or child elements
+	Body bool
+}
+
+// Middleware wraps the handler selected for a node, e.g. to time or
+// rewrite its output. Calling next runs the remaining chain.
+type Middleware func(node Node, next Handler) Handler
+
+// Warning is a non-fatal diagnostic produced during compilation
+type Warning struct {
+	Tag     string
+	Message string
+}
+
+// String formats the warning for display
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Tag, w.Message)
+}
+
+// CompileError reports a tag that cannot be compiled where it appears
+type CompileError struct {
+	Tag     string
+	Message string
+}
+
+// Error implements the error interface
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Tag, e.Message)
+}
+
+// CompileOptions controls how Luau output is generated
+type CompileOptions struct {
+	// Minify strips comments and indentation from the output. Statements
+	// stay newline-separated so they can never merge into invalid code.
+	Minify bool
+
+	// NoTrailingComma leaves out the comma after the last entry of a
+	// multi-line table, which otherwise ends with one like every other entry
+	NoTrailingComma bool
+
+	// BacktickStrings compiles {{...}} placeholders in <print>, <warn> and
+	// <error> to Luau backtick strings instead of tostring concatenation.
+	// Leave it off when the output must also run as plain Lua.
+	BacktickStrings bool
+
+	// Semicolons terminates each statement with ';' for tooling that
+	// rejects Luau's ambiguous newline-separated call syntax. Block
+	// statements, comments and <raw> code are left as written.
+	Semicolons bool
+
+	// Target selects the language dialect of the output. The zero value
+	// targets Luau.
+	Target Target
+
+	// BaseDir is the directory relative <include> paths are resolved
+	// against. Empty means the working directory, except that
+	// CompileFromFile uses the compiled file's own directory.
+	BaseDir string
+
+	// WrapScript wraps the whole output in a scope, indenting everything
+	// inside it, so that top-level locals do not leak into the enclosing
+	// code. The zero value leaves the output unwrapped.
+	WrapScript ScriptWrap
+
+	// StrictMode prepends a Luau type checking directive such as --!strict
+	// to the output of <script> and <module> documents. It takes
+	// precedence over a <pragma> in the document, which is then ignored
+	// with a warning unless it asks for the same mode.
+	StrictMode StrictMode
+
+	// InterpolationDelimiters replaces the {{ and }} around placeholders
+	// such as {{name}}, for content that holds double braces of its own.
+	// The raw form wraps the expression in braces inside them, e.g.
+	// <%{name}%> for <% and %>. The zero value keeps the default.
+	InterpolationDelimiters Delimiters
+
+	// SafeInterpolation only lets placeholders name a variable or a field,
+	// such as {{player.Name}}, rejecting calls, indexing and operators, for
+	// documents from untrusted sources.
+	SafeInterpolation bool
+
+	// AutoReverseFor gives a numeric <for> without a 'step' a step of -1
+	// when its literal bounds count down, e.g. from="10" to="1", instead
+	// of emitting a loop that never runs.
+	AutoReverseFor bool
+}
+
+// Target is a language dialect the compiler can emit
+type Target string
+
+const (
+	// TargetLuau is Roblox's Luau, the default target
+	TargetLuau Target = "luau"
+
+	// TargetLua51 is standard Lua 5.1. Luau-only constructs are rewritten
+	// where Lua 5.1 has an equivalent and rejected otherwise.
+	TargetLua51 Target = "lua51"
+)
+
+// ParseTarget converts a target name such as "lua51" to a Target
+func ParseTarget(name string) (Target, error) {
+	switch target := Target(name); target {
+	case TargetLuau, TargetLua51:
+		return target, nil
+	default:
+		return "", fmt.Errorf("unknown target: %s (expected luau or lua51)", name)
+	}
+}
+
+// Delimiters open and close an interpolation placeholder
+type Delimiters struct {
+	Open  string
+	Close string
+}
+
+// DefaultDelimiters are the {{ and }} of {{expr}} placeholders
+var DefaultDelimiters = Delimiters{Open: "{{", Close: "}}"}
+
+// ScriptWrap is a scope the output of a whole script can be wrapped in
+type ScriptWrap string
+
+const (
+	// WrapNone leaves the output unwrapped
+	WrapNone ScriptWrap = ""
+
+	// WrapDo wraps the output in a do ... end block
+	WrapDo ScriptWrap = "do"
+
+	// WrapFunction wraps the output in an immediately called function,
+	// return (function(...) ... end)(...), so a top-level <return> becomes
+	// the value of a ModuleScript
+	WrapFunction ScriptWrap = "function"
+)
+
+// StrictMode is a Luau type checking mode set by a directive comment
+type StrictMode string
+
+const (
+	// StrictModeNone emits no directive; the zero value means the same
+	StrictModeNone StrictMode = "none"
+
+	// StrictModeNonstrict emits --!nonstrict
+	StrictModeNonstrict StrictMode = "nonstrict"
+
+	// StrictModeStrict emits --!strict
+	StrictModeStrict StrictMode = "strict"
+)
+
+// strictDirective returns the pragma mode for the StrictMode option, which
+// is empty when no directive is wanted
+func (c *Compiler) strictDirective() (string, error) {
+	switch mode := c.options.StrictMode; mode {
+	case "", StrictModeNone:
+		return "", nil
+	case StrictModeNonstrict, StrictModeStrict:
+		return string(mode), nil
+	default:
+		return "", fmt.Errorf("unknown strict mode: %s (expected none, nonstrict or strict)", mode)
+	}
+}
+
+// isScriptRoot reports whether a root element named name holds a list of
+// statements rather than being a single command
+func isScriptRoot(name string) bool {
+	return name == "script" || name == "module"
+}
+
+// scriptWrapper returns the lines opening and closing the WrapScript scope,
+// which are empty when the output is not wrapped
+func (c *Compiler) scriptWrapper() (string, string, error) {
+	switch c.options.WrapScript {
+	case WrapNone:
+		return "", "", nil
+	case WrapDo:
+		return "do", "end", nil
+	case WrapFunction:
+		return "return (function(...)", "end)(...)", nil
+	default:
+		return "", "", fmt.Errorf("unknown script wrap: %s (expected do or function)", c.options.WrapScript)
+	}
+}
+
+// DefaultCompileOptions returns the options used by NewCompiler, which are
+// the zero CompileOptions
+func DefaultCompileOptions() CompileOptions {
+	return CompileOptions{}
+}
+
 // Compiler manages the compilation process
 type Compiler struct {
-	handlers map[string]Handler
-	indent   int
+	handlers   map[string]Handler
+	specs      map[string]HandlerSpec
+	fallback   Handler
+	middleware []Middleware
+	options    CompileOptions
+	cache      Cache
+	indent     int
+	scopes     []map[string]bool
+	warnings   []Warning
+	pragma     string
+
+	// replaced reports whether a registered handler, such as a built-in, has
+	// been replaced by another for the same tag
+	replaced bool
+
+	// ctx cancels the compilation in progress
+	ctx context.Context
+
+	// loopDepth counts the loops enclosing the node being compiled,
+	// reset at function boundaries
+	loopDepth int
+
+	// functionDepth counts the <function> bodies enclosing the node being
+	// compiled
+	functionDepth int
+
+	// inVarargFunction reports whether ... is usable where the node being
+	// compiled appears: in a variadic function or at the top level chunk
+	inVarargFunction bool
+
+	// includes lists the files being included, innermost last, to resolve
+	// nested paths and detect include cycles
+	includes []string
+
+	// uncacheable reports whether the output depends on more than the
+	// document, e.g. on included files
+	uncacheable bool
+
+	// exported reports whether the script root has an <export>
+	exported bool
+
+	// onceCount numbers the flags of the <once> blocks without a 'flag'
+	onceCount int
+
+	// memoizeCount numbers the cache tables of <memoize>
+	memoizeCount int
+
+	// hoisted holds the chunk-scope declarations made while compiling the
+	// current top-level statement, which are emitted before it
+	hoisted []string
+
+	// placeholders finds the interpolation placeholders for the
+	// InterpolationDelimiters option
+	placeholders *placeholders
+
+	// macros holds the <define>d macros of the document being compiled
+	macros map[string]macro
+
+	// expanding lists the macros being expanded, innermost last, to detect
+	// recursive macros
+	expanding []string
 }
 
 // NewCompiler creates a new compiler instance
 func NewCompiler() *Compiler {
 	c := &Compiler{
 		handlers: make(map[string]Handler),
+		specs:    make(map[string]HandlerSpec),
+		options:  DefaultCompileOptions(),
 		indent:   0,
 	}
 
@@ -36,32 +301,396 @@ func NewCompiler() *Compiler {
 	return c
 }
 
-// Register adds a custom handler for a specific XML tag
+// Use adds middleware that wraps every handler, built-in or custom.
+// Middleware composes in registration order: the first registered is the
+// outermost wrapper.
+func (c *Compiler) Use(mw Middleware) {
+	c.middleware = append(c.middleware, mw)
+}
+
+// SetFallback sets the handler for tags with no registered handler, in
+// place of the "unknown tag" error. Middleware wraps it like any other
+// handler. A nil handler restores the error.
+func (c *Compiler) SetFallback(handler Handler) {
+	c.fallback = handler
+}
+
+// NewCompilerWithOptions creates a new compiler instance using the given options
+func NewCompilerWithOptions(opts CompileOptions) *Compiler {
+	c := NewCompiler()
+	c.options = opts
+	return c
+}
+
+// Options returns the compiler's current options
+func (c *Compiler) Options() CompileOptions {
+	return c.options
+}
+
+// SetOptions replaces the compiler's options
+func (c *Compiler) SetOptions(opts CompileOptions) {
+	c.options = opts
+}
+
+// Register adds a custom handler for a specific XML tag. Replacing a tag
+// this way drops any spec it was registered with.
 func (c *Compiler) Register(tag string, handler Handler) {
+	if _, ok := c.handlers[tag]; ok {
+		c.replaced = true
+	}
+	c.handlers[tag] = handler
+	delete(c.specs, tag)
+}
+
+// RegisterWithSpec adds a handler for a tag along with a spec describing
+// its attributes, which Spec returns
+func (c *Compiler) RegisterWithSpec(tag string, spec HandlerSpec, handler Handler) {
+	if _, ok := c.handlers[tag]; ok {
+		c.replaced = true
+	}
 	c.handlers[tag] = handler
+	c.specs[tag] = spec
+}
+
+// Spec returns the spec a tag was registered with. It reports false for
+// unknown tags and for tags registered without a spec.
+func (c *Compiler) Spec(tag string) (HandlerSpec, bool) {
+	spec, ok := c.specs[tag]
+	return spec, ok
+}
+
+// Tags returns the registered tags in sorted order
+func (c *Compiler) Tags() []string {
+	tags := make([]string, 0, len(c.handlers))
+	for tag := range c.handlers {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Clone returns a new compiler with copies of all currently registered
+// handlers and their specs, the fallback handler, middleware and the
+// current options. The cache, if any, is shared. Handlers registered on the
+// clone do not affect the original and vice versa. Per-compilation state
+// such as indentation is not copied, so a configured compiler can serve as
+// a template that each goroutine clones before compiling.
+func (c *Compiler) Clone() *Compiler {
+	clone := &Compiler{
+		handlers:   make(map[string]Handler, len(c.handlers)),
+		specs:      make(map[string]HandlerSpec, len(c.specs)),
+		fallback:   c.fallback,
+		middleware: append([]Middleware(nil), c.middleware...),
+		options:    c.options,
+		cache:      c.cache,
+		replaced:   c.replaced,
+	}
+	for tag, handler := range c.handlers {
+		clone.handlers[tag] = handler
+	}
+	for tag, spec := range c.specs {
+		clone.specs[tag] = spec
+	}
+	return clone
 }
 
 // getIndent returns the current indentation string
 func (c *Compiler) getIndent() string {
+	if c.options.Minify {
+		return ""
+	}
 	return strings.Repeat("    ", c.indent)
 }
 
+// Indent raises the indentation of the code compiled after it by one level.
+// A custom block handler calls it before compiling its body and Dedent
+// afterwards.
+func (c *Compiler) Indent() {
+	c.indent++
+}
+
+// Dedent lowers the indentation raised by Indent
+func (c *Compiler) Dedent() {
+	if c.indent > 0 {
+		c.indent--
+	}
+}
+
+// CurrentIndent returns the indentation to prefix the current line with.
+// It is empty when the Minify option is set.
+func (c *Compiler) CurrentIndent() string {
+	return c.getIndent()
+}
+
+// Warnings returns the warnings produced by the most recent compilation
+func (c *Compiler) Warnings() []Warning {
+	return c.warnings
+}
+
+// warn records a non-fatal diagnostic for the given tag
+func (c *Compiler) warn(tag, format string, args ...any) {
+	c.warnings = append(c.warnings, Warning{Tag: tag, Message: fmt.Sprintf(format, args...)})
+}
+
+// reset clears per-compilation state before a new document is compiled
+func (c *Compiler) reset(ctx context.Context) {
+	c.ctx = ctx
+	c.indent = 0
+	c.loopDepth = 0
+	c.functionDepth = 0
+	c.inVarargFunction = true
+	c.scopes = []map[string]bool{{}}
+	c.warnings = nil
+	c.pragma = ""
+	if c.options.WrapScript != WrapNone {
+		c.indent = 1
+	}
+	c.exported = false
+	c.onceCount = 0
+	c.memoizeCount = 0
+	c.hoisted = nil
+	c.includes = nil
+	c.uncacheable = false
+	c.macros = map[string]macro{}
+	c.expanding = nil
+}
+
+// atScriptRoot reports whether the node being compiled is a top-level
+// statement of the script, inside any WrapScript scope
+func (c *Compiler) atScriptRoot() bool {
+	if c.options.WrapScript != WrapNone {
+		return c.indent == 1
+	}
+	return c.indent == 0
+}
+
+// targetDialect returns the dialect the output is compiled for
+func (c *Compiler) targetDialect() Target {
+	if c.options.Target == "" {
+		return TargetLuau
+	}
+	return c.options.Target
+}
+
+// checkOptions rejects an unknown Target or WrapScript, or unusable
+// InterpolationDelimiters, before compiling
+func (c *Compiler) checkOptions() error {
+	if _, err := ParseTarget(string(c.targetDialect())); err != nil {
+		return err
+	}
+	if err := c.setPlaceholders(); err != nil {
+		return err
+	}
+	if _, err := c.strictDirective(); err != nil {
+		return err
+	}
+	_, _, err := c.scriptWrapper()
+	return err
+}
+
+// setPlaceholders builds the pattern for the InterpolationDelimiters
+// option, which must both be set and differ, or both be left empty
+func (c *Compiler) setPlaceholders() error {
+	d := c.options.InterpolationDelimiters
+	switch {
+	case d == Delimiters{} || d == DefaultDelimiters:
+		c.placeholders = defaultPlaceholders
+	case d.Open == "" || d.Close == "":
+		return fmt.Errorf("interpolation delimiters must not be empty")
+	case d.Open == d.Close:
+		return fmt.Errorf("interpolation delimiters must differ: %s", d.Open)
+	case c.placeholders == nil || c.placeholders.Delimiters != d:
+		c.placeholders = newPlaceholders(d)
+	}
+	return nil
+}
+
+// interpolation returns the placeholders of the document being compiled
+func (c *Compiler) interpolation() *placeholders {
+	if c.placeholders == nil {
+		return defaultPlaceholders
+	}
+	return c.placeholders
+}
+
+// checkInterpolation rejects the placeholders in text of a tag that do
+// not name a variable or field when the SafeInterpolation option is set
+func (c *Compiler) checkInterpolation(tag, text string) error {
+	if !c.options.SafeInterpolation {
+		return nil
+	}
+	for _, m := range c.interpolation().pattern.FindAllStringSubmatchIndex(text, -1) {
+		if expr, _ := placeholderExpr(text, m); !isFieldPath(expr) {
+			return &CompileError{Tag: tag, Message: "unsafe interpolation of " + expr + ": only variables and fields can be interpolated"}
+		}
+	}
+	return nil
+}
+
+// pushScope opens a new block scope for local declarations
+func (c *Compiler) pushScope() {
+	c.scopes = append(c.scopes, map[string]bool{})
+}
+
+// popScope closes the innermost block scope
+func (c *Compiler) popScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// declareLocal records a local declaration in the current scope, warning
+// when the name was already declared in the same block. Shadowing a name
+// from an enclosing scope is legal Luau and is not reported.
+func (c *Compiler) declareLocal(tag, name string) {
+	if len(c.scopes) == 0 {
+		c.pushScope()
+	}
+	scope := c.scopes[len(c.scopes)-1]
+	if scope[name] {
+		c.warn(tag, "local '%s' is redeclared in the same scope", name)
+		return
+	}
+	scope[name] = true
+}
+
+// hoistLocal declares the local name with the given initial value at chunk
+// scope, before the top-level statement being compiled, for state that
+// must outlive the function or loop it is used in
+func (c *Compiler) hoistLocal(tag, name, value string) {
+	if len(c.scopes) == 0 {
+		c.pushScope()
+	}
+	if c.scopes[0][name] {
+		c.warn(tag, "local '%s' is redeclared in the same scope", name)
+	}
+	c.scopes[0][name] = true
+
+	indent := c.indent
+	c.indent = 0
+	if c.options.WrapScript != WrapNone {
+		c.indent = 1
+	}
+	decl := fmt.Sprintf("%slocal %s = %s%s", c.getIndent(), name, value, c.terminator())
+	c.indent = indent
+	c.hoisted = append(c.hoisted, decl)
+}
+
+// blockKeywords begin statements that close with end or until and need no
+// terminating semicolon
+var blockKeywords = []string{"if", "while", "for", "repeat", "do", "function", "local function"}
+
+// unterminatedTags produce code that must not gain a semicolon, either
+// because it is written by hand, because it continues an enclosing block
+// or because the handler terminates each of its statements itself
+var unterminatedTags = map[string]bool{"raw": true, "comment": true, "elseif": true, "else": true, "include": true, "use": true, "memoize": true, "destructure": true}
+
+// terminator returns the text ending a statement that a handler builds
+// itself rather than compiling through compileStatement: ";" when the
+// Semicolons option is set
+func (c *Compiler) terminator() string {
+	if c.options.Semicolons {
+		return ";"
+	}
+	return ""
+}
+
+// compileStatement compiles node in statement position, terminating it
+// with a semicolon when the Semicolons option is set
+func (c *Compiler) compileStatement(node Node) (string, error) {
+	// A lambda is an expression, so on its own it must be assigned
+	if node.XMLName.Local == "function" && GetBoolAttr(node, "lambda") && GetAttr(node, "var") == "" {
+		return "", &CompileError{Tag: "function", Message: "lambda function used as a statement requires 'var' attribute"}
+	}
+
+	code, err := c.compileNode(node)
+	if err != nil || code == "" || !c.options.Semicolons || unterminatedTags[node.XMLName.Local] {
+		return code, err
+	}
+
+	first, _, _ := strings.Cut(strings.TrimSpace(code), "\n")
+	for _, keyword := range blockKeywords {
+		if first == keyword || strings.HasPrefix(first, keyword+" ") || strings.HasPrefix(first, keyword+"(") {
+			return code, nil
+		}
+	}
+	if strings.HasPrefix(first, "--") || strings.HasSuffix(code, ";") {
+		return code, nil
+	}
+	return code + ";", nil
+}
+
+// compileRootStatement compiles a top-level statement of the script,
+// preceded by the declarations hoisted while compiling it
+func (c *Compiler) compileRootStatement(node Node) (string, error) {
+	exported := c.exported
+	code, err := c.compileStatement(node)
+	if err != nil {
+		return "", err
+	}
+
+	// The export compiles to a return, which must end the chunk
+	if exported && code != "" && node.XMLName.Local != "comment" {
+		return "", &CompileError{Tag: node.XMLName.Local, Message: "statement after the module export; export must be the last statement"}
+	}
+
+	hoisted := c.hoisted
+	c.hoisted = nil
+	if code != "" {
+		hoisted = append(hoisted, code)
+	}
+	return strings.Join(hoisted, "\n"), nil
+}
+
+// CompileChildren compiles the child elements of node as statements at
+// the current indentation and joins them with newlines, leaving out those
+// that produce no code. Block handlers call it between raising and
+// lowering the indentation to compile their body.
+func (c *Compiler) CompileChildren(node Node) (string, error) {
+	return c.compileStatements(node.Nodes)
+}
+
+// compileStatements compiles nodes as statements, see CompileChildren
+func (c *Compiler) compileStatements(nodes []Node) (string, error) {
+	var results []string
+	for _, node := range nodes {
+		code, err := c.compileStatement(node)
+		if err != nil {
+			return "", err
+		}
+		if code != "" {
+			results = append(results, code)
+		}
+	}
+	return strings.Join(results, "\n"), nil
+}
+
 // compileNode processes a single XML node
 func (c *Compiler) compileNode(node Node) (string, error) {
-	// Skip text nodes that are just whitespace
+	if err := c.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// The decoder folds text into the parent's Content, so a nameless node
+	// only comes from a handler building nodes by hand. Whitespace is
+	// formatting; anything else has no statement to compile to.
 	if node.XMLName.Local == "" {
 		content := strings.TrimSpace(node.Content)
 		if content == "" {
 			return "", nil
 		}
-		// This is unexpected content - might be an error
 		return "", fmt.Errorf("unexpected text content: %s", content)
 	}
 
 	// Look up handler for this tag
 	handler, exists := c.handlers[node.XMLName.Local]
 	if !exists {
-		return "", fmt.Errorf("unknown tag: %s", node.XMLName.Local)
+		if c.fallback == nil {
+			return "", fmt.Errorf("unknown tag: %s", node.XMLName.Local)
+		}
+		handler = c.fallback
+	}
+
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		handler = c.middleware[i](node, handler)
 	}
 
 	return handler(node, c)
@@ -69,16 +698,53 @@ func (c *Compiler) compileNode(node Node) (string, error) {
 
 // CompileFromString compiles an XML string using this compiler instance
 func (c *Compiler) CompileFromString(s string) (string, error) {
-	var root Node
-	if err := xml.Unmarshal([]byte(s), &root); err != nil {
-		return "", fmt.Errorf("XML parse error: %w", err)
+	return c.CompileFromStringContext(context.Background(), s)
+}
+
+// CompileFromStringContext compiles an XML string, returning ctx.Err()
+// promptly once ctx is cancelled or its deadline passes
+func (c *Compiler) CompileFromStringContext(ctx context.Context, s string) (string, error) {
+	c.reset(ctx)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := c.checkOptions(); err != nil {
+		return "", err
+	}
+
+	cacheable := c.cacheable()
+
+	var key string
+	if cacheable {
+		key = c.cacheKey(s)
+		if cached, ok := c.cache.Get(key); ok {
+			return cached, nil
+		}
 	}
 
-	// Handle root script tag
-	if root.XMLName.Local == "script" {
-		var results []string
+	result, err := c.compileDocument(s)
+	if err != nil {
+		return "", err
+	}
+
+	if cacheable && !c.uncacheable {
+		c.cache.Set(key, result)
+	}
+	return result, nil
+}
+
+// compileDocument parses and compiles a whole XML document
+func (c *Compiler) compileDocument(s string) (string, error) {
+	root, err := c.parse(strings.NewReader(s))
+	if err != nil {
+		return "", err
+	}
+
+	var results []string
+	if isScriptRoot(root.XMLName.Local) {
+		c.pragma, _ = c.strictDirective()
 		for _, child := range root.Nodes {
-			code, err := c.compileNode(child)
+			code, err := c.compileRootStatement(child)
 			if err != nil {
 				return "", err
 			}
@@ -86,11 +752,27 @@ func (c *Compiler) CompileFromString(s string) (string, error) {
 				results = append(results, code)
 			}
 		}
-		return strings.Join(results, "\n"), nil
+	} else {
+		// Single command
+		code, err := c.compileRootStatement(root)
+		if err != nil {
+			return "", err
+		}
+		if code != "" {
+			results = append(results, code)
+		}
 	}
 
-	// Single command
-	return c.compileNode(root)
+	if header, footer, _ := c.scriptWrapper(); header != "" {
+		results = append(append([]string{header}, results...), footer)
+	}
+
+	// A <pragma> must be the first line wherever it appears
+	if c.pragma != "" {
+		results = append([]string{"--!" + c.pragma}, results...)
+	}
+
+	return strings.Join(results, "\n"), nil
 }
 
 // CompileFromReader compiles XML from an io.Reader using this compiler instance
@@ -102,6 +784,115 @@ func (c *Compiler) CompileFromReader(r io.Reader) (string, error) {
 	return c.CompileFromString(string(data))
 }
 
+// CompileStream compiles XML from r and writes Luau to w one top-level
+// statement at a time, so neither the whole document tree nor the whole
+// output has to be held in memory. The output matches CompileFromString.
+func (c *Compiler) CompileStream(r io.Reader, w io.Writer) error {
+	c.reset(context.Background())
+	if err := c.checkOptions(); err != nil {
+		return err
+	}
+
+	d := newDecoder(r)
+	start, err := c.readProlog(d)
+	if err != nil {
+		return err
+	}
+
+	header, footer, _ := c.scriptWrapper()
+	written, pragmaWritten, opened := false, false, false
+	emit := func(code string) error {
+		// The wrapper opens with the first statement, after any pragma
+		if code != "" && header != "" && !opened {
+			opened = true
+			code = header + "\n" + code
+		}
+
+		// Output is already written, so a <pragma> can only be honoured
+		// before the first statement
+		if c.pragma != "" && !pragmaWritten {
+			if written {
+				return &CompileError{Tag: "pragma", Message: "pragma must precede other statements when streaming"}
+			}
+			pragmaWritten = true
+			code = strings.TrimSuffix("--!"+c.pragma+"\n"+code, "\n")
+		}
+		if code == "" {
+			return nil
+		}
+		if written {
+			code = "\n" + code
+		}
+		if _, err := io.WriteString(w, code); err != nil {
+			return err
+		}
+		written = true
+		return nil
+	}
+
+	// The wrapper closes once the last statement has been written
+	finish := func() error {
+		if header == "" {
+			return nil
+		}
+		code := footer
+		if !opened {
+			code = header + "\n" + footer
+		}
+		if written {
+			code = "\n" + code
+		}
+		_, err := io.WriteString(w, code)
+		return err
+	}
+
+	// Single command
+	if !isScriptRoot(start.Name.Local) {
+		var node Node
+		if err := d.DecodeElement(&node, &start); err != nil {
+			return fmt.Errorf("XML parse error: %w", err)
+		}
+		code, err := c.compileRootStatement(node)
+		if err != nil {
+			return err
+		}
+		if err := emit(code); err != nil {
+			return err
+		}
+		return finish()
+	}
+
+	c.pragma, _ = c.strictDirective()
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("XML parse error: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var child Node
+			if err := d.DecodeElement(&child, &t); err != nil {
+				return fmt.Errorf("XML parse error: %w", err)
+			}
+			code, err := c.compileRootStatement(child)
+			if err != nil {
+				return err
+			}
+			if err := emit(code); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			// End of the root script tag. A directive is still due if the
+			// script has no statements.
+			if err := emit(""); err != nil {
+				return err
+			}
+			return finish()
+		}
+	}
+}
+
 // Package-level convenience functions using default compiler
 var defaultCompiler = NewCompiler()
 
@@ -115,12 +906,40 @@ func CompileString(s string) (string, error) {
 	return defaultCompiler.CompileFromString(s)
 }
 
+// CompileStringContext compiles an XML string to Luau code using the default
+// compiler, stopping early when ctx is cancelled
+func CompileStringContext(ctx context.Context, s string) (string, error) {
+	return defaultCompiler.CompileFromStringContext(ctx, s)
+}
+
 // CompileReader compiles XML from an io.Reader to Luau code using the default compiler
 func CompileReader(r io.Reader) (string, error) {
 	return defaultCompiler.CompileFromReader(r)
 }
 
+// CompileStream compiles XML from r to w using the default compiler
+func CompileStream(r io.Reader, w io.Writer) error {
+	return defaultCompiler.CompileStream(r, w)
+}
+
 // Register adds a handler to the default compiler
 func Register(tag string, handler Handler) {
 	defaultCompiler.Register(tag, handler)
 }
+
+// RegisterWithSpec adds a handler and its spec to the default compiler
+func RegisterWithSpec(tag string, spec HandlerSpec, handler Handler) {
+	defaultCompiler.RegisterWithSpec(tag, spec, handler)
+}
+
+// ResetDefault rebuilds the default compiler from NewCompiler, restoring
+// only the built-in handlers. All handlers added through the package-level
+// Register are discarded.
+func ResetDefault() {
+	defaultCompiler = NewCompiler()
+}
+
+// Reset is equivalent to ResetDefault
+func Reset() {
+	ResetDefault()
+}
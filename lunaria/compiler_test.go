@@ -1,8 +1,13 @@
 package lunaria
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -20,6 +25,204 @@ func TestBasicSet(t *testing.T) {
 	}
 }
 
+func TestSetTableField(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Field", `<set var="config.debug">true</set>`, "config.debug = true"},
+		{"String key", `<set var='t["key"]'>v</set>`, `t["key"] = v`},
+		{"Nested", `<set var="a.b.c">v</set>`, "a.b.c = v"},
+		{"Index expression", `<set var="cache[key].hits">cache[key].hits + 1</set>`, "cache[key].hits = cache[key].hits + 1"},
+		{"Explicit non-local", `<set var="t.x" local="false">1</set>`, "t.x = 1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestSetMultiple(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Local pair", `<set vars="a, b" local="true">1, 2</set>`, `local a, b = 1, 2`},
+		{"Swap", `<set vars="a,b">b, a</set>`, `a, b = b, a`},
+		{"Call fills remaining", `<set vars="ok, err" local="true">pcall(f)</set>`, `local ok, err = pcall(f)`},
+		{"Method call", `<set vars="x, y, z">1, obj:coords("a, b")</set>`, `x, y, z = 1, obj:coords("a, b")`},
+		{"Vararg", `<set vars="first, second" local="true">...</set>`, `local first, second = ...`},
+		{"Table fields", `<set vars="self.x, t[1]">f()</set>`, `self.x, t[1] = f()`},
+		{"Nested table values", `<set vars="t, n">{1, 2}, select("#", a, b)</set>`, `t, n = {1, 2}, select("#", a, b)`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestSetMultipleErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Too few values", `<set vars="a, b, c">1, 2</set>`},
+		{"Too many values", `<set vars="a">1, 2</set>`},
+		{"Parenthesized call", `<set vars="a, b">(f())</set>`},
+		{"Call not last", `<set vars="a, b, c">f(), 1</set>`},
+		{"Invalid target", `<set vars="a, 1b">1, 2</set>`},
+		{"Local table field", `<set vars="a, b.c" local="true">1, 2</set>`},
+		{"Missing value", `<set vars="a, b"></set>`},
+		{"Both var and vars", `<set var="a" vars="a, b">1, 2</set>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+		warnings int
+	}{
+		{"Warns by default", `<global var="Config">{}</global>`, `Config = {}`, 1},
+		{"Suppressed", `<global var="Config" suppress-warning="true">{}</global>`, `Config = {}`, 0},
+		{"Through _G", `<global var="_G.Config">{}</global>`, `_G.Config = {}`, 0},
+		{"Indexed _G", `<global var='_G["Config"]'>{}</global>`, `_G["Config"] = {}`, 0},
+		{"Field of a global", `<global var="Shared.settings">{}</global>`, `Shared.settings = {}`, 1},
+		{"Set with global scope", `<set var="Config" scope="global">{}</set>`, `Config = {}`, 1},
+		{"Set through _G", `<set var="_G.Config" scope="global">{}</set>`, `_G.Config = {}`, 0},
+		{"Set with local scope", `<set var="config" scope="local">{}</set>`, `local config = {}`, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompiler()
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+
+			warnings := compiler.Warnings()
+			if len(warnings) != tc.warnings {
+				t.Fatalf("Expected %d warnings, got: %v", tc.warnings, warnings)
+			}
+			if tc.warnings > 0 && !strings.Contains(warnings[0].Message, "global") {
+				t.Errorf("Expected global assignment warning, got: %v", warnings[0])
+			}
+		})
+	}
+
+	for _, xml := range []string{
+		`<global>1</global>`,
+		`<global var="x"></global>`,
+		`<global var="1x">1</global>`,
+		`<global var="f().x">1</global>`,
+		`<set var="x" scope="global" local="true">1</set>`,
+		`<set var="x" scope="module">1</set>`,
+	} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
+	}
+}
+
+func TestUnset(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Identifier", `<unset var="x"/>`, `x = nil`},
+		{"Field", `<unset var="self.connection"/>`, `self.connection = nil`},
+		{"Index", `<unset var="cache[key]"/>`, `cache[key] = nil`},
+		{"Nested", `<while test="true"><unset var='players[id]["data"]'/></while>`, "while true do\n    players[id][\"data\"] = nil\nend"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	for _, xml := range []string{`<unset/>`, `<unset var=""/>`, `<unset var="f()"/>`, `<unset var="a[]"/>`, `<unset var="obj:method"/>`} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
+	}
+}
+
+func TestLazy(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Global", `<lazy var="cache" default="{}"/>`, `cache = cache or {}`},
+		{"Explicit non-local", `<lazy var="cache" default="{}" local="false"/>`, `cache = cache or {}`},
+		{"Local", `<lazy var="options" default="{}" local="true"/>`, `local options = options or {}`},
+		{"Field", `<lazy var="self.items" default="{}"/>`, `self.items = self.items or {}`},
+		{"Parameter default", `<function name="greet" params="name"><lazy var="name" default='"stranger"'/><print>name</print></function>`, "function greet(name)\n    name = name or \"stranger\"\n    print(name)\nend"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	for _, xml := range []string{`<lazy default="1"/>`, `<lazy var="x"/>`, `<lazy var="t.x" default="1" local="true"/>`, `<lazy var="f()" default="1"/>`} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
+	}
+}
+
 func TestPrintWithInterpolation(t *testing.T) {
 	xml := `<script>
   <set var="name" local="true">"World"</set>
@@ -39,113 +242,271 @@ print("Hello, " .. tostring(name) .. "!")`
 	}
 }
 
-func TestIfStatement(t *testing.T) {
-	xml := `<if test="x > 0">
-  <print>"Positive"</print>
-</if>`
+func TestOutputCommands(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		args    string
+	}{
+		{"Expression", `"Hello"`, `"Hello"`},
+		{"Interpolation", `Hi {{name}}`, `"Hi " .. tostring(name) .. ""`},
+		{"Quotes are escaped", `Say "{{word}}"`, `"Say \"" .. tostring(word) .. "\""`},
+		{"Raw splice", `Hi {{{name}}} ({{age}})`, `"Hi " .. (name) .. " (" .. tostring(age) .. ")"`},
+		{
+			name: "Multi-line interpolation",
+			content: `
+      Player {{player.Name}} joined
+      Score: {{score}}
+    `,
+			args: `"Player " .. tostring(player.Name) .. " joined\nScore: " .. tostring(score) .. ""`,
+		},
+	}
 
-	expected := `if x > 0 then
-    print("Positive")
-end`
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// print and warn must treat identical content identically
+			for _, tag := range []string{"print", "warn"} {
+				xml := fmt.Sprintf("<%s>%s</%s>", tag, tc.content, tag)
+				expected := fmt.Sprintf("%s(%s)", tag, tc.args)
 
-	result, err := CompileString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+				result, err := CompileString(xml)
+				if err != nil {
+					t.Fatalf("Compilation failed: %v", err)
+				}
+
+				if result != expected {
+					t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+				}
+			}
+
+			xml := fmt.Sprintf(`<error level="2">%s</error>`, tc.content)
+			expected := fmt.Sprintf("error(%s, 2)", tc.args)
+			result, err := CompileString(xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+			}
+		})
 	}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	for _, tag := range []string{"print", "warn", "error"} {
+		if _, err := CompileString(fmt.Sprintf("<%s> </%s>", tag, tag)); err == nil {
+			t.Errorf("Expected error for empty <%s>", tag)
+		}
 	}
 }
 
-func TestForLoop(t *testing.T) {
-	xml := `<for var="i" from="1" to="10">
-  <print>{{i}}</print>
-</for>`
+func TestOutputArgs(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+		args string
+	}{
+		{"Two args", `<TAG><arg>"x ="</arg><arg>x</arg></TAG>`, `"x =", x`},
+		{"Placeholders are not interpolated", `<TAG><arg>"{{x}}"</arg><arg>y</arg></TAG>`, `"{{x}}", y`},
+		{"Content first", `<TAG>"count:"<arg>#items</arg></TAG>`, `"count:", #items`},
+		{"Nested expression", `<TAG><arg>"sum"</arg><arg><call name="sum"><arg>a</arg><arg>b</arg></call></arg></TAG>`, `"sum", sum(a, b)`},
+		{"Escaped literal", `<TAG><arg>"a&#10;b"</arg></TAG>`, `"a\nb"`},
+	}
 
-	expected := `for i = 1, 10 do
-    print("" .. tostring(i) .. "")
-end`
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, tag := range []string{"print", "warn"} {
+				expected := fmt.Sprintf("%s(%s)", tag, tc.args)
+				result, err := CompileString(strings.ReplaceAll(tc.xml, "TAG", tag))
+				if err != nil {
+					t.Fatalf("Compilation failed: %v", err)
+				}
+				if result != expected {
+					t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+				}
+			}
+		})
+	}
 
-	result, err := CompileString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+	for _, xml := range []string{`<print><arg> </arg></print>`, `<warn><arg>x</arg><set var="y">1</set></warn>`} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
 	}
+}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+func TestErrorMessage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Sentence", `<error>Failed to load data</error>`, `error("Failed to load data", 1)`},
+		{"Single word", `<error>Failed</error>`, `error("Failed", 1)`},
+		{"Commas", `<error>Hello, World!</error>`, `error("Hello, World!", 1)`},
+		{"Comma list", `<error>a, b</error>`, `error("a, b", 1)`},
+		{"Apostrophe", `<error>Can't connect</error>`, `error("Can't connect", 1)`},
+		{"Quotes escaped", `<error>Missing "name" field</error>`, `error("Missing \"name\" field", 1)`},
+		{"Backslash escaped", `<error>Bad path C:\temp</error>`, `error("Bad path C:\\temp", 1)`},
+		{"Tab escaped", "<error>Bad&#9;value here</error>", `error("Bad\tvalue here", 1)`},
+		{"Literal", `<error>'n must be a number'</error>`, `error('n must be a number', 1)`},
+		{"Format call", `<error>string.format("%d items", n)</error>`, `error(string.format("%d items", n), 1)`},
+		{"Expression", `<error expr="true">"Failed: " .. reason</error>`, `error("Failed: " .. reason, 1)`},
+		{"Variable expression", `<error expr="true">message</error>`, `error(message, 1)`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	// print and warn keep passing their content as an expression
+	if result, err := CompileString(`<print>a, b</print>`); err != nil || result != "print(a, b)" {
+		t.Errorf("Expected print content to stay an expression, got %q (error: %v)", result, err)
 	}
 }
 
-func TestForLoopWithStep(t *testing.T) {
-	xml := `<for var="i" from="0" to="10" step="2">
-  <print>{{i}}</print>
-</for>`
+func TestErrorReraise(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Level 0", `<error var="err" level="0"/>`, `error(err, 0)`},
+		{"Default level", `<error var="err"/>`, `error(err, 1)`},
+		{"Level 2", `<error var="caught" level="2"></error>`, `error(caught, 2)`},
+	}
 
-	expected := `for i = 0, 10, 2 do
-    print("" .. tostring(i) .. "")
-end`
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
 
-	result, err := CompileString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
 	}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	for _, xml := range []string{
+		`<error level="0"/>`,
+		`<error var="err">"message"</error>`,
+		`<error var="err.msg" level="0"/>`,
+	} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
 	}
 }
 
-func TestGenericForLoop(t *testing.T) {
-	xml := `<for var="k, v" in="pairs(table)">
-  <print>{{k}}: {{v}}</print>
-</for>`
+func TestSafeInterpolation(t *testing.T) {
+	compiler := NewCompilerWithOptions(CompileOptions{SafeInterpolation: true})
 
-	expected := `for k, v in pairs(table) do
-    print("" .. tostring(k) .. ": " .. tostring(v) .. "")
-end`
+	allowed := map[string]string{
+		`<print>Hi {{name}}</print>`:                                   `print("Hi " .. tostring(name) .. "")`,
+		`<warn>{{ player.Character.Name }} joined</warn>`:              `warn("" .. tostring(player.Character.Name) .. " joined")`,
+		`<error>bad {{{reason}}}</error>`:                              `error("bad " .. (reason) .. "", 1)`,
+		`<assert test="ok">failed for {{id}}</assert>`:                 `assert(ok, "failed for " .. tostring(id) .. "")`,
+		`<print>os.time()</print>`:                                     `print(os.time())`,
+		`<nil-check var="x" else-error="missing {{key}}"></nil-check>`: "if x ~= nil then\nelse\n    error(\"missing \" .. tostring(key) .. \"\", 1)\nend",
+	}
+	for xml, expected := range allowed {
+		result, err := compiler.CompileFromString(xml)
+		if err != nil {
+			t.Errorf("Compilation of %s failed: %v", xml, err)
+		} else if result != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+		}
+	}
 
-	result, err := CompileString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+	rejected := map[string]string{
+		`<print>{{os.execute('rm')}}</print>`:                  "print: unsafe interpolation of os.execute('rm')",
+		`<warn>{{{ game:GetService("Players") }}}</warn>`:      `warn: unsafe interpolation of game:GetService("Players")`,
+		`<error>{{a .. b}}</error>`:                            "error: unsafe interpolation of a .. b",
+		`<assert test="ok">{{t[k]}}</assert>`:                  "assert: unsafe interpolation of t[k]",
+		`<istring>{{x + 1}}</istring>`:                         "istring: unsafe interpolation of x + 1",
+		`<nil-check var="x" else-error="{{f()}}"></nil-check>`: "error: unsafe interpolation of f()",
+		`<script><define name="m" params="v"><print>{{v}}!</print></define><use macro="m" v="f()"/></script>`: "unsafe interpolation of f()",
+	}
+	for xml, errorMsg := range rejected {
+		if _, err := compiler.CompileFromString(xml); err == nil || !strings.Contains(err.Error(), errorMsg) {
+			t.Errorf("Expected error containing %q for %s, got: %v", errorMsg, xml, err)
+		}
 	}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	// Without the option any expression can be interpolated
+	if _, err := CompileString(`<print>{{os.execute('rm')}}</print>`); err != nil {
+		t.Errorf("Expected unrestricted interpolation by default, got: %v", err)
 	}
 }
 
-func TestWhileLoop(t *testing.T) {
-	xml := `<while test="x < 10">
-  <set var="x">x + 1</set>
-</while>`
+func TestInterpolationDelimiters(t *testing.T) {
+	testCases := []struct {
+		name       string
+		delimiters Delimiters
+		xml        string
+		expected   string
+	}{
+		{"Dollar brace", Delimiters{"${", "}"}, `<print>Hi ${name}!</print>`, `print("Hi " .. tostring(name) .. "!")`},
+		{"Double braces are text", Delimiters{"${", "}"}, `<print>{{x}} is ${x}</print>`, `print("{{x}} is " .. tostring(x) .. "")`},
+		{"Raw form", Delimiters{"${", "}"}, `<print>Hi ${{name}}</print>`, `print("Hi " .. (name) .. "")`},
+		{"Percent tags", Delimiters{"<%", "%>"}, `<print>Score: &lt;% score %></print>`, `print("Score: " .. tostring(score) .. "")`},
+		{"No placeholder", Delimiters{"<%", "%>"}, `<print>"{{name}}"</print>`, `print("{{name}}")`},
+		{"Assert", Delimiters{"${", "}"}, `<assert test="n > 0">n was ${n}</assert>`, `assert(n > 0, "n was " .. tostring(n) .. "")`},
+		{"Istring", Delimiters{"${", "}"}, `<istring>{a} ${b}</istring>`, "`\\{a} {b}`"},
+		{"Macro", Delimiters{"${", "}"}, `<script><define name="log" params="msg"><warn>[LOG] ${msg}</warn></define><use macro="log" msg="text"/></script>`, `warn("[LOG] " .. tostring(text) .. "")`},
+		{"Default", Delimiters{}, `<print>Hi {{name}}</print>`, `print("Hi " .. tostring(name) .. "")`},
+	}
 
-	expected := `while x < 10 do
-    x = x + 1
-end`
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{InterpolationDelimiters: tc.delimiters})
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
 
-	result, err := CompileString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
 	}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	for _, d := range []Delimiters{{"${", ""}, {"", "}}"}, {"%%", "%%"}} {
+		compiler := NewCompilerWithOptions(CompileOptions{InterpolationDelimiters: d})
+		if _, err := compiler.CompileFromString(`<print>x</print>`); err == nil || !strings.Contains(err.Error(), "interpolation delimiters") {
+			t.Errorf("Expected delimiter error for %+v, got: %v", d, err)
+		}
 	}
 }
 
-func TestFunction(t *testing.T) {
-	xml := `<function name="greet" params="name" local="true">
-  <print>Hello, {{name}}!</print>
-  <return>"greeting sent"</return>
-</function>`
+func TestBacktickStrings(t *testing.T) {
+	compiler := NewCompilerWithOptions(CompileOptions{BacktickStrings: true})
 
-	expected := `local function greet(name)
-    print("Hello, " .. tostring(name) .. "!")
-    return "greeting sent"
-end`
+	xml := `<script>
+  <print>Hello {{player.Name}}!</print>
+  <warn>
+    Score: {{score}}
+    Best: {{best}}
+  </warn>
+  <error level="2">{literal} ` + "`tick`" + ` {{reason}}</error>
+  <print>"unchanged"</print>
+</script>`
 
-	result, err := CompileString(xml)
+	expected := "print(`Hello {player.Name}!`)\n" +
+		"warn(`Score: {score}\\nBest: {best}`)\n" +
+		"error(`\\{literal} \\`tick\\` {reason}`, 2)\n" +
+		`print("unchanged")`
+
+	result, err := compiler.CompileFromString(xml)
 	if err != nil {
 		t.Fatalf("Compilation failed: %v", err)
 	}
@@ -155,38 +516,2977 @@ end`
 	}
 }
 
-func TestFunctionCall(t *testing.T) {
-	xml := `<call name="greet">
-  <arg>"Alice"</arg>
-  <arg>"Bob"</arg>
-</call>`
+func TestIString(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Assignment", `<istring var="msg" local="true">Hi {{name}}, you have {{#items}} items</istring>`, "local msg = `Hi {name}, you have {#items} items`"},
+		{"Expression", `<istring>{{a}}{{b}}</istring>`, "`{a}{b}`"},
+		{"No placeholders", `<istring>plain "text"</istring>`, "`plain \"text\"`"},
+	}
 
-	expected := `greet("Alice", "Bob")`
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
 
-	result, err := CompileString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
 	}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	if _, err := CompileString(`<istring var="s"></istring>`); err == nil {
+		t.Error("Expected error for empty istring")
 	}
 }
 
-func TestTable(t *testing.T) {
-	xml := `<table var="config" local="true">
-  <entry key="name">"MyApp"</entry>
-  <entry key="version">1.0</entry>
-  <entry key="debug">true</entry>
-</table>`
-
-	expected := `local config = {
-    name = "MyApp",
+func TestStringInterpolate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+		warnings int
+	}{
+		{
+			name: "Multiple bindings",
+			xml: `<string-interpolate var="msg" local="true" template="Hello {name}, you are level {level}!">
+  <bind name="name" expr="player.Name"/>
+  <bind name="level" expr="stats.level + 1"/>
+</string-interpolate>`,
+			expected: `local msg = "Hello " .. tostring(player.Name) .. ", you are level " .. tostring(stats.level + 1) .. "!"`,
+		},
+		{
+			name:     "Adjacent bindings",
+			xml:      `<string-interpolate template="{a}{b}"><bind name="a" expr="x"/><bind name="b" expr="y"/></string-interpolate>`,
+			expected: `tostring(x) .. tostring(y)`,
+		},
+		{
+			name:     "Bindings at both ends",
+			xml:      `<string-interpolate template="{first} and {last}"><bind name="first" expr="a"/><bind name="last" expr="b"/></string-interpolate>`,
+			expected: `tostring(a) .. " and " .. tostring(b)`,
+		},
+		{
+			name:     "Repeated placeholder",
+			xml:      `<string-interpolate template="{n} x {n}"><bind name="n" expr="size"/></string-interpolate>`,
+			expected: `tostring(size) .. " x " .. tostring(size)`,
+		},
+		{
+			name:     "Literal braces and quotes",
+			xml:      `<string-interpolate template='say "{w}" {not a placeholder}'><bind name="w" expr="word"/></string-interpolate>`,
+			expected: `"say \"" .. tostring(word) .. "\" {not a placeholder}"`,
+		},
+		{
+			name:     "Empty template",
+			xml:      `<string-interpolate template=""/>`,
+			expected: `""`,
+		},
+		{
+			name:     "Unused binding",
+			xml:      `<string-interpolate template="static"><bind name="x" expr="1"/></string-interpolate>`,
+			expected: `"static"`,
+			warnings: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompiler()
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != tc.warnings {
+				t.Errorf("Expected %d warnings, got: %v", tc.warnings, warnings)
+			}
+		})
+	}
+
+	for _, xml := range []string{
+		`<string-interpolate><bind name="a" expr="x"/></string-interpolate>`,
+		`<string-interpolate template="{missing}"/>`,
+		`<string-interpolate template="{a}"><bind name="a"/></string-interpolate>`,
+		`<string-interpolate template="{a}"><bind name="a" expr="1"/><bind name="a" expr="2"/></string-interpolate>`,
+	} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
+	}
+}
+
+func TestAugmentedAssign(t *testing.T) {
+	testCases := []struct {
+		op       string
+		value    string
+		expected string
+	}{
+		{"+", "1", "x = x + 1"},
+		{"-", "2", "x = x - 2"},
+		{"*", "3", "x = x * 3"},
+		{"/", "4", "x = x / 4"},
+		{"..", `"!"`, `x = x .. "!"`},
+		{"%", "5", "x = x % 5"},
+		{"^", "2", "x = x ^ 2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.op, func(t *testing.T) {
+			xml := fmt.Sprintf(`<augmented-assign var="x" op="%s" value='%s'/>`, tc.op, tc.value)
+
+			result, err := CompileString(xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestIncrementDecrement(t *testing.T) {
+	xml := `<script>
+  <increment var="count"/>
+  <decrement var="lives"/>
+  <increment var="score" value="10"/>
+</script>`
+
+	expected := `count = count + 1
+lives = lives - 1
+score = score + 10`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestAugmentedAssignUnknownOperator(t *testing.T) {
+	_, err := CompileString(`<augmented-assign var="x" op="//" value="2"/>`)
+	if err == nil {
+		t.Fatal("Expected error but got none")
+	}
+	if !strings.Contains(err.Error(), "unknown operator") {
+		t.Errorf("Expected unknown operator error, got: %v", err)
+	}
+}
+
+func TestDestructure(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Simple fields",
+			xml: `<destructure from="point" local="true">
+  <bind field="x" var="x"/>
+  <bind field="y" var="y"/>
+</destructure>`,
+			expected: "local x = point.x\nlocal y = point.y",
+		},
+		{
+			name: "Nested paths",
+			xml: `<destructure from="config">
+  <bind field="window.size.width" var="width"/>
+  <bind field="items.1" var="first"/>
+  <bind field="user-agent" var="agent"/>
+</destructure>`,
+			expected: "width = config.window.size.width\nfirst = config.items[1]\nagent = config[\"user-agent\"]",
+		},
+		{
+			name: "Binding-level local",
+			xml: `<destructure from="t">
+  <bind field="a" var="a" local="true"/>
+  <bind field="b" var="b" local="true"/>
+</destructure>`,
+			expected: "local a = t.a\nlocal b = t.b",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestDestructureMixedLocalError(t *testing.T) {
+	xml := `<destructure from="t" local="true">
+  <bind field="a" var="a"/>
+  <bind field="b" var="b" local="false"/>
+</destructure>`
+
+	_, err := CompileString(xml)
+	if err == nil || !strings.Contains(err.Error(), "cannot mix local and non-local") {
+		t.Errorf("Expected mixed local error, got: %v", err)
+	}
+}
+
+func TestIfStatement(t *testing.T) {
+	xml := `<if test="x > 0">
+  <print>"Positive"</print>
+</if>`
+
+	expected := `if x > 0 then
+    print("Positive")
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestForLoop(t *testing.T) {
+	xml := `<for var="i" from="1" to="10">
+  <print>{{i}}</print>
+</for>`
+
+	expected := `for i = 1, 10 do
+    print("" .. tostring(i) .. "")
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestForLoopWithStep(t *testing.T) {
+	xml := `<for var="i" from="0" to="10" step="2">
+  <print>{{i}}</print>
+</for>`
+
+	expected := `for i = 0, 10, 2 do
+    print("" .. tostring(i) .. "")
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestForLoopBounds(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+		warnings int
+	}{
+		{
+			name:     "Forward loop",
+			xml:      `<for var="i" from="1" to="10"><print>{{i}}</print></for>`,
+			expected: "for i = 1, 10 do\n    print(\"\" .. tostring(i) .. \"\")\nend",
+		},
+		{
+			name:     "Reverse loop",
+			xml:      `<for var="i" from="10" to="1" step="-1"><print>{{i}}</print></for>`,
+			expected: "for i = 10, 1, -1 do\n    print(\"\" .. tostring(i) .. \"\")\nend",
+		},
+		{
+			name:     "Negative step counting up",
+			xml:      `<for var="i" from="1" to="10" step="-1"></for>`,
+			expected: "for i = 1, 10, -1 do\nend",
+			warnings: 1,
+		},
+		{
+			name:     "Positive step counting down",
+			xml:      `<for var="i" from="10" to="1" step="2"></for>`,
+			expected: "for i = 10, 1, 2 do\nend",
+			warnings: 1,
+		},
+		{
+			name:     "Default step counting down",
+			xml:      `<for var="i" from="10" to="1"></for>`,
+			expected: "for i = 10, 1 do\nend",
+			warnings: 1,
+		},
+		{
+			name:     "Non-literal step",
+			xml:      `<for var="i" from="1" to="10" step="delta"></for>`,
+			expected: "for i = 1, 10, delta do\nend",
+		},
+		{
+			name:     "Non-literal bounds",
+			xml:      `<for var="i" from="#items" to="1" step="1.5"></for>`,
+			expected: "for i = #items, 1, 1.5 do\nend",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompiler()
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != tc.warnings {
+				t.Errorf("Expected %d warnings, got: %v", tc.warnings, warnings)
+			}
+		})
+	}
+
+	if _, err := CompileString(`<for var="i" from="1" to="10" step="0"></for>`); err == nil {
+		t.Error("Expected error for zero step")
+	}
+}
+
+func TestTargetLua51(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		options  CompileOptions
+		expected string
+	}{
+		{
+			name:     "Typeof becomes type",
+			xml:      `<typeof var="kind" local="true">value</typeof>`,
+			expected: "local kind = type(value)",
+		},
+		{
+			name:     "Typeof expression",
+			xml:      `<if test="x"><call name="print"><arg><typeof>x</typeof></arg></call></if>`,
+			expected: "if x then\n    print(type(x))\nend",
+		},
+		{
+			name:     "Istring falls back to concatenation",
+			xml:      `<istring var="msg" local="true">Hello {{name}}</istring>`,
+			expected: `local msg = "Hello " .. tostring(name) .. ""`,
+		},
+		{
+			name:     "Backtick option is ignored",
+			xml:      `<print>Hi {{name}}</print>`,
+			options:  CompileOptions{BacktickStrings: true},
+			expected: `print("Hi " .. tostring(name) .. "")`,
+		},
+		{
+			name:     "Table unpack",
+			xml:      `<call name="print"><arg><call name="table.unpack">list</call></arg></call>`,
+			expected: "print(unpack(list))",
+		},
+		{
+			name:     "Task spawn becomes a coroutine",
+			xml:      `<call name="task.spawn">worker<arg>1</arg><arg>2</arg></call>`,
+			expected: "coroutine.wrap(worker)(1, 2)",
+		},
+		{
+			name:     "Untyped variadic function",
+			xml:      `<function name="log" params="level" variadic="true"><print>...</print></function>`,
+			expected: "function log(level, ...)\n    print(...)\nend",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.options.Target = TargetLua51
+			result, err := NewCompilerWithOptions(tc.options).CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestTargetLua51Errors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Continue", `<while test="true"><continue/></while>`, "continue is not available in Lua 5.1"},
+		{"Typed parameters", `<function name="f" params="x: number"></function>`, "type annotations are not available in Lua 5.1"},
+		{"Task wait", `<call name="task.wait">1</call>`, "task.wait is not available in Lua 5.1"},
+		{"Typed vararg", `<function name="f" variadic="true" variadic-type="string"></function>`, "type annotations are not available in Lua 5.1"},
+		{"Bitwise and", `<bit-and var="x" a="a" b="b"/>`, "bit32 is not available in Lua 5.1"},
+		{"Bitwise shift", `<bit-lshift var="x" a="a" b="2"/>`, "bit32 is not available in Lua 5.1"},
+		{"Bitwise not", `<bit-not var="x" a="a"/>`, "bit32 is not available in Lua 5.1"},
+		{"Bitwise extract", `<bit-extract var="x" a="a" field="3"/>`, "bit32 is not available in Lua 5.1"},
+		{"Bitwise replace", `<bit-replace var="x" a="a" b="1" field="3"/>`, "bit32 is not available in Lua 5.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{Target: TargetLua51})
+			_, err := compiler.CompileFromString(tc.xml)
+
+			var compileErr *CompileError
+			if !errors.As(err, &compileErr) || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected CompileError containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+
+	// The same constructs are fine for Luau
+	for _, tc := range testCases {
+		if _, err := CompileString(tc.xml); err != nil {
+			t.Errorf("%s: expected Luau to accept %s, got: %v", tc.name, tc.xml, err)
+		}
+	}
+}
+
+func TestUnknownTarget(t *testing.T) {
+	compiler := NewCompilerWithOptions(CompileOptions{Target: "lua54"})
+	if _, err := compiler.CompileFromString(`<set var="x">1</set>`); err == nil || !strings.Contains(err.Error(), "unknown target: lua54") {
+		t.Errorf("Expected unknown target error, got: %v", err)
+	}
+	if err := compiler.CompileStream(strings.NewReader(`<set var="x">1</set>`), io.Discard); err == nil {
+		t.Error("Expected unknown target error when streaming")
+	}
+
+	for _, name := range []string{"luau", "lua51"} {
+		if target, err := ParseTarget(name); err != nil || string(target) != name {
+			t.Errorf("ParseTarget(%q) = %q, %v", name, target, err)
+		}
+	}
+}
+
+func TestWrapScript(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		wrap     ScriptWrap
+		minify   bool
+		expected string
+	}{
+		{
+			name:     "Do block",
+			xml:      `<script><set var="x" local="true">1</set><if test="x"><print>x</print></if></script>`,
+			wrap:     WrapDo,
+			expected: "do\n    local x = 1\n    if x then\n        print(x)\n    end\nend",
+		},
+		{
+			name:     "Function scope",
+			xml:      `<script><set var="M" local="true">{}</set><return>M</return></script>`,
+			wrap:     WrapFunction,
+			expected: "return (function(...)\n    local M = {}\n    return M\nend)(...)",
+		},
+		{
+			name:     "Single command",
+			xml:      `<print>"hi"</print>`,
+			wrap:     WrapDo,
+			expected: "do\n    print(\"hi\")\nend",
+		},
+		{
+			name:     "Empty script",
+			xml:      `<script></script>`,
+			wrap:     WrapDo,
+			expected: "do\nend",
+		},
+		{
+			name:     "Pragma stays first",
+			xml:      `<script><pragma mode="strict"/><set var="x">1</set></script>`,
+			wrap:     WrapDo,
+			expected: "--!strict\ndo\n    x = 1\nend",
+		},
+		{
+			name:     "Minified",
+			xml:      `<script><set var="x">1</set></script>`,
+			wrap:     WrapFunction,
+			minify:   true,
+			expected: "return (function(...)\nx = 1\nend)(...)",
+		},
+		{
+			name:     "Unwrapped",
+			xml:      `<set var="x">1</set>`,
+			expected: "x = 1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{WrapScript: tc.wrap, Minify: tc.minify})
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+
+			var out strings.Builder
+			if err := compiler.CompileStream(strings.NewReader(tc.xml), &out); err != nil {
+				t.Fatalf("Stream compilation failed: %v", err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("Expected streamed:\n%s\nGot:\n%s", tc.expected, out.String())
+			}
+		})
+	}
+
+	compiler := NewCompilerWithOptions(CompileOptions{WrapScript: "module"})
+	if _, err := compiler.CompileFromString(`<set var="x">1</set>`); err == nil || !strings.Contains(err.Error(), "unknown script wrap: module") {
+		t.Errorf("Expected unknown script wrap error, got: %v", err)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		options  CompileOptions
+		expected string
+		warnings int
+	}{
+		{
+			name:     "Strict script",
+			xml:      `<script><set var="x" local="true">1</set></script>`,
+			options:  CompileOptions{StrictMode: StrictModeStrict},
+			expected: "--!strict\nlocal x = 1",
+		},
+		{
+			name:     "Nonstrict module root",
+			xml:      `<module><set var="M" local="true">{}</set><export var="M"/></module>`,
+			options:  CompileOptions{StrictMode: StrictModeNonstrict},
+			expected: "--!nonstrict\nlocal M = {}\nreturn M",
+		},
+		{
+			name:     "Single command has no directive",
+			xml:      `<set var="x">1</set>`,
+			options:  CompileOptions{StrictMode: StrictModeStrict},
+			expected: "x = 1",
+		},
+		{
+			name:     "None",
+			xml:      `<script><set var="x">1</set></script>`,
+			options:  CompileOptions{StrictMode: StrictModeNone},
+			expected: "x = 1",
+		},
+		{
+			name:     "Empty script",
+			xml:      `<script></script>`,
+			options:  CompileOptions{StrictMode: StrictModeStrict},
+			expected: "--!strict",
+		},
+		{
+			name:     "Precedes wrapper",
+			xml:      `<script><set var="x">1</set></script>`,
+			options:  CompileOptions{StrictMode: StrictModeStrict, WrapScript: WrapDo},
+			expected: "--!strict\ndo\n    x = 1\nend",
+		},
+		{
+			name:     "Overrides pragma",
+			xml:      `<script><pragma mode="nocheck"/><set var="x">1</set></script>`,
+			options:  CompileOptions{StrictMode: StrictModeStrict},
+			expected: "--!strict\nx = 1",
+			warnings: 1,
+		},
+		{
+			name:     "Matching pragma",
+			xml:      `<script><pragma mode="strict"/><set var="x">1</set></script>`,
+			options:  CompileOptions{StrictMode: StrictModeStrict},
+			expected: "--!strict\nx = 1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(tc.options)
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != tc.warnings {
+				t.Errorf("Expected %d warnings, got: %v", tc.warnings, warnings)
+			}
+
+			var out strings.Builder
+			if err := compiler.CompileStream(strings.NewReader(tc.xml), &out); err != nil {
+				t.Fatalf("Stream compilation failed: %v", err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("Expected streamed:\n%s\nGot:\n%s", tc.expected, out.String())
+			}
+		})
+	}
+
+	// A conflicting pragma names the option that overrides it
+	compiler := NewCompilerWithOptions(CompileOptions{StrictMode: StrictModeStrict})
+	if _, err := compiler.CompileFromString(`<script><pragma mode="nocheck"/></script>`); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	warnings := compiler.Warnings()
+	if len(warnings) != 1 || warnings[0].Message != "pragma nocheck overridden by CompileOptions.StrictMode strict" {
+		t.Errorf("Expected a warning about the overriding option, got: %v", warnings)
+	}
+
+	compiler = NewCompilerWithOptions(CompileOptions{StrictMode: "nocheck"})
+	if _, err := compiler.CompileFromString(`<script></script>`); err == nil || !strings.Contains(err.Error(), "unknown strict mode: nocheck") {
+		t.Errorf("Expected unknown strict mode error, got: %v", err)
+	}
+}
+
+func TestAutoReverseFor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+		warnings int
+	}{
+		{
+			name:     "Counting down",
+			xml:      `<for var="i" from="10" to="1"><print>{{i}}</print></for>`,
+			expected: "for i = 10, 1, -1 do\n    print(\"\" .. tostring(i) .. \"\")\nend",
+		},
+		{
+			name:     "Counting up",
+			xml:      `<for var="i" from="1" to="10"></for>`,
+			expected: "for i = 1, 10 do\nend",
+		},
+		{
+			name:     "Explicit step is kept",
+			xml:      `<for var="i" from="10" to="1" step="1"></for>`,
+			expected: "for i = 10, 1 do\nend",
+			warnings: 1,
+		},
+		{
+			name:     "Non-literal bounds",
+			xml:      `<for var="i" from="n" to="1"></for>`,
+			expected: "for i = n, 1 do\nend",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{AutoReverseFor: true})
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != tc.warnings {
+				t.Errorf("Expected %d warnings, got: %v", tc.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestDescendingForSuggestsStep(t *testing.T) {
+	compiler := NewCompiler()
+	if _, err := compiler.CompileFromString(`<for var="i" from="5" to="0"></for>`); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	warnings := compiler.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, `step="-1"`) {
+		t.Errorf("Expected a warning suggesting step=\"-1\", got: %v", warnings)
+	}
+}
+
+func TestGenericForLoop(t *testing.T) {
+	xml := `<for var="k, v" in="pairs(table)">
+  <print>{{k}}: {{v}}</print>
+</for>`
+
+	expected := `for k, v in pairs(table) do
+    print("" .. tostring(k) .. ": " .. tostring(v) .. "")
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestGenericForLoopVarList(t *testing.T) {
+	result, err := CompileString(`<for var="a,b ,  c" in="next3()"></for>`)
+	if expected := "for a, b, c in next3() do\nend"; err != nil || result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s (error: %v)", expected, result, err)
+	}
+
+	errorCases := []struct {
+		xml      string
+		errorMsg string
+	}{
+		{`<for var="k, 2v" in="pairs(t)"></for>`, "invalid variable name: 2v"},
+		{`<for var="k, v.x" in="pairs(t)"></for>`, "invalid variable name: v.x"},
+		{`<for var="i, j" from="1" to="3"></for>`, "numeric for loop takes a single variable, got: i, j"},
+	}
+	for _, tc := range errorCases {
+		if _, err := CompileString(tc.xml); err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+			t.Errorf("Expected error containing %q for %s, got: %v", tc.errorMsg, tc.xml, err)
+		}
+	}
+}
+
+func TestGenericForKeyValue(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name:     "Key and value",
+			xml:      `<for key="k" value="v" in="pairs(t)"><print>k</print></for>`,
+			expected: "for k, v in pairs(t) do\n    print(k)\nend",
+		},
+		{
+			name:     "Value only",
+			xml:      `<for value="item" in="ipairs(items)"><print>item</print></for>`,
+			expected: "for _, item in ipairs(items) do\n    print(item)\nend",
+		},
+		{
+			name:     "Key only",
+			xml:      `<for key="k" in="pairs(t)"><print>k</print></for>`,
+			expected: "for k in pairs(t) do\n    print(k)\nend",
+		},
+		{
+			name:     "Single var",
+			xml:      `<for var="line" in="lines()"><print>line</print></for>`,
+			expected: "for line in lines() do\n    print(line)\nend",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompiler()
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != 0 {
+				t.Errorf("Expected no warnings, got: %v", warnings)
+			}
+		})
+	}
+
+	// The comma-separated var form still compiles but is deprecated
+	compiler := NewCompiler()
+	result, err := compiler.CompileFromString(`<for var="k, v" in="pairs(t)"></for>`)
+	if err != nil || result != "for k, v in pairs(t) do\nend" {
+		t.Errorf("Expected deprecated var form to compile, got %q (error: %v)", result, err)
+	}
+	if warnings := compiler.Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0].Message, "deprecated") {
+		t.Errorf("Expected a deprecation warning, got: %v", warnings)
+	}
+
+	errorCases := []struct {
+		xml      string
+		errorMsg string
+	}{
+		{`<for var="k" key="k" in="pairs(t)"></for>`, "for command cannot have both 'var' and 'key'/'value' attributes"},
+		{`<for in="pairs(t)"></for>`, "for command requires 'var' or 'key'/'value' attributes"},
+		{`<for key="i" from="1" to="3"></for>`, "numeric for loop takes 'var', not 'key'/'value'"},
+		{`<for key="k" value="1v" in="pairs(t)"></for>`, "invalid variable name: 1v"},
+		{`<for key="k, v" in="pairs(t)"></for>`, "invalid variable name: k, v"},
+	}
+	for _, tc := range errorCases {
+		if _, err := CompileString(tc.xml); err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+			t.Errorf("Expected error containing %q for %s, got: %v", tc.errorMsg, tc.xml, err)
+		}
+	}
+}
+
+func TestWhileLoop(t *testing.T) {
+	xml := `<while test="x < 10">
+  <set var="x">x + 1</set>
+</while>`
+
+	expected := `while x < 10 do
+    x = x + 1
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestBlockTextContent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+		warnings int
+	}{
+		{
+			name:     "Formatting whitespace",
+			xml:      "<while test=\"x\">\n\t  \n  <break/>\n\n</while>",
+			expected: "while x do\n    break\nend",
+		},
+		{
+			name:     "Non-breaking space",
+			xml:      "<repeat until=\"done\">&#160;<break/>&nbsp;</repeat>",
+			expected: "repeat\n    break\nuntil done",
+		},
+		{
+			name:     "Whitespace only body",
+			xml:      "<if test=\"x\">\n   \n</if>",
+			expected: "if x then\nend",
+		},
+		{
+			name:     "Mixed content in loop",
+			xml:      `<while test="x">x = x + 1<break/></while>`,
+			expected: "while x do\n    break\nend",
+			warnings: 1,
+		},
+		{
+			name:     "Mixed content in if",
+			xml:      `<for var="i" from="1" to="3"><if test="i > 1">stray<break/></if></for>`,
+			expected: "for i = 1, 3 do\n    if i > 1 then\n        break\n    end\nend",
+			warnings: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompiler()
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != tc.warnings {
+				t.Errorf("Expected %d warnings, got: %v", tc.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestContinueAndBreakInLoops(t *testing.T) {
+	xml := `<while test="true">
+  <repeat until="done">
+    <continue/>
+  </repeat>
+  <break/>
+</while>`
+
+	expected := `while true do
+    repeat
+        continue
+    until done
+    break
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestIfChain(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Else",
+			xml: `<if test="ok">
+  <print>"yes"</print>
+  <else>
+    <print>"no"</print>
+  </else>
+</if>`,
+			expected: "if ok then\n    print(\"yes\")\nelse\n    print(\"no\")\nend",
+		},
+		{
+			name: "Elseif chain",
+			xml: `<if test="n &lt; 0">
+  <set var="sign">-1</set>
+  <elseif test="n == 0"><set var="sign">0</set></elseif>
+  <elseif test="n &gt; 0"><set var="sign">1</set></elseif>
+  <else><error>"nan"</error></else>
+</if>`,
+			expected: "if n < 0 then\n    sign = -1\nelseif n == 0 then\n    sign = 0\nelseif n > 0 then\n    sign = 1\nelse\n    error(\"nan\", 1)\nend",
+		},
+		{
+			name: "Then section with two elseifs and an else",
+			xml: `<if test="a">
+  <then><print>"a"</print></then>
+  <elseif test="b"><print>"b"</print></elseif>
+  <elseif test="c"><print>"c"</print></elseif>
+  <else><print>"none"</print></else>
+</if>`,
+			expected: "if a then\n    print(\"a\")\nelseif b then\n    print(\"b\")\nelseif c then\n    print(\"c\")\nelse\n    print(\"none\")\nend",
+		},
+		{
+			name:     "Nested",
+			xml:      `<for var="i" from="1" to="3"><if test="i == 1"><break/><else><continue/></else></if></for>`,
+			expected: "for i = 1, 3 do\n    if i == 1 then\n        break\n    else\n        continue\n    end\nend",
+		},
+		{
+			name:     "Empty branches",
+			xml:      `<if test="x"><elseif test="y"/><else/></if>`,
+			expected: "if x then\nelseif y then\nelse\nend",
+		},
+		{
+			name:     "Branch scopes",
+			xml:      `<if test="x"><set var="v" local="true">1</set><else><set var="v" local="true">2</set></else></if>`,
+			expected: "if x then\n    local v = 1\nelse\n    local v = 2\nend",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompiler()
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != 0 {
+				t.Errorf("Expected no warnings, got: %v", warnings)
+			}
+		})
+	}
+}
+
+func TestIfChainErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Standalone elseif", `<elseif test="x"><print>1</print></elseif>`, "elseif must be inside an if block"},
+		{"Standalone else", `<script><else/></script>`, "else must be inside an if block"},
+		{"Else in loop", `<while test="x"><else/></while>`, "else must be inside an if block"},
+		{"Elseif after else", `<if test="x"><else/><elseif test="y"/></if>`, "elseif cannot follow else"},
+		{"Second else", `<if test="x"><else/><else/></if>`, "else cannot follow else"},
+		{"Statement after branch", `<if test="x"><else/><print>1</print></if>`, "must come before its elseif and else branches"},
+		{"Standalone then", `<then><print>1</print></then>`, "then must be inside an if block"},
+		{"Then after else", `<if test="x"><else/><then/></if>`, "then must come before the elseif and else branches"},
+		{"Elseif without test", `<if test="x"><elseif/></if>`, "elseif command requires 'test' attribute"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := CompileString(tc.xml)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestWhenType(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Primitive", `<when-type var="x" is="number"><print>x + 1</print></when-type>`, "if typeof(x) == \"number\" then\n    print(x + 1)\nend"},
+		{"Roblox type", `<when-type var="part" is="Instance"><print>part.Name</print></when-type>`, "if typeof(part) == \"Instance\" then\n    print(part.Name)\nend"},
+		{"Field", `<when-type var="args[1]" is="Vector3"><set var="pos">args[1]</set></when-type>`, "if typeof(args[1]) == \"Vector3\" then\n    pos = args[1]\nend"},
+		{"Else branch", `<when-type var="x" is="string"><print>x</print><else><print>tostring(x)</print></else></when-type>`, "if typeof(x) == \"string\" then\n    print(x)\nelse\n    print(tostring(x))\nend"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	compiler := NewCompilerWithOptions(CompileOptions{Target: TargetLua51})
+	result, err := compiler.CompileFromString(`<when-type var="x" is="table"><print>x</print></when-type>`)
+	if expected := "if type(x) == \"table\" then\n    print(x)\nend"; err != nil || result != expected {
+		t.Errorf("Expected %q for Lua 5.1, got %q (error: %v)", expected, result, err)
+	}
+}
+
+func TestWhenTypeErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		target   Target
+		errorMsg string
+	}{
+		{"Missing var", `<when-type is="number"/>`, TargetLuau, "when-type command requires 'var' attribute"},
+		{"Missing is", `<when-type var="x"/>`, TargetLuau, "when-type command requires 'is' attribute"},
+		{"Unknown type", `<when-type var="x" is="integer"/>`, TargetLuau, "unknown type: integer"},
+		{"Wrong case", `<when-type var="x" is="Number"/>`, TargetLuau, "unknown type: Number"},
+		{"Roblox type in Lua 5.1", `<when-type var="x" is="Vector3"/>`, TargetLua51, "Roblox type Vector3 is not available in Lua 5.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{Target: tc.target})
+			_, err := compiler.CompileFromString(tc.xml)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestNilCheck(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Simple", `<nil-check var="x"><print>x</print></nil-check>`, "if x ~= nil then\n    print(x)\nend"},
+		{"Field", `<nil-check var="player.Character"><print>"spawned"</print></nil-check>`, "if player.Character ~= nil then\n    print(\"spawned\")\nend"},
+		{"Else error", `<nil-check var="x" else-error="expected x to be non-nil"><print>x</print></nil-check>`, "if x ~= nil then\n    print(x)\nelse\n    error(\"expected x to be non-nil\", 1)\nend"},
+		{"Else error with quotes", `<nil-check var="x" else-error='missing "x"'/>`, "if x ~= nil then\nelse\n    error(\"missing \\\"x\\\"\", 1)\nend"},
+		{"Interpolated else error", `<nil-check var="cfg[key]" else-error="no config for {{key}}"><print>cfg[key]</print></nil-check>`, "if cfg[key] ~= nil then\n    print(cfg[key])\nelse\n    error(\"no config for \" .. tostring(key) .. \"\", 1)\nend"},
+		{"Else branch", `<nil-check var="x"><print>x</print><else><print>"none"</print></else></nil-check>`, "if x ~= nil then\n    print(x)\nelse\n    print(\"none\")\nend"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestNilCheckErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Missing var", `<nil-check><print>x</print></nil-check>`, "nil-check command requires 'var' attribute"},
+		{"Else error and else branch", `<nil-check var="x" else-error="nil"><else><print>1</print></else></nil-check>`, "nil-check command cannot have both 'else-error' attribute and else branches"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := CompileString(tc.xml)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestGuard(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Return value", `<guard test="x == nil" return="nil"/>`, "if x == nil then return nil end"},
+		{"Bare return", `<guard test="not ready" return=""/>`, "if not ready then return end"},
+		{"Multiple values", `<guard test="not ok" return="false, err"/>`, "if not ok then return false, err end"},
+		{"Error action", `<guard test="type(n) ~= 'number'"><error>"n must be a number"</error></guard>`, `if type(n) ~= 'number' then error("n must be a number", 1) end`},
+		{
+			name: "Multi-line action",
+			xml: `<guard test="not player">
+  <warn>"no player"</warn>
+  <return>nil</return>
+</guard>`,
+			expected: "if not player then\n    warn(\"no player\")\n    return nil\nend",
+		},
+		{
+			name: "Indented",
+			xml: `<function name="f" params="x">
+  <guard test="x &lt; 0" return="0"/>
+  <return>x</return>
+</function>`,
+			expected: "function f(x)\n    if x < 0 then return 0 end\n    return x\nend",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestOnce(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Distinct flags",
+			xml:  `<script><once><print>"a"</print></once><once><print>"b"</print></once></script>`,
+			expected: `local _lunariaOnce_1 = false
+if not _lunariaOnce_1 then
+    _lunariaOnce_1 = true
+    print("a")
+end
+local _lunariaOnce_2 = false
+if not _lunariaOnce_2 then
+    _lunariaOnce_2 = true
+    print("b")
+end`,
+		},
+		{
+			name: "Custom flag",
+			xml:  `<script><once flag="_initialized"><call name="setup"/></once><once/></script>`,
+			expected: `local _initialized = false
+if not _initialized then
+    _initialized = true
+    setup()
+end
+local _lunariaOnce_1 = false
+if not _lunariaOnce_1 then
+    _lunariaOnce_1 = true
+end`,
+		},
+		{
+			// The flag lives outside the function, so later calls skip the body
+			name: "Inside function",
+			xml:  `<function name="init" local="true"><once><print>"ready"</print></once></function>`,
+			expected: `local _lunariaOnce_1 = false
+local function init()
+    if not _lunariaOnce_1 then
+        _lunariaOnce_1 = true
+        print("ready")
+    end
+end`,
+		},
+		{
+			name: "Inside loop in function",
+			xml:  `<script><set var="n" local="true">0</set><function name="run" local="true"><for var="i" from="1" to="3"><once flag="warned"><warn>"slow"</warn></once></for></function></script>`,
+			expected: `local n = 0
+local warned = false
+local function run()
+    for i = 1, 3 do
+        if not warned then
+            warned = true
+            warn("slow")
+        end
+    end
+end`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	// Counting restarts with each compilation
+	compiler := NewCompilerWithOptions(CompileOptions{Semicolons: true})
+	for i := 0; i < 2; i++ {
+		result, err := compiler.CompileFromString(`<once><set var="x">1</set></once>`)
+		expected := "local _lunariaOnce_1 = false;\nif not _lunariaOnce_1 then\n    _lunariaOnce_1 = true;\n    x = 1;\nend"
+		if err != nil || result != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s (error: %v)", expected, result, err)
+		}
+	}
+
+	// In a wrapped script the flag is declared inside the wrapper, whether
+	// compiled at once or streamed
+	compiler = NewCompilerWithOptions(CompileOptions{WrapScript: WrapDo})
+	xml := `<script><function name="f"><once/></function></script>`
+	expected := "do\n    local _lunariaOnce_1 = false\n    function f()\n        if not _lunariaOnce_1 then\n            _lunariaOnce_1 = true\n        end\n    end\nend"
+	if result, err := compiler.CompileFromString(xml); err != nil || result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s (error: %v)", expected, result, err)
+	}
+	var out strings.Builder
+	if err := compiler.CompileStream(strings.NewReader(xml), &out); err != nil || out.String() != expected {
+		t.Errorf("Expected streamed:\n%s\nGot:\n%s (error: %v)", expected, out.String(), err)
+	}
+
+	if _, err := CompileString(`<once flag="1st"/>`); err == nil || !strings.Contains(err.Error(), "invalid variable name: 1st") {
+		t.Errorf("Expected invalid flag error, got: %v", err)
+	}
+}
+
+func TestGuardErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Missing test", `<guard return="nil"/>`},
+		{"No action", `<guard test="x"/>`},
+		{"Both actions", `<guard test="x" return="nil"><error>bad</error></guard>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+}
+
+func TestContinueAndBreakOutsideLoop(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+		tag  string
+	}{
+		{"Top-level continue", `<continue/>`, "continue"},
+		{"Top-level break", `<break/>`, "break"},
+		{"Continue in if", `<if test="x"><continue/></if>`, "continue"},
+		{
+			name: "Break in function inside loop",
+			xml: `<for var="i" from="1" to="3">
+  <function name="f" local="true"><break/></function>
+</for>`,
+			tag: "break",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := CompileString(tc.xml)
+			var compileErr *CompileError
+			if !errors.As(err, &compileErr) {
+				t.Fatalf("Expected CompileError, got: %v", err)
+			}
+			if compileErr.Tag != tc.tag {
+				t.Errorf("Expected error for tag %s, got %s", tc.tag, compileErr.Tag)
+			}
+		})
+	}
+}
+
+func TestFunction(t *testing.T) {
+	xml := `<function name="greet" params="name" local="true">
+  <print>Hello, {{name}}!</print>
+  <return>"greeting sent"</return>
+</function>`
+
+	expected := `local function greet(name)
+    print("Hello, " .. tostring(name) .. "!")
+    return "greeting sent"
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestLambdaFunction(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+		warnings int
+	}{
+		{
+			name:     "Named function",
+			xml:      `<function name="inc" params="x" local="true"><return>x + 1</return></function>`,
+			expected: "local function inc(x)\n    return x + 1\nend",
+		},
+		{
+			name:     "Lambda assigned to var",
+			xml:      `<function lambda="true" var="inc" params="x" local="true"><return>x + 1</return></function>`,
+			expected: "local inc = function(x)\n    return x + 1\nend",
+		},
+		{
+			name:     "Lambda ignores name",
+			xml:      `<function lambda="true" name="ignored" var="inc" params="x"><return>x + 1</return></function>`,
+			expected: "inc = function(x)\n    return x + 1\nend",
+			warnings: 1,
+		},
+		{
+			name:     "Inline lambda",
+			xml:      `<call name="map"><arg><function lambda="true" params="x"><return>x + 1</return></function></arg></call>`,
+			expected: "map(function(x)\n    return x + 1\nend)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompiler()
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != tc.warnings {
+				t.Errorf("Expected %d warnings, got: %v", tc.warnings, warnings)
+			}
+		})
+	}
+
+	// A bare function(...) end statement is not valid Luau
+	for _, xml := range []string{
+		`<function name="f" params="x" lambda="true"><return>x</return></function>`,
+		`<script><if test="ok"><function lambda="true"/></if></script>`,
+	} {
+		if _, err := CompileString(xml); err == nil || !strings.Contains(err.Error(), "lambda function used as a statement requires 'var' attribute") {
+			t.Errorf("Expected lambda statement error for %s, got: %v", xml, err)
+		}
+	}
+}
+
+func TestIteratorTags(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Call arg", `<call name="process"><arg><ipairs table="items"/></arg></call>`, "process(ipairs(items))"},
+		{"Mixed args", `<call name="each"><arg>"players"</arg><arg><pairs table="players"/></arg></call>`, `each("players", pairs(players))`},
+		{"Self-call arg", `<self-call object="queue" method="PushAll"><arg><ipairs table="jobs"/></arg></self-call>`, "queue:PushAll(ipairs(jobs))"},
+		{"Local iterator", `<pairs var="iter" local="true" table="config"/>`, "local iter = pairs(config)"},
+		{"Assigned iterator", `<ipairs var="iter" table="list"/>`, "iter = ipairs(list)"},
+		{"Indented call", `<if test="ready"><call name="run"><arg><pairs table="t"/></arg></call></if>`, "if ready then\n    run(pairs(t))\nend"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestIteratorTagErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Missing ipairs table", `<ipairs var="iter"/>`},
+		{"Missing pairs table in arg", `<call name="f"><arg><pairs/></arg></call>`},
+		{"Arg with content and child", `<call name="f"><arg>x<ipairs table="t"/></arg></call>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+}
+
+func TestNamedArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Options table", `<call name="create"><arg name="width">10</arg><arg name="height">20</arg></call>`, "create({ width = 10, height = 20 })"},
+		{"Expression value", `<call name="run"><arg name="items"><ipairs table="t"/></arg></call>`, "run({ items = ipairs(t) })"},
+		{"Self-call", `<self-call object="gui" method="Open"><arg name="modal">true</arg></self-call>`, "gui:Open({ modal = true })"},
+		{"Positional", `<call name="create"><arg>10</arg><arg>20</arg></call>`, "create(10, 20)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	errorCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Named then positional", `<call name="f"><arg name="a">1</arg><arg>2</arg></call>`, "call cannot mix named and positional arguments"},
+		{"Positional then named", `<call name="f"><arg>1</arg><arg name="a">2</arg></call>`, "call cannot mix named and positional arguments"},
+		{"Content and named", `<call name="f">x<arg name="a">1</arg></call>`, "call cannot mix named and positional arguments"},
+		{"Self-call args and named", `<self-call object="o" method="m" args="x"><arg name="a">1</arg></self-call>`, "self-call cannot mix named and positional arguments"},
+		{"Duplicate name", `<call name="f"><arg name="a">1</arg><arg name="a">2</arg></call>`, "call has duplicate argument 'a'"},
+		{"Invalid name", `<call name="f"><arg name="max-size">1</arg></call>`, "invalid argument name: max-size"},
+		{"Empty value", `<call name="f"><arg name="a"></arg></call>`, "call argument 'a' requires a value"},
+		{"Format", `<format template="%d"><arg name="n">1</arg></format>`, "format does not accept named arguments"},
+		{"Print", `<print><arg name="n">1</arg></print>`, "print does not accept named arguments"},
+	}
+
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestSelfCall(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Statement", `<self-call object="connection" method="Disconnect"/>`, `connection:Disconnect()`},
+		{"Args attribute", `<self-call object="part" method="SetAttribute" args='"Health", 100'/>`, `part:SetAttribute("Health", 100)`},
+		{"Arg children", `<self-call object="remote" method="FireServer"><arg>"hit"</arg><arg>target</arg></self-call>`, `remote:FireServer("hit", target)`},
+		{"Captured result", `<self-call var="child" local="true" object="workspace" method="FindFirstChild" args='"Map"'/>`, `local child = workspace:FindFirstChild("Map")`},
+		{"Chained", `<self-call object='workspace:FindFirstChild("Map")' method="Destroy"/>`, `workspace:FindFirstChild("Map"):Destroy()`},
+		{"Indented", `<if test="conn"><self-call object="conn" method="Disconnect"/></if>`, "if conn then\n    conn:Disconnect()\nend"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	for _, xml := range []string{
+		`<self-call method="Destroy"/>`,
+		`<self-call object="part"/>`,
+		`<self-call object="part" method="a.b"/>`,
+	} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
+	}
+}
+
+func TestUpvalue(t *testing.T) {
+	xml := `<function name="makeCounter" params="step" local="true">
+  <set var="count" local="true">0</set>
+  <function lambda="true" var="increment" local="true">
+    <upvalue captures="count,step"/>
+    <set var="count">count + step</set>
+  </function>
+  <return>increment</return>
+</function>`
+
+	expected := `local function makeCounter(step)
+    local count = 0
+    local increment = function()
+        -- captures: count, step
+        count = count + step
+    end
+    return increment
+end`
+
+	compiler := NewCompiler()
+	result, err := compiler.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+	if warnings := compiler.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got: %v", warnings)
+	}
+
+	minified, err := NewCompilerWithOptions(CompileOptions{Minify: true}).CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if strings.Contains(minified, "captures") {
+		t.Errorf("Expected minify to strip upvalue comments, got:\n%s", minified)
+	}
+}
+
+func TestUpvalueOutsideFunction(t *testing.T) {
+	compiler := NewCompiler()
+	result, err := compiler.CompileFromString(`<upvalue captures="x"/>`)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != "-- captures: x" {
+		t.Errorf("Unexpected output: %s", result)
+	}
+	if warnings := compiler.Warnings(); len(warnings) != 1 || warnings[0].Tag != "upvalue" {
+		t.Errorf("Expected one upvalue warning, got: %v", warnings)
+	}
+
+	for _, xml := range []string{`<upvalue/>`, `<upvalue captures="a.b"/>`} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
+	}
+}
+
+func TestVariadicFunction(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name:     "Vararg only",
+			xml:      `<function name="log" variadic="true"><vararg var="first" local="true"/></function>`,
+			expected: "function log(...)\n    local first = ...\nend",
+		},
+		{
+			name:     "Untyped params",
+			xml:      `<function name="fire" params="event" variadic="true" local="true"><vararg var="args" local="true" pack="true"/></function>`,
+			expected: "local function fire(event, ...)\n    local args = {...}\nend",
+		},
+		{
+			name:     "Typed params",
+			xml:      `<function name="fire" params="event: string" variadic="true"></function>`,
+			expected: "function fire(event: string, ...: any)\nend",
+		},
+		{
+			name:     "Explicit vararg type",
+			xml:      `<function name="sum" variadic="true" variadic-type="number"></function>`,
+			expected: "function sum(...: number)\nend",
+		},
+		{
+			name:     "Lambda",
+			xml:      `<function lambda="true" var="pack" local="true" variadic="true"><vararg var="packed" local="true" pack="true"/><return>packed</return></function>`,
+			expected: "local pack = function(...)\n    local packed = {...}\n    return packed\nend",
+		},
+		{
+			name:     "Top level chunk",
+			xml:      `<vararg var="scriptArgs" local="true" pack="true"/>`,
+			expected: "local scriptArgs = {...}",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestVarargOutsideVariadicFunction(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Plain function", `<function name="f"><vararg var="x"/></function>`},
+		{"Nested in variadic", `<function name="outer" variadic="true"><function name="inner"><vararg/></function></function>`},
+		{"Duplicate vararg", `<function name="f" params="a, ..." variadic="true"></function>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+
+	// Variadic context is restored after a nested function
+	xml := `<function name="outer" variadic="true"><function name="inner"></function><vararg var="x"/></function>`
+	if _, err := CompileString(xml); err != nil {
+		t.Errorf("Expected vararg after nested function to compile, got: %v", err)
+	}
+
+	// Explicit ... in params also makes the function variadic
+	if _, err := CompileString(`<function name="f" params="..."><vararg/></function>`); err != nil {
+		t.Errorf("Expected ... in params to allow vararg, got: %v", err)
+	}
+}
+
+func TestFunctionCall(t *testing.T) {
+	xml := `<call name="greet">
+  <arg>"Alice"</arg>
+  <arg>"Bob"</arg>
+</call>`
+
+	expected := `greet("Alice", "Bob")`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestTable(t *testing.T) {
+	xml := `<table var="config" local="true">
+  <entry key="name">"MyApp"</entry>
+  <entry key="version">1.0</entry>
+  <entry key="debug">true</entry>
+</table>`
+
+	expected := `local config = {
+    name = "MyApp",
     version = 1.0,
     debug = true,
 }`
 
-	result, err := CompileString(xml)
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestTableTrailingComma(t *testing.T) {
+	xml := `<table var="t" local="true">
+  <item>"first"</item>
+  <entry key="nested"><table><entry key="x">1</entry></table></entry>
+  <entry key="last">true</entry>
+</table>`
+
+	testCases := []struct {
+		name            string
+		noTrailingComma bool
+		expected        string
+	}{
+		{
+			name: "With trailing comma",
+			expected: `local t = {
+    "first",
+    nested = {
+        x = 1,
+    },
+    last = true,
+}`,
+		},
+		{
+			name:            "Without trailing comma",
+			noTrailingComma: true,
+			expected: `local t = {
+    "first",
+    nested = {
+        x = 1
+    },
+    last = true
+}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := NewCompilerWithOptions(CompileOptions{NoTrailingComma: tc.noTrailingComma}).CompileFromString(xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	// Options that say nothing about commas keep the default
+	result, err := NewCompilerWithOptions(CompileOptions{Minify: true}).CompileFromString(`<table var="t"><entry key="x">1</entry></table>`)
+	if expected := "t = {\nx = 1,\n}"; err != nil || result != expected {
+		t.Errorf("Expected %q with only Minify set, got %q (error: %v)", expected, result, err)
+	}
+}
+
+func TestTableSortedKeys(t *testing.T) {
+	xml := `<table var="t" local="true" sort="keys">
+  <entry key="zeta">1</entry>
+  <entry key="10">"ten"</entry>
+  <entry key="alpha">2</entry>
+  <entry key="2">"two"</entry>
+  <entry key="Beta">3</entry>
+</table>`
+
+	expected := `local t = {
+    [2] = "two",
+    [10] = "ten",
+    Beta = 3,
+    alpha = 2,
+    zeta = 1,
+}`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestInlineTableKeepsSourceOrder(t *testing.T) {
+	xml := `<table>
+  <entry key="b">1</entry>
+  <entry key="a">2</entry>
+</table>`
+
+	expected := `{
+    b = 1,
+    a = 2,
+}`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+
+	if _, err := CompileString(`<table sort="values"/>`); err == nil {
+		t.Error("Expected error for unknown sort order")
+	}
+}
+
+func TestMixedTable(t *testing.T) {
+	xml := `<table var="mixed" local="true">
+  <item>"a"</item>
+  <entry key="x">1</entry>
+  <item>"b"</item>
+</table>`
+
+	expected := `local mixed = {
+    "a",
+    x = 1,
+    "b",
+}`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestMixedTableSortedKeys(t *testing.T) {
+	xml := `<table sort="keys">
+  <entry key="b">2</entry>
+  <item>"first"</item>
+  <entry key="a">1</entry>
+  <item>"second"</item>
+</table>`
+
+	expected := `{
+    "first",
+    "second",
+    a = 1,
+    b = 2,
+}`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestNestedTableEntries(t *testing.T) {
+	xml := `<table var="config" local="true">
+  <entry key="name">"App"</entry>
+  <entry key="window">
+    <table>
+      <entry key="width">800</entry>
+      <entry key="size"><table><entry key="h">600</entry></table></entry>
+    </table>
+  </entry>
+  <entry key="tags"><array><item>"a"</item><item>"b"</item></array></entry>
+  <entry key="onLoad"><function lambda="true"><print>"loaded"</print></function></entry>
+</table>`
+
+	expected := `local config = {
+    name = "App",
+    window = {
+        width = 800,
+        size = {
+            h = 600,
+        },
+    },
+    tags = {"a", "b"},
+    onLoad = function()
+        print("loaded")
+    end,
+}`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestEntryWithContentAndChildError(t *testing.T) {
+	xml := `<table><entry key="x">1<table/></entry></table>`
+
+	_, err := CompileString(xml)
+	if err == nil || !strings.Contains(err.Error(), "both content and a child element") {
+		t.Errorf("Expected content/child conflict error, got: %v", err)
+	}
+}
+
+func TestArray(t *testing.T) {
+	xml := `<array var="numbers" local="true">
+  <item>1</item>
+  <item>2</item>
+  <item>3</item>
+</array>`
+
+	expected := `local numbers = {1, 2, 3}`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Local assignment",
+			xml: `<format var="msg" local="true" template="Hello %s, you are %d">
+  <arg>name</arg>
+  <arg>age</arg>
+</format>`,
+			expected: `local msg = string.format("Hello %s, you are %d", name, age)`,
+		},
+		{
+			name:     "Escaped template",
+			xml:      `<format var="msg" template='Say "%s"&#10;'><arg>word</arg></format>`,
+			expected: `msg = string.format("Say \"%s\"\n", word)`,
+		},
+		{
+			name:     "Inline expression",
+			xml:      `<format template="%d%%"><arg>pct</arg></format>`,
+			expected: `string.format("%d%%", pct)`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	if _, err := CompileString(`<format var="msg"><arg>x</arg></format>`); err == nil {
+		t.Error("Expected error for missing template")
+	}
+	if _, err := CompileString(`<format var="1msg" template="%s"><arg>x</arg></format>`); err == nil {
+		t.Error("Expected error for invalid variable name")
+	}
+}
+
+func TestConcat(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name:     "Chain without separator",
+			xml:      `<concat var="full" local="true"><part>first</part><part>" "</part><part>last</part></concat>`,
+			expected: `local full = first .. " " .. last`,
+		},
+		{
+			name:     "Separator",
+			xml:      `<concat var="full" local="true" sep=" "><part>first</part><part>middle</part><part>last</part></concat>`,
+			expected: `local full = table.concat({first, middle, last}, " ")`,
+		},
+		{
+			name:     "Plain text parts are quoted",
+			xml:      `<concat sep=", "><part>Hello there</part><part>player.Name</part><part>welcome back</part></concat>`,
+			expected: `table.concat({"Hello there", player.Name, "welcome back"}, ", ")`,
+		},
+		{
+			name:     "Single part",
+			xml:      `<concat var="s"><part>name</part></concat>`,
+			expected: `s = name`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	if _, err := CompileString(`<concat var="s" sep=","></concat>`); err == nil {
+		t.Error("Expected error for concat without parts")
+	}
+}
+
+func TestRawCode(t *testing.T) {
+	xml := `<raw>
+local function complex()
+    return math.random() * 100
+end
+</raw>`
+
+	expected := `local function complex()
+    return math.random() * 100
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestRawCodeDedent(t *testing.T) {
+	xml := `<script>
+  <if test="enabled">
+    <if test="ready">
+      <raw>
+        local function step(dt)
+            if dt > 1 then
+                dt = 1
+            end
+
+            return dt * speed
+        end
+      </raw>
+    </if>
+  </if>
+</script>`
+
+	expected := `if enabled then
+    if ready then
+        local function step(dt)
+            if dt > 1 then
+                dt = 1
+            end
+
+            return dt * speed
+        end
+    end
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestComment(t *testing.T) {
+	xml := `<comment>This is a test comment</comment>`
+	expected := `-- This is a test comment`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestMultiLineComment(t *testing.T) {
+	xml := `<comment>This is a
+multi-line
+comment</comment>`
+
+	expected := `-- This is a
+-- multi-line
+-- comment`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestBlockComment(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name:     "Single line",
+			xml:      `<comment block="true">Module header</comment>`,
+			expected: "--[[\nModule header\n]]",
+		},
+		{
+			name: "Multi-line inside a block",
+			xml: `<if test="debug">
+<comment block="true">Line one
+Line two</comment>
+</if>`,
+			expected: "if debug then\n    --[[\nLine one\nLine two\n    ]]\nend",
+		},
+		{
+			name:     "Content closing a block comment",
+			xml:      `<comment block="true">t[a[1]] = 2</comment>`,
+			expected: "--[=[\nt[a[1]] = 2\n]=]",
+		},
+		{
+			name:     "Content closing level one",
+			xml:      `<comment block="true">x = [[a]] .. [=[b]=]</comment>`,
+			expected: "--[==[\nx = [[a]] .. [=[b]=]\n]==]",
+		},
+		{
+			name:     "Only level two used",
+			xml:      `<comment block="true">s = [==[ -- ]==]</comment>`,
+			expected: "--[[\ns = [==[ -- ]==]\n]]",
+		},
+		{
+			name:     "Explicit line comments",
+			xml:      `<comment block="false">Plain</comment>`,
+			expected: "-- Plain",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestPragmaIsHoisted(t *testing.T) {
+	xml := `<script>
+  <set var="x" local="true">1</set>
+  <pragma mode="strict"/>
+  <print>x</print>
+</script>`
+
+	expected := `--!strict
+local x = 1
+print(x)`
+
+	compiler := NewCompiler()
+	result, err := compiler.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+
+	// Streaming cannot move the pragma back above written output
+	var out strings.Builder
+	if err := compiler.CompileStream(strings.NewReader(xml), &out); err == nil {
+		t.Error("Expected streaming a late pragma to fail")
+	}
+}
+
+func TestPragmaStreamsWhenFirst(t *testing.T) {
+	xml := `<script><pragma mode="nocheck"/><set var="x">1</set></script>`
+
+	var out strings.Builder
+	if err := CompileStream(strings.NewReader(xml), &out); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if expected := "--!nocheck\nx = 1"; out.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+	}
+}
+
+func TestDuplicatePragmaWarns(t *testing.T) {
+	xml := `<script>
+  <pragma mode="strict"/>
+  <pragma mode="nonstrict"/>
+</script>`
+
+	compiler := NewCompiler()
+	result, err := compiler.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != "--!strict" {
+		t.Errorf("Expected first pragma to win, got: %s", result)
+	}
+	if warnings := compiler.Warnings(); len(warnings) != 1 || warnings[0].Tag != "pragma" {
+		t.Errorf("Expected one pragma warning, got: %v", warnings)
+	}
+}
+
+func TestSpawn(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		target   Target
+		expected string
+		warnings int
+	}{
+		{"Task", `<spawn><print>"hi"</print></spawn>`, TargetLuau, "task.spawn(function()\n    print(\"hi\")\nend)", 0},
+		{"Coroutine", `<spawn mode="coroutine"><print>"hi"</print></spawn>`, TargetLuau, "coroutine.wrap(function()\n    print(\"hi\")\nend)()", 0},
+		{"Nested indentation", `<if test="ok"><spawn><set var="x" local="true">1</set><print>x</print></spawn></if>`, TargetLuau, "if ok then\n    task.spawn(function()\n        local x = 1\n        print(x)\n    end)\nend", 0},
+		{"Lua 5.1", `<spawn><print>1</print></spawn>`, TargetLua51, "coroutine.wrap(function()\n    print(1)\nend)()", 0},
+		{"Empty", `<spawn/>`, TargetLuau, "task.spawn(function()\nend)", 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{Target: tc.target})
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			warnings := compiler.Warnings()
+			if len(warnings) != tc.warnings {
+				t.Fatalf("Expected %d warnings, got: %v", tc.warnings, warnings)
+			}
+			if tc.warnings > 0 && !strings.Contains(warnings[0].Message, "spawn has no body") {
+				t.Errorf("Expected no-op spawn warning, got: %v", warnings[0])
+			}
+		})
+	}
+}
+
+func TestSpawnErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Invalid mode", `<spawn mode="thread"><print>1</print></spawn>`, "invalid spawn mode: thread"},
+		{"Break crosses function", `<while test="true"><spawn><break/></spawn></while>`, "break must be inside a loop"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := CompileString(tc.xml)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestMemoize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Single key",
+			xml: `<memoize name="fib" key-param="n" local="true">
+  <if test="n &lt; 2"><return>n</return></if>
+  <return>fib(n - 1) + fib(n - 2)</return>
+</memoize>`,
+			expected: `local _cache_fib_1 = {}
+local function fib(n)
+    if _cache_fib_1[n] ~= nil then
+        return _cache_fib_1[n]
+    end
+    local _lunariaResult = (function()
+        if n < 2 then
+            return n
+        end
+        return fib(n - 1) + fib(n - 2)
+    end)()
+    _cache_fib_1[n] = _lunariaResult
+    return _lunariaResult
+end`,
+		},
+		{
+			name: "Multiple keys",
+			xml:  `<memoize name="distance" key-param="a, b"><return>math.abs(a - b)</return></memoize>`,
+			expected: `local _cache_distance_1 = {}
+function distance(a, b)
+    local _lunariaKey = tostring(a) .. "\0" .. tostring(b)
+    if _cache_distance_1[_lunariaKey] ~= nil then
+        return _cache_distance_1[_lunariaKey]
+    end
+    local _lunariaResult = (function()
+        return math.abs(a - b)
+    end)()
+    _cache_distance_1[_lunariaKey] = _lunariaResult
+    return _lunariaResult
+end`,
+		},
+		{
+			name: "Nested",
+			xml:  `<if test="ok"><memoize name="load" key-param="id" local="true"><return>fetch(id)</return></memoize></if>`,
+			expected: `if ok then
+    local _cache_load_1 = {}
+    local function load(id)
+        if _cache_load_1[id] ~= nil then
+            return _cache_load_1[id]
+        end
+        local _lunariaResult = (function()
+            return fetch(id)
+        end)()
+        _cache_load_1[id] = _lunariaResult
+        return _lunariaResult
+    end
+end`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	// The generated locals never shadow a parameter, and each cache gets
+	// its own number
+	xml := `<script><memoize name="f" key-param="result"><return>result * 2</return></memoize><memoize name="f" key-param="_key, b"><return>_key</return></memoize></script>`
+	expected := `local _cache_f_1 = {}
+function f(result)
+    if _cache_f_1[result] ~= nil then
+        return _cache_f_1[result]
+    end
+    local _lunariaResult = (function()
+        return result * 2
+    end)()
+    _cache_f_1[result] = _lunariaResult
+    return _lunariaResult
+end
+local _cache_f_2 = {}
+function f(_key, b)
+    local _lunariaKey = tostring(_key) .. "\0" .. tostring(b)
+    if _cache_f_2[_lunariaKey] ~= nil then
+        return _cache_f_2[_lunariaKey]
+    end
+    local _lunariaResult = (function()
+        return _key
+    end)()
+    _cache_f_2[_lunariaKey] = _lunariaResult
+    return _lunariaResult
+end`
+	if result, err := CompileString(xml); err != nil || result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s (error: %v)", expected, result, err)
+	}
+
+	// Minified output drops the indentation of every generated line
+	minified := NewCompilerWithOptions(CompileOptions{Minify: true})
+	xml = `<function name="outer"><memoize name="fib" key-param="n" local="true"><return>n</return></memoize></function>`
+	expected = "function outer()\nlocal _cache_fib_1 = {}\nlocal function fib(n)\nif _cache_fib_1[n] ~= nil then\nreturn _cache_fib_1[n]\nend\nlocal _lunariaResult = (function()\nreturn n\nend)()\n_cache_fib_1[n] = _lunariaResult\nreturn _lunariaResult\nend\nend"
+	if result, err := minified.CompileFromString(xml); err != nil || result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s (error: %v)", expected, result, err)
+	}
+
+	for _, key := range []string{"_lunariaResult", "a, _lunariaKey", "_cache_g_1"} {
+		xml := fmt.Sprintf(`<memoize name="g" key-param="%s"/>`, key)
+		if _, err := CompileString(xml); err == nil || !strings.Contains(err.Error(), "collides with a name generated by memoize") {
+			t.Errorf("Expected collision error for %s, got: %v", xml, err)
+		}
+	}
+
+	for _, xml := range []string{`<memoize key-param="n"/>`, `<memoize name="f"/>`, `<memoize name="a.b" key-param="n"/>`, `<memoize name="f" key-param="n, 2x"/>`} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
+	}
+}
+
+func TestAssert(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Plain message", `<assert test="x ~= nil">Variable x must not be nil</assert>`, `assert(x ~= nil, "Variable x must not be nil")`},
+		{"No message", `<assert test="ok"/>`, `assert(ok)`},
+		{"Interpolated message", `<assert test="n > 0">n was {{n}}</assert>`, `assert(n > 0, "n was " .. tostring(n) .. "")`},
+		{"Interpolated quotes", `<assert test="ok">"{{name}}" failed</assert>`, `assert(ok, "\"" .. tostring(name) .. "\" failed")`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	compiler := NewCompilerWithOptions(CompileOptions{BacktickStrings: true})
+	result, err := compiler.CompileFromString(`<assert test="n > 0">n was {{n}}</assert>`)
+	if expected := "assert(n > 0, `n was {n}`)"; err != nil || result != expected {
+		t.Errorf("Expected %q with backtick strings, got %q (error: %v)", expected, result, err)
+	}
+}
+
+func TestInstanceof(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"IsA expression", `<instanceof var="part" class="BasePart"/>`, `part:IsA("BasePart")`},
+		{"Explicit roblox", `<instanceof var="hit.Parent" class="Model" roblox="true"/>`, `hit.Parent:IsA("Model")`},
+		{"Expression object", `<instanceof var="a or b" class="Model"/>`, `(a or b):IsA("Model")`},
+		{"Typeof fallback", `<instanceof var="value" class="string" roblox="false"/>`, `typeof(value) == "string"`},
+		{"Assignment", `<instanceof var="hit" class="Humanoid" result="ok" local="true"/>`, `local ok = hit:IsA("Humanoid")`},
+		{"Global assignment", `<instanceof var="v" class="table" roblox="false" result="isTable"/>`, `isTable = typeof(v) == "table"`},
+		{"Inside call", `<call name="print"><arg><instanceof var="x" class="Part"/></arg></call>`, `print(x:IsA("Part"))`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	errorCases := map[string]string{
+		`<instanceof var="x"/>`:                          "requires 'class' attribute",
+		`<instanceof class="Part"/>`:                     "requires 'var' attribute",
+		`<instanceof var="x" class="Part" result="1a"/>`: "invalid variable name",
+	}
+	for xml, msg := range errorCases {
+		if _, err := CompileString(xml); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected error containing %q for %s, got: %v", msg, xml, err)
+		}
+	}
+}
+
+func TestOutputEscapes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Print literal newline", `<print>"Line 1&#10;Line 2"</print>`, `print("Line 1\nLine 2")`},
+		{"Print literal tab", `<print>"a&#9;b"</print>`, `print("a\tb")`},
+		{"Print literal backslash", `<print>"C:\\temp"</print>`, `print("C:\\temp")`},
+		{"Print interpolated", `<print>Tab&#9;{{x}}&#10;C:\temp</print>`, `print("Tab\t" .. tostring(x) .. "\nC:\\temp")`},
+		{"Warn literal newline", `<warn>"a&#10;b"</warn>`, `warn("a\nb")`},
+		{"Warn interpolated", `<warn>{{a}}&#9;{{b}}</warn>`, `warn("" .. tostring(a) .. "\t" .. tostring(b) .. "")`},
+		{"Error literal newline", `<error>"bad&#10;input"</error>`, `error("bad\ninput", 1)`},
+		{"Error interpolated", `<error>bad\{{x}}</error>`, `error("bad\\" .. tostring(x) .. "", 1)`},
+		{"Assert text", `<assert test="ok">a&#9;b\c</assert>`, `assert(ok, "a\tb\\c")`},
+		{"Assert literal", `<assert test="ok">"line&#10;two"</assert>`, `assert(ok, "line\ntwo")`},
+		{"Istring", `<istring>a&#9;{{b}}\</istring>`, "`a\\t{b}\\\\`"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestNumber(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Decimal", `<number var="x" local="true" value="42"/>`, `local x = 42`},
+		{"Typed", `<number var="pi" local="true" type="number" value="3.14"/>`, `local pi: number = 3.14`},
+		{"Hex from decimal", `<number var="mask" local="true" value="255" base="hex"/>`, `local mask = 0xFF`},
+		{"Hex kept", `<number value="0xff"/>`, `0xFF`},
+		{"Hex to decimal", `<number value="0x10" base="dec"/>`, `16`},
+		{"Binary", `<number value="10" base="bin" group="4"/>`, `0b1010`},
+		{"Grouped binary", `<number value="255" base="bin" group="4"/>`, `0b1111_1111`},
+		{"Grouped hex", `<number value="0xDEADBEEF" group="4"/>`, `0xDEAD_BEEF`},
+		{"Scientific", `<number var="big" value="1e6"/>`, `big = 1e6`},
+		{"Scientific fraction", `<number value="6.022E23" group="3"/>`, `6.022E23`},
+		{"Grouped", `<number var="million" local="true" value="1000000" group="3"/>`, `local million = 1_000_000`},
+		{"Grouped fraction", `<number value="12345.678" group="3"/>`, `12_345.678`},
+		{"Regrouped", `<number value="1_0000_00" group="3"/>`, `1_000_000`},
+		{"Negative hex", `<number value="-16" base="hex"/>`, `-0x10`},
+		{"Content value", `<number var="n">7</number>`, `n = 7`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	errorCases := map[string]string{
+		`<number/>`:                                 "requires 'value' attribute",
+		`<number value="abc"/>`:                     "invalid number: abc",
+		`<number value="0xZZ"/>`:                    "invalid number: 0xZZ",
+		`<number value="1.5" base="hex"/>`:          "not an integer",
+		`<number value="1" base="oct"/>`:            "unknown base: oct",
+		`<number value="1" group="0"/>`:             "invalid group",
+		`<number var="x" value="1" type="number"/>`: "local 'var' to annotate",
+		`<number var="1x" local="true" value="1"/>`: "invalid variable name",
+		`<number value="inf"/>`:                     "invalid number",
+	}
+	for xml, msg := range errorCases {
+		if _, err := CompileString(xml); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected error containing %q for %s, got: %v", msg, xml, err)
+		}
+	}
+
+	lua51 := NewCompilerWithOptions(CompileOptions{Target: TargetLua51})
+	if result, err := lua51.CompileFromString(`<number value="255" base="hex"/>`); err != nil || result != "0xFF" {
+		t.Errorf("Expected hex to be allowed in Lua 5.1, got %q (error: %v)", result, err)
+	}
+	for _, xml := range []string{`<number value="1000" group="3"/>`, `<number value="5" base="bin"/>`, `<number var="x" local="true" type="number" value="1"/>`} {
+		if _, err := lua51.CompileFromString(xml); err == nil {
+			t.Errorf("Expected Lua 5.1 to reject %s", xml)
+		}
+	}
+}
+
+func TestNotAndBool(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Not expression", `<not>ready</not>`, `not (ready)`},
+		{"Not compound", `<not>a and b</not>`, `not (a and b)`},
+		{"Not assignment", `<not var="blocked" local="true">allowed</not>`, `local blocked = not (allowed)`},
+		{"Bool expression", `<bool>player.Character</bool>`, `not not (player.Character)`},
+		{"Bool assignment", `<bool var="alive">humanoid.Health > 0</bool>`, `alive = not not (humanoid.Health > 0)`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	for _, xml := range []string{`<not></not>`, `<bool var="b"></bool>`} {
+		if _, err := CompileString(xml); err == nil {
+			t.Errorf("Expected error for %s", xml)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Local assignment", `<length var="n" local="true">items</length>`, `local n = #items`},
+		{"Field operand", `<length var="n">self.queue</length>`, `n = #self.queue`},
+		{"Inline expression", `<length>items</length>`, `#items`},
+		{"Complex operand", `<length>a .. b</length>`, `#(a .. b)`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+
+	if _, err := CompileString(`<length var="n"></length>`); err == nil {
+		t.Error("Expected error for missing operand")
+	}
+}
+
+func TestTernary(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name:     "Local assignment",
+			xml:      `<ternary var="label" local="true" test="n > 0" then='"positive"' else-val='"negative"'/>`,
+			expected: `local label = (n > 0 and "positive" or "negative")`,
+		},
+		{
+			name:     "Global assignment",
+			xml:      `<ternary var="label" test="ok" then="a" else-val="b"/>`,
+			expected: `label = (ok and a or b)`,
+		},
+		{
+			name:     "Inline expression",
+			xml:      `<ternary test="flag" then="1" else-val="2"/>`,
+			expected: `(flag and 1 or 2)`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestTernaryMissingBranch(t *testing.T) {
+	_, err := CompileString(`<ternary var="x" test="ok" then="1"/>`)
+	if err == nil || !strings.Contains(err.Error(), "'else-val'") {
+		t.Errorf("Expected missing else-val error, got: %v", err)
+	}
+}
+
+func TestComplexScript(t *testing.T) {
+	xml := `<script>
+  <comment>A complex example script</comment>
+  <set var="numbers" local="true">{1, 2, 3, 4, 5}</set>
+  
+  <function name="processNumbers" params="nums" local="true">
+    <set var="sum" local="true">0</set>
+    <for var="i, num" in="ipairs(nums)">
+      <set var="sum">sum + num</set>
+      <if test="num % 2 == 0">
+        <print>{{num}} is even</print>
+      </if>
+    </for>
+    <return>sum</return>
+  </function>
+  
+  <set var="result" local="true">processNumbers(numbers)</set>
+  <print>Total sum: {{result}}</print>
+</script>`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	// Check that it contains expected elements
+	if !strings.Contains(result, "-- A complex example script") {
+		t.Error("Missing comment")
+	}
+	if !strings.Contains(result, "local numbers = {1, 2, 3, 4, 5}") {
+		t.Error("Missing numbers assignment")
+	}
+	if !strings.Contains(result, "local function processNumbers(nums)") {
+		t.Error("Missing function declaration")
+	}
+	if !strings.Contains(result, "for i, num in ipairs(nums) do") {
+		t.Error("Missing for loop")
+	}
+	if !strings.Contains(result, "print(\"Total sum: \" .. tostring(result) .. \"\")") {
+		t.Error("Missing interpolated print")
+	}
+}
+
+func TestCustomHandler(t *testing.T) {
+	compiler := NewCompiler()
+
+	// Register a custom log handler
+	compiler.Register("log", func(node Node, c *Compiler) (string, error) {
+		level := GetAttrWithDefault(node, "level", "info")
+		message := strings.TrimSpace(node.Content)
+		return fmt.Sprintf("%slogger.%s(%s)", c.getIndent(), level, WrapInQuotes(message)), nil
+	})
+
+	xml := `<log level="debug">Application starting</log>`
+	expected := `logger.debug("Application starting")`
+
+	result, err := compiler.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestCompileChildren(t *testing.T) {
+	compiler := NewCompiler()
+
+	// A custom block command built on CompileChildren
+	compiler.Register("unless", func(node Node, c *Compiler) (string, error) {
+		c.indent++
+		body, err := c.CompileChildren(node)
+		c.indent--
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%sif not (%s) then\n%s\n%send", c.getIndent(), GetAttr(node, "test"), body, c.getIndent()), nil
+	})
+
+	xml := `<for var="i" from="1" to="3"><unless test="i == 2"><comment>skip two</comment><raw></raw><print>i</print></unless></for>`
+	expected := "for i = 1, 3 do\n    if not (i == 2) then\n        -- skip two\n        print(i)\n    end\nend"
+
+	result, err := compiler.CompileFromString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+
+	if _, err := compiler.CompileFromString(`<unless test="x"><bogus/></unless>`); err == nil || !strings.Contains(err.Error(), "unknown tag: bogus") {
+		t.Errorf("Expected child errors to propagate, got: %v", err)
+	}
+
+	if body, err := compiler.CompileChildren(Node{}); err != nil || body != "" {
+		t.Errorf("Expected no code for a node without children, got %q (error: %v)", body, err)
+	}
+}
+
+func TestIndentAPI(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.Dedent()
+	if got := compiler.CurrentIndent(); got != "" {
+		t.Errorf("Expected Dedent at the top level to be a no-op, got %q", got)
+	}
+
+	compiler.Indent()
+	compiler.Indent()
+	if got := compiler.CurrentIndent(); got != "        " {
+		t.Errorf("Expected two levels of indentation, got %q", got)
+	}
+	compiler.Dedent()
+	if got := compiler.CurrentIndent(); got != "    " {
+		t.Errorf("Expected one level of indentation, got %q", got)
+	}
+
+	compiler.SetOptions(CompileOptions{Minify: true})
+	if got := compiler.CurrentIndent(); got != "" {
+		t.Errorf("Expected no indentation when minifying, got %q", got)
+	}
+}
+
+func TestClone(t *testing.T) {
+	original := NewCompiler()
+	original.Register("original", func(node Node, c *Compiler) (string, error) {
+		return "original()", nil
+	})
+
+	clone := original.Clone()
+	clone.Register("cloned", func(node Node, c *Compiler) (string, error) {
+		return "cloned()", nil
+	})
+	original.Register("late", func(node Node, c *Compiler) (string, error) {
+		return "late()", nil
+	})
+
+	if result, err := clone.CompileFromString(`<original/>`); err != nil || result != "original()" {
+		t.Errorf("Expected clone to inherit handler, got %q, %v", result, err)
+	}
+	if _, err := original.CompileFromString(`<cloned/>`); err == nil {
+		t.Error("Expected handler registered on clone to be absent from original")
+	}
+	if _, err := clone.CompileFromString(`<late/>`); err == nil {
+		t.Error("Expected handler registered on original after cloning to be absent from clone")
+	}
+	if _, err := clone.CompileFromString(`<set var="x">1</set>`); err != nil {
+		t.Errorf("Expected clone to keep built-ins, got: %v", err)
+	}
+}
+
+func TestCloneCopiesOptions(t *testing.T) {
+	original := NewCompilerWithOptions(CompileOptions{Minify: true})
+
+	clone := original.Clone()
+	if !clone.Options().Minify {
+		t.Error("Expected clone to inherit options")
+	}
+
+	clone.SetOptions(CompileOptions{})
+	if !original.Options().Minify {
+		t.Error("Expected changing clone options to leave the original untouched")
+	}
+
+	clone.Register("log", func(node Node, c *Compiler) (string, error) {
+		return "log()", nil
+	})
+	if _, err := original.CompileFromString(`<log/>`); err == nil {
+		t.Error("Expected handler registered on clone not to leak into the original")
+	}
+}
+
+// Run with go test -race to check for data races
+func TestSafeCompilerConcurrentRegister(t *testing.T) {
+	safe := NewSafeCompiler(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		tag := fmt.Sprintf("custom%d", i)
+		go func() {
+			defer wg.Done()
+			safe.Register(tag, func(node Node, c *Compiler) (string, error) {
+				return tag + "()", nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			result, err := safe.CompileFromString(`<set var="x" local="true">1</set>`)
+			if err != nil || result != "local x = 1" {
+				t.Errorf("Unexpected result %q, %v", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 8; i++ {
+		tag := fmt.Sprintf("custom%d", i)
+		if result, err := safe.CompileFromString("<" + tag + "/>"); err != nil || result != tag+"()" {
+			t.Errorf("Expected %s to be registered, got %q, %v", tag, result, err)
+		}
+	}
+}
+
+func TestSafeCompilerOptions(t *testing.T) {
+	safe := NewSafeCompiler(NewCompilerWithOptions(CompileOptions{Minify: true}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			safe.SetOptions(CompileOptions{Minify: true})
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := safe.CompileFromString(`<if test="x"><print>"hi"</print></if>`); err != nil {
+				t.Errorf("Compilation failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := safe.CompileFromString(`<if test="x"><print>"hi"</print></if>`)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if expected := "if x then\nprint(\"hi\")\nend"; result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+
+	clone := safe.Clone()
+	if _, err := clone.CompileFromString(`<unset var="x"/>`); err != nil || !clone.Options().Minify {
+		t.Errorf("Expected clone to carry options, got %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	Register("custom", func(node Node, c *Compiler) (string, error) {
+		return "custom()", nil
+	})
+	if _, err := CompileString(`<custom/>`); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	Reset()
+
+	if _, err := CompileString(`<custom/>`); err == nil {
+		t.Error("Expected custom handler to be removed by Reset")
+	}
+	if _, err := CompileString(`<set var="x">1</set>`); err != nil {
+		t.Errorf("Expected built-ins after Reset, got: %v", err)
+	}
+}
+
+func TestMinify(t *testing.T) {
+	compiler := NewCompilerWithOptions(CompileOptions{Minify: true})
+
+	xml := `<script>
+  <comment>Stripped when minifying</comment>
+  <set var="a" local="true">1</set>
+  <set var="b" local="true">2</set>
+  <if test="a &lt; b">
+    <comment>Also stripped</comment>
+    <for var="i" from="1" to="b">
+      <print>i</print>
+    </for>
+  </if>
+</script>`
+
+	expected := `local a = 1
+local b = 2
+if a < b then
+for i = 1, b do
+print(i)
+end
+end`
+
+	result, err := compiler.CompileFromString(xml)
 	if err != nil {
 		t.Fatalf("Compilation failed: %v", err)
 	}
@@ -196,16 +3496,48 @@ func TestTable(t *testing.T) {
 	}
 }
 
-func TestArray(t *testing.T) {
-	xml := `<array var="numbers" local="true">
-  <item>1</item>
-  <item>2</item>
-  <item>3</item>
-</array>`
+func TestSemicolons(t *testing.T) {
+	compiler := NewCompilerWithOptions(CompileOptions{Semicolons: true})
 
-	expected := `local numbers = {1, 2, 3}`
+	xml := `<script>
+  <comment>Not terminated</comment>
+  <set var="count" local="true">0</set>
+  <function name="bump" local="true">
+    <increment var="count"/>
+  </function>
+  <if test="count == 0">
+    <call name="bump"/>
+  </if>
+  <repeat until="count > 3">
+    <call name="bump"/>
+  </repeat>
+  <function var="reset" local="true" lambda="true">
+    <set var="count">0</set>
+  </function>
+  <raw>
+local t = {}
+(t.f or print)("raw is untouched")
+  </raw>
+</script>`
 
-	result, err := CompileString(xml)
+	expected := `-- Not terminated
+local count = 0;
+local function bump()
+    count = count + 1;
+end
+if count == 0 then
+    bump();
+end
+repeat
+    bump();
+until count > 3
+local reset = function()
+    count = 0;
+end;
+local t = {}
+(t.f or print)("raw is untouched")`
+
+	result, err := compiler.CompileFromString(xml)
 	if err != nil {
 		t.Fatalf("Compilation failed: %v", err)
 	}
@@ -213,139 +3545,324 @@ func TestArray(t *testing.T) {
 	if result != expected {
 		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
 	}
+
+	// Handlers emitting several statements terminate each of them
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Memoize",
+			xml:  `<memoize name="fib" key-param="n" local="true"><return>n</return></memoize>`,
+			expected: `local _cache_fib_1 = {};
+local function fib(n)
+    if _cache_fib_1[n] ~= nil then
+        return _cache_fib_1[n];
+    end
+    local _lunariaResult = (function()
+        return n;
+    end)();
+    _cache_fib_1[n] = _lunariaResult;
+    return _lunariaResult;
+end`,
+		},
+		{
+			name: "Map",
+			xml:  `<map table="items" element="v" var="doubled" local="true">v * 2</map>`,
+			expected: `local doubled = (function()
+    local _r = {};
+    for _, v in ipairs(items) do
+        _r[#_r + 1] = v * 2;
+    end
+    return _r;
+end)();`,
+		},
+		{
+			name: "Filter",
+			xml:  `<filter table="items" element="v" var="positive"><if test="v > 0"/></filter>`,
+			expected: `positive = (function()
+    local _r = {};
+    for _, v in ipairs(items) do
+        if v > 0 then
+            _r[#_r + 1] = v;
+        end
+    end
+    return _r;
+end)();`,
+		},
+		{
+			name: "Reduce",
+			xml:  `<reduce table="items" initial="0" var="sum">acc + value</reduce>`,
+			expected: `sum = (function()
+    local acc = 0;
+    for _, value in ipairs(items) do
+        acc = acc + value;
+    end
+    return acc;
+end)();`,
+		},
+		{
+			name:     "Destructure",
+			xml:      `<destructure from="t"><bind field="a"/><bind field="b" var="c"/></destructure>`,
+			expected: "a = t.a;\nc = t.b;",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := NewCompilerWithOptions(CompileOptions{Semicolons: true}).CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
 }
 
-func TestRawCode(t *testing.T) {
-	xml := `<raw>
-local function complex()
-    return math.random() * 100
-end
-</raw>`
+func TestResetDefault(t *testing.T) {
+	t.Cleanup(ResetDefault)
 
-	expected := `local function complex()
-    return math.random() * 100
-end`
+	Register("set", func(node Node, c *Compiler) (string, error) {
+		return "overridden", nil
+	})
+	if result, _ := CompileString(`<set var="x">1</set>`); result != "overridden" {
+		t.Fatalf("Expected overriding handler to be used, got: %s", result)
+	}
 
-	result, err := CompileString(xml)
+	ResetDefault()
+
+	result, err := CompileString(`<set var="x">1</set>`)
 	if err != nil {
 		t.Fatalf("Compilation failed: %v", err)
 	}
+	if result != "x = 1" {
+		t.Errorf("Expected built-in set handler to be restored, got: %s", result)
+	}
+}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+func TestCompileStreamMatchesBatch(t *testing.T) {
+	inputs := []string{
+		`<set var="x" local="true">42</set>`,
+		`<?xml version="1.0"?>
+<!-- leading comment -->
+<script>
+  <comment>Header</comment>
+  <set var="name" local="true">"World"</set>
+  <raw></raw>
+  <for var="i" from="1" to="3">
+    <print>Hello {{name}} #{{i}}</print>
+  </for>
+</script>`,
+		`<script></script>`,
+	}
+
+	for _, xml := range inputs {
+		expected, err := CompileString(xml)
+		if err != nil {
+			t.Fatalf("Batch compilation failed: %v", err)
+		}
+
+		var out strings.Builder
+		if err := CompileStream(strings.NewReader(xml), &out); err != nil {
+			t.Fatalf("Stream compilation failed: %v", err)
+		}
+
+		if out.String() != expected {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+		}
 	}
 }
 
-func TestComment(t *testing.T) {
-	xml := `<comment>This is a test comment</comment>`
-	expected := `-- This is a test comment`
+func TestCompileStreamErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Unknown tag", `<script><unknown/></script>`, "unknown tag: unknown"},
+		{"Truncated document", `<script><set var="x">1</set>`, "XML parse error"},
+		{"Empty input", ``, "XML parse error"},
+	}
 
-	result, err := CompileString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CompileStream(strings.NewReader(tc.xml), io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing '%s', got: %v", tc.errorMsg, err)
+			}
+		})
 	}
+}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+func TestCompileStringContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := CompileStringContext(ctx, `<set var="x">1</set>`)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
 	}
 }
 
-func TestMultiLineComment(t *testing.T) {
-	xml := `<comment>This is a
-multi-line
-comment</comment>`
+func TestCompileStringContextCancelledMidway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	expected := `-- This is a
--- multi-line
--- comment`
+	compiler := NewCompiler()
+	compiler.Register("stop", func(node Node, c *Compiler) (string, error) {
+		cancel()
+		return "-- stop", nil
+	})
 
-	result, err := CompileString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+	_, err := compiler.CompileFromStringContext(ctx, `<script>
+  <stop/>
+  <set var="x">1</set>
+</script>`)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
 	}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	// The compiler stays usable after a cancelled compilation
+	if _, err := compiler.CompileFromString(`<set var="x">1</set>`); err != nil {
+		t.Errorf("Compilation failed: %v", err)
 	}
 }
 
-func TestAssert(t *testing.T) {
-	xml := `<assert test="x ~= nil">Variable x must not be nil</assert>`
-	expected := `assert(x ~= nil, "Variable x must not be nil")`
+func TestMiddlewareOrder(t *testing.T) {
+	compiler := NewCompiler()
 
-	result, err := CompileString(xml)
-	if err != nil {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(node Node, next Handler) Handler {
+			return func(node Node, c *Compiler) (string, error) {
+				calls = append(calls, name+">"+node.XMLName.Local)
+				code, err := next(node, c)
+				calls = append(calls, name+"<"+node.XMLName.Local)
+				return code, err
+			}
+		}
+	}
+	compiler.Use(trace("outer"))
+	compiler.Use(trace("inner"))
+
+	if _, err := compiler.CompileFromString(`<set var="x">1</set>`); err != nil {
 		t.Fatalf("Compilation failed: %v", err)
 	}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	expected := "outer>set inner>set inner<set outer<set"
+	if got := strings.Join(calls, " "); got != expected {
+		t.Errorf("Expected call order %q, got %q", expected, got)
 	}
 }
 
-func TestComplexScript(t *testing.T) {
-	xml := `<script>
-  <comment>A complex example script</comment>
-  <set var="numbers" local="true">{1, 2, 3, 4, 5}</set>
-  
-  <function name="processNumbers" params="nums" local="true">
-    <set var="sum" local="true">0</set>
-    <for var="i, num" in="ipairs(nums)">
-      <set var="sum">sum + num</set>
-      <if test="num % 2 == 0">
-        <print>{{num}} is even</print>
-      </if>
-    </for>
-    <return>sum</return>
-  </function>
-  
-  <set var="result" local="true">processNumbers(numbers)</set>
-  <print>Total sum: {{result}}</print>
-</script>`
+func TestMiddlewareAppliesToNestedAndCustomTags(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.Register("log", func(node Node, c *Compiler) (string, error) {
+		return c.getIndent() + "log()", nil
+	})
 
-	result, err := CompileString(xml)
-	if err != nil {
+	seen := map[string]int{}
+	compiler.Use(func(node Node, next Handler) Handler {
+		seen[node.XMLName.Local]++
+		return next
+	})
+
+	if _, err := compiler.CompileFromString(`<script><if test="x"><log/></if></script>`); err != nil {
 		t.Fatalf("Compilation failed: %v", err)
 	}
 
-	// Check that it contains expected elements
-	if !strings.Contains(result, "-- A complex example script") {
-		t.Error("Missing comment")
+	if seen["if"] != 1 || seen["log"] != 1 {
+		t.Errorf("Expected middleware to see if and log once each, got: %v", seen)
 	}
-	if !strings.Contains(result, "local numbers = {1, 2, 3, 4, 5}") {
-		t.Error("Missing numbers assignment")
+}
+
+func TestPlugin(t *testing.T) {
+	var p Plugin = PluginFunc(func(c *Compiler) {
+		c.Register("ping", func(node Node, compiler *Compiler) (string, error) {
+			return compiler.CurrentIndent() + "ping()", nil
+		})
+	})
+
+	compiler := NewCompiler()
+	p.Register(compiler)
+	if result, err := compiler.CompileFromString(`<ping/>`); err != nil || result != "ping()" {
+		t.Errorf("Expected plugin handler to compile, got %q (error: %v)", result, err)
 	}
-	if !strings.Contains(result, "local function processNumbers(nums)") {
-		t.Error("Missing function declaration")
+}
+
+func TestFallback(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.SetFallback(func(node Node, c *Compiler) (string, error) {
+		args := make([]string, len(node.Attrs))
+		for i, attr := range node.Attrs {
+			args[i] = attr.Value
+		}
+		return fmt.Sprintf("%s%s(%s)", c.CurrentIndent(), node.XMLName.Local, strings.Join(args, ", ")), nil
+	})
+
+	result, err := compiler.CompileFromString(`<script><foo a="1" b="x"/><if test="ok"><bar/></if><set var="y">2</set></script>`)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
 	}
-	if !strings.Contains(result, "for i, num in ipairs(nums) do") {
-		t.Error("Missing for loop")
+	expected := "foo(1, x)\nif ok then\n    bar()\nend\ny = 2"
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
 	}
-	if !strings.Contains(result, "print(\"Total sum: \" .. tostring(result) .. \"\")") {
-		t.Error("Missing interpolated print")
+
+	// Clones keep the fallback; clearing it restores the error
+	if result, err := compiler.Clone().CompileFromString(`<baz/>`); err != nil || result != "baz()" {
+		t.Errorf("Expected clone to use the fallback, got %q (error: %v)", result, err)
+	}
+	compiler.SetFallback(nil)
+	if _, err := compiler.CompileFromString(`<baz/>`); err == nil || !strings.Contains(err.Error(), "unknown tag: baz") {
+		t.Errorf("Expected unknown tag error, got: %v", err)
 	}
 }
 
-func TestCustomHandler(t *testing.T) {
+func TestHandlerSpec(t *testing.T) {
 	compiler := NewCompiler()
+	spec := HandlerSpec{Attributes: []string{"msg", "level"}, Required: []string{"msg"}}
+	compiler.RegisterWithSpec("log", spec, func(node Node, c *Compiler) (string, error) {
+		return fmt.Sprintf("log(%s)", GetAttr(node, "msg")), nil
+	})
 
-	// Register a custom log handler
+	if result, err := compiler.CompileFromString(`<log msg="x"/>`); err != nil || result != "log(x)" {
+		t.Errorf("Expected handler registered with a spec to compile, got %q (error: %v)", result, err)
+	}
+	if got, ok := compiler.Clone().Spec("log"); !ok || strings.Join(got.Required, ",") != "msg" || got.Body {
+		t.Errorf("Expected clone to keep the spec, got %+v (found: %v)", got, ok)
+	}
+
+	// Replacing the handler without a spec drops the stale one
 	compiler.Register("log", func(node Node, c *Compiler) (string, error) {
-		level := GetAttrWithDefault(node, "level", "info")
-		message := strings.TrimSpace(node.Content)
-		return fmt.Sprintf("%slogger.%s(%s)", c.getIndent(), level, WrapInQuotes(message)), nil
+		return "log()", nil
 	})
+	if _, ok := compiler.Spec("log"); ok {
+		t.Error("Expected Register to drop the previous spec")
+	}
+	if _, ok := compiler.Spec("missing"); ok {
+		t.Error("Expected no spec for an unknown tag")
+	}
 
-	xml := `<log level="debug">Application starting</log>`
-	expected := `logger.debug("Application starting")`
-
-	result, err := compiler.CompileFromString(xml)
-	if err != nil {
-		t.Fatalf("Compilation failed: %v", err)
+	if got, ok := compiler.Spec("while"); !ok || !got.Body || strings.Join(got.Required, ",") != "test" {
+		t.Errorf("Expected while spec with required test and a body, got %+v (found: %v)", got, ok)
 	}
 
-	if result != expected {
-		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	// Every built-in declares a spec whose required attributes it lists
+	for _, tag := range NewCompiler().Tags() {
+		got, ok := compiler.Spec(tag)
+		if !ok {
+			t.Errorf("Expected built-in %s to have a spec", tag)
+			continue
+		}
+		for _, attr := range got.Required {
+			if !slices.Contains(got.Attributes, attr) {
+				t.Errorf("Expected %s spec to list its required attribute %s", tag, attr)
+			}
+		}
 	}
 }
 
@@ -368,6 +3885,24 @@ func TestErrorHandling(t *testing.T) {
 			shouldError: true,
 			errorMsg:    "invalid variable name",
 		},
+		{
+			name:        "Local table field",
+			xml:         `<set var="t.field" local="true">1</set>`,
+			shouldError: true,
+			errorMsg:    "set command cannot declare table field t.field as local",
+		},
+		{
+			name:        "Local scope table field",
+			xml:         `<set var='t["k"]' scope="local">1</set>`,
+			shouldError: true,
+			errorMsg:    "cannot declare table field",
+		},
+		{
+			name:        "Invalid table field",
+			xml:         `<set var="t.">1</set>`,
+			shouldError: true,
+			errorMsg:    "invalid variable name",
+		},
 		{
 			name:        "Missing test attribute",
 			xml:         `<if><print>"test"</print></if>`,
@@ -400,6 +3935,116 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestRedeclarationWarning(t *testing.T) {
+	compiler := NewCompiler()
+
+	xml := `<script>
+  <set var="x" local="true">1</set>
+  <set var="x" local="true">2</set>
+</script>`
+
+	if _, err := compiler.CompileFromString(xml); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	warnings := compiler.Warnings()
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %d: %v", len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0].Message, "'x'") {
+		t.Errorf("Expected warning to name the variable, got: %s", warnings[0])
+	}
+}
+
+func TestShadowingInNestedScopeDoesNotWarn(t *testing.T) {
+	compiler := NewCompiler()
+
+	xml := `<script>
+  <set var="x" local="true">1</set>
+  <if test="x > 0">
+    <set var="x" local="true">2</set>
+  </if>
+  <function name="f" local="true">
+    <set var="x" local="true">3</set>
+  </function>
+  <set var="y" local="true">x</set>
+</script>`
+
+	if _, err := compiler.CompileFromString(xml); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if warnings := compiler.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got: %v", warnings)
+	}
+
+	// Warnings from a previous compilation must not leak into the next one
+	if _, err := compiler.CompileFromString(`<set var="x" local="true">1</set>`); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if warnings := compiler.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got: %v", warnings)
+	}
+}
+
+func TestUnescapedLessThanInAttribute(t *testing.T) {
+	xml := `<script>
+  <!-- don't trip on quotes in comments -->
+  <if test='n <= 1'>
+    <return>n</return>
+  </if>
+</script>`
+
+	expected := `if n <= 1 then
+    return n
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestSelfClosingTags(t *testing.T) {
+	// Empty elements decode to the same Node whichever way they are written,
+	// so every command must compile identically in both forms
+	testCases := []struct {
+		name     string
+		selfTag  string
+		fullTag  string
+		expected string
+	}{
+		{"Break", `<while test="true"><break/></while>`, `<while test="true"><break></break></while>`, "while true do\n    break\nend"},
+		{"Continue", `<while test="true"><continue/></while>`, "<while test=\"true\"><continue>\n  </continue></while>", "while true do\n    continue\nend"},
+		{"Return", `<function name="f"><return/></function>`, `<function name="f"><return> </return></function>`, "function f()\n    return\nend"},
+		{"Comment", `<comment/>`, `<comment></comment>`, ""},
+		{"Raw", `<raw/>`, `<raw></raw>`, ""},
+		{"Call without args", `<call name="f"/>`, `<call name="f"></call>`, "f()"},
+		{"Empty table", `<table var="t" local="true"/>`, `<table var="t" local="true"></table>`, "local t = {}"},
+		{"Empty function", `<function name="noop"/>`, `<function name="noop"></function>`, "function noop()\nend"},
+		{"Pragma", `<pragma mode="strict"/>`, `<pragma mode="strict"></pragma>`, "--!strict"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, xml := range []string{tc.selfTag, tc.fullTag} {
+				result, err := CompileString(xml)
+				if err != nil {
+					t.Fatalf("Compilation of %s failed: %v", xml, err)
+				}
+
+				if result != tc.expected {
+					t.Errorf("%s\nExpected:\n%s\nGot:\n%s", xml, tc.expected, result)
+				}
+			}
+		})
+	}
+}
+
 // Benchmark tests
 func BenchmarkSimpleCompilation(b *testing.B) {
 	xml := `<script>
@@ -0,0 +1,73 @@
+package lunaria_test
+
+import (
+	"fmt"
+	"strings"
+
+	"lunaria/lunaria"
+)
+
+// Prefix every compiled statement with a comment naming the tag it came from.
+func ExampleCompiler_Use() {
+	compiler := lunaria.NewCompiler()
+	compiler.Use(func(node lunaria.Node, next lunaria.Handler) lunaria.Handler {
+		return func(node lunaria.Node, c *lunaria.Compiler) (string, error) {
+			code, err := next(node, c)
+			if err != nil || code == "" {
+				return code, err
+			}
+			indent := code[:len(code)-len(strings.TrimLeft(code, " "))]
+			return fmt.Sprintf("%s-- trace: <%s>\n%s", indent, node.XMLName.Local, code), nil
+		}
+	})
+
+	result, err := compiler.CompileFromString(`<script>
+  <set var="x" local="true">1</set>
+  <print>x</print>
+</script>`)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(result)
+	// Output:
+	// -- trace: <set>
+	// local x = 1
+	// -- trace: <print>
+	// print(x)
+}
+
+// A custom block command that indents its body like the built-in blocks.
+func ExampleCompiler_CompileChildren() {
+	compiler := lunaria.NewCompiler()
+	compiler.Register("unless", func(node lunaria.Node, c *lunaria.Compiler) (string, error) {
+		header := fmt.Sprintf("%sif not (%s) then", c.CurrentIndent(), lunaria.GetAttr(node, "test"))
+
+		c.Indent()
+		body, err := c.CompileChildren(node)
+		c.Dedent()
+		if err != nil {
+			return "", err
+		}
+		return header + "\n" + body + "\n" + c.CurrentIndent() + "end", nil
+	})
+
+	result, err := compiler.CompileFromString(`<function name="check" params="ok">
+  <unless test="ok">
+    <warn>"not ok"</warn>
+    <return>false</return>
+  </unless>
+  <return>true</return>
+</function>`)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(result)
+	// Output:
+	// function check(ok)
+	//     if not (ok) then
+	//         warn("not ok")
+	//         return false
+	//     end
+	//     return true
+	// end
+}
@@ -0,0 +1,79 @@
+package lunaria
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CompileFromFile compiles the XML file at path using this compiler
+// instance. Unless BaseDir is set, includes resolve relative to the file.
+func (c *Compiler) CompileFromFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if c.options.BaseDir == "" {
+		c.options.BaseDir = filepath.Dir(path)
+		defer func() { c.options.BaseDir = "" }()
+	}
+
+	result, err := c.CompileFromReader(file)
+	if err != nil {
+		return "", fmt.Errorf("compiling %s: %w", path, err)
+	}
+	return result, nil
+}
+
+// CompileToFile compiles the XML file at inPath and writes the Luau output
+// to outPath, creating missing parent directories
+func (c *Compiler) CompileToFile(inPath, outPath string) error {
+	result, err := c.CompileFromFile(inPath)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteFile(outPath, result); err != nil {
+		return fmt.Errorf("writing %s: %w", outPath, err)
+	}
+	return nil
+}
+
+// CompileFile compiles an XML file to Luau code using the default compiler
+func CompileFile(path string) (string, error) {
+	return defaultCompiler.CompileFromFile(path)
+}
+
+// CompileToFile compiles an XML file and writes the result using the default compiler
+func CompileToFile(inPath, outPath string) error {
+	return defaultCompiler.CompileToFile(inPath, outPath)
+}
+
+// WriteFile writes content to filename, creating its directory if needed
+func WriteFile(filename, content string) error {
+	dir := filepath.Dir(filename)
+	if dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(filename, []byte(content), 0644)
+}
+
+// IsLunariaFile reports whether filename has the extension of a Lunaria
+// source file, .xml or .lunaria
+func IsLunariaFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".xml" || ext == ".lunaria"
+}
+
+// OutputFilename returns the .lua file beside the source file inputFile
+func OutputFilename(inputFile string) string {
+	ext := filepath.Ext(inputFile)
+	base := strings.TrimSuffix(inputFile, ext)
+	return base + ".lua"
+}
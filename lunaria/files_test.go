@@ -0,0 +1,87 @@
+package lunaria
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "script.xml")
+	if err := os.WriteFile(input, []byte(`<set var="x" local="true">42</set>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := CompileFile(input)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if expected := "local x = 42"; result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestCompileFileErrorsIncludePath(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing.xml")
+	if _, err := CompileFile(missing); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected error mentioning %s, got: %v", missing, err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.xml")
+	if err := os.WriteFile(invalid, []byte(`<unknown/>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompileFile(invalid); err == nil || !strings.Contains(err.Error(), invalid) {
+		t.Errorf("Expected error mentioning %s, got: %v", invalid, err)
+	}
+}
+
+func TestCompileToFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "script.xml")
+	output := filepath.Join(dir, "out", "nested", "script.lua")
+	if err := os.WriteFile(input, []byte(`<print>"hi"</print>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CompileToFile(input, output); err != nil {
+		t.Fatalf("CompileToFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Reading output failed: %v", err)
+	}
+	if expected := `print("hi")`; string(data) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, string(data))
+	}
+}
+
+func TestFileNames(t *testing.T) {
+	for name, expected := range map[string]bool{"a.xml": true, "b.LUNARIA": true, "dir.xml/c.lua": false, "notes.txt": false, "xml": false} {
+		if got := IsLunariaFile(name); got != expected {
+			t.Errorf("IsLunariaFile(%q) = %v, expected %v", name, got, expected)
+		}
+	}
+
+	for input, expected := range map[string]string{"a.xml": "a.lua", "src/b.lunaria": "src/b.lua", "noext": "noext.lua", "v1.2/c.xml": "v1.2/c.lua"} {
+		if got := OutputFilename(input); got != expected {
+			t.Errorf("OutputFilename(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestWriteFileCreatesDirectories(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a", "b", "out.lua")
+	if err := WriteFile(path, "x = 1"); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "x = 1" {
+		t.Errorf("Expected file content %q, got %q (error: %v)", "x = 1", data, err)
+	}
+}
@@ -0,0 +1,138 @@
+package lunaria
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// formatIndent is the indentation unit of formatted XML
+const formatIndent = "  "
+
+// verbatimTags hold code whose whitespace is significant, so Format keeps
+// their content exactly as written
+var verbatimTags = map[string]bool{"raw": true, "comment": true}
+
+// Parse decodes a Lunaria document into its root Node without compiling it
+func Parse(r io.Reader) (Node, error) {
+	root, _, err := parseDocument(r)
+	return root, err
+}
+
+// parseDocument decodes a document into its root Node and the processing
+// instructions, such as <?lunaria-version "1.0"?>, that precede it
+func parseDocument(r io.Reader) (Node, []xml.ProcInst, error) {
+	d := newDecoder(r)
+	var prolog []xml.ProcInst
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return Node{}, nil, fmt.Errorf("XML parse error: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.ProcInst:
+			prolog = append(prolog, t.Copy())
+		case xml.StartElement:
+			var root Node
+			if err := d.DecodeElement(&root, &t); err != nil {
+				return Node{}, nil, fmt.Errorf("XML parse error: %w", err)
+			}
+			return root, prolog, nil
+		}
+	}
+}
+
+// Format re-emits a Lunaria document with consistent two-space indentation
+// and attributes sorted by name. Text is trimmed, except in <raw> and
+// <comment>, which are kept verbatim (in a CDATA section when they contain
+// markup characters). XML comments are not part of the Node tree and are
+// dropped.
+func Format(r io.Reader, w io.Writer) error {
+	root, prolog, err := parseDocument(r)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, pi := range prolog {
+		fmt.Fprintf(&b, "<?%s %s?>\n", pi.Target, strings.TrimSpace(string(pi.Inst)))
+	}
+	formatNode(&b, root, 0)
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// FormatString formats the Lunaria document s, see Format
+func FormatString(s string) (string, error) {
+	var b strings.Builder
+	if err := Format(strings.NewReader(s), &b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// formatNode writes node and its children at the given depth
+func formatNode(b *strings.Builder, node Node, depth int) {
+	indent := strings.Repeat(formatIndent, depth)
+	name := node.XMLName.Local
+
+	b.WriteString(indent + "<" + name)
+	attrs := append([]xml.Attr(nil), node.Attrs...)
+	sort.SliceStable(attrs, func(i, j int) bool { return attrs[i].Name.Local < attrs[j].Name.Local })
+	for _, attr := range attrs {
+		fmt.Fprintf(b, ` %s="%s"`, attr.Name.Local, escapeAttr(attr.Value))
+	}
+
+	var text string
+	if verbatimTags[name] {
+		text = verbatimText(node.Content)
+	} else {
+		text = escapeText(strings.TrimSpace(node.Content))
+	}
+
+	switch {
+	case len(node.Nodes) == 0 && text == "":
+		b.WriteString("/>\n")
+		return
+	case len(node.Nodes) == 0:
+		b.WriteString(">" + text + "</" + name + ">\n")
+		return
+	}
+
+	b.WriteString(">\n")
+	if text != "" {
+		b.WriteString(indent + formatIndent + text + "\n")
+	}
+	for _, child := range node.Nodes {
+		formatNode(b, child, depth+1)
+	}
+	b.WriteString(indent + "</" + name + ">\n")
+}
+
+// escapeAttr escapes an attribute value for a double-quoted attribute. A
+// '<' is escaped too so formatted files stay well-formed XML for other
+// tools, even though Lunaria accepts it unescaped.
+func escapeAttr(s string) string {
+	return strings.NewReplacer(`&`, "&amp;", `<`, "&lt;", `"`, "&quot;", "\n", "&#xA;", "\t", "&#x9;").Replace(s)
+}
+
+// escapeText escapes character data
+func escapeText(s string) string {
+	return strings.NewReplacer(`&`, "&amp;", `<`, "&lt;").Replace(s)
+}
+
+// verbatimText returns s unchanged, wrapped in a CDATA section if it
+// contains characters that would otherwise need escaping
+func verbatimText(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+	if !strings.ContainsAny(s, "<&") {
+		return s
+	}
+	return "<![CDATA[" + strings.ReplaceAll(s, "]]>", "]]]]><![CDATA[>") + "]]>"
+}
@@ -0,0 +1,121 @@
+package lunaria
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatSource(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name:     "Indents children",
+			xml:      `<script><if test="x"><print>"yes"</print></if></script>`,
+			expected: "<script>\n  <if test=\"x\">\n    <print>\"yes\"</print>\n  </if>\n</script>\n",
+		},
+		{
+			name:     "Sorts attributes",
+			xml:      `<for var="i" to="10" from="1"><break/></for>`,
+			expected: "<for from=\"1\" to=\"10\" var=\"i\">\n  <break/>\n</for>\n",
+		},
+		{
+			name:     "Trims text",
+			xml:      "<script>\n\n    <set   var=\"x\">\n   42  </set></script>",
+			expected: "<script>\n  <set var=\"x\">42</set>\n</script>\n",
+		},
+		{
+			name:     "Text before children",
+			xml:      `<call name="f">a<arg>1</arg></call>`,
+			expected: "<call name=\"f\">\n  a\n  <arg>1</arg>\n</call>\n",
+		},
+		{
+			name:     "Escapes text and attributes",
+			xml:      `<if test="a &lt; b &amp;&amp; c ~= &quot;x&quot;"><print>a &lt; b</print></if>`,
+			expected: "<if test=\"a &lt; b &amp;&amp; c ~= &quot;x&quot;\">\n  <print>a &lt; b</print>\n</if>\n",
+		},
+		{
+			name:     "Escapes unescaped attribute characters",
+			xml:      `<if test="a < b"><print>1</print></if>`,
+			expected: "<if test=\"a &lt; b\">\n  <print>1</print>\n</if>\n",
+		},
+		{
+			name:     "Raw kept verbatim",
+			xml:      "<script><raw>\n  local x = 1\n    if x then end\n</raw></script>",
+			expected: "<script>\n  <raw>\n  local x = 1\n    if x then end\n</raw>\n</script>\n",
+		},
+		{
+			name:     "CDATA preserved",
+			xml:      "<raw><![CDATA[if a < b then end]]></raw>",
+			expected: "<raw><![CDATA[if a < b then end]]></raw>\n",
+		},
+		{
+			name:     "Processing instructions",
+			xml:      "<?xml version=\"1.0\"?>\n<?lunaria-version \"1.0\"?>\n<set var=\"x\">1</set>",
+			expected: "<?xml version=\"1.0\"?>\n<?lunaria-version \"1.0\"?>\n<set var=\"x\">1</set>\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := FormatString(tc.xml)
+			if err != nil {
+				t.Fatalf("Formatting failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+
+			again, err := FormatString(result)
+			if err != nil || again != result {
+				t.Errorf("Expected formatting to be idempotent, got:\n%s (error: %v)", again, err)
+			}
+		})
+	}
+}
+
+func TestFormatPreservesCompiledOutput(t *testing.T) {
+	source := `<script>
+<comment>Setup</comment>
+<set var="items" local="true">{1, 2, 3}</set>
+<for var="i, v" in="ipairs(items)"><if test="v &gt; 1 and v < 3"><print>{{i}}: {{v}} &amp; more</print><else><continue/></else></if></for>
+<raw><![CDATA[
+local s = "a<b"
+  print(s)
+]]></raw>
+</script>`
+
+	formatted, err := FormatString(source)
+	if err != nil {
+		t.Fatalf("Formatting failed: %v", err)
+	}
+
+	expected, err := CompileString(source)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	result, err := CompileString(formatted)
+	if err != nil {
+		t.Fatalf("Compiling formatted source failed: %v\n%s", err, formatted)
+	}
+	if result != expected {
+		t.Errorf("Formatted source compiles differently.\nExpected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestParse(t *testing.T) {
+	root, err := Parse(strings.NewReader(`<?lunaria-version "99"?><script><set var="x">1</set></script>`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if root.XMLName.Local != "script" || len(root.Nodes) != 1 || GetAttr(root.Nodes[0], "var") != "x" {
+		t.Errorf("Unexpected tree: %+v", root)
+	}
+
+	if _, err := Parse(strings.NewReader(`<script>`)); err == nil {
+		t.Error("Expected a parse error for an unclosed element")
+	}
+}
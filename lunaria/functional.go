@@ -0,0 +1,212 @@
+package lunaria
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// registerFunctionalCommands registers higher-order commands that build
+// new values from existing tables
+func (c *Compiler) registerFunctionalCommands() {
+	// <map> command - collects an expression over every element of a table
+	c.RegisterWithSpec("map", HandlerSpec{
+		Attributes: []string{"table", "element", "var", "local"},
+		Required:   []string{"table"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		source := GetAttr(node, "table")
+		element := GetAttrWithDefault(node, "element", "value")
+		expr := strings.TrimSpace(node.Content)
+
+		if source == "" {
+			return "", fmt.Errorf("map command requires 'table' attribute")
+		}
+		if !IsValidIdentifier(element) {
+			return "", fmt.Errorf("invalid variable name: %s", element)
+		}
+		if expr == "" {
+			return "", fmt.Errorf("map command requires a body expression")
+		}
+
+		code, err := compileCollector(node, compiler, source, element, "_r[#_r + 1] = "+expr+compiler.terminator())
+		if err != nil {
+			return "", err
+		}
+
+		return compileAssignment("map", node, compiler, code)
+	})
+
+	// <filter> command - collects the elements of a table that pass a test
+	c.RegisterWithSpec("filter", HandlerSpec{
+		Attributes: []string{"table", "element", "test", "var", "local"},
+		Required:   []string{"table"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		source := GetAttr(node, "table")
+		element := GetAttrWithDefault(node, "element", "value")
+		test := GetAttr(node, "test")
+
+		if source == "" {
+			return "", fmt.Errorf("filter command requires 'table' attribute")
+		}
+		if !IsValidIdentifier(element) {
+			return "", fmt.Errorf("invalid variable name: %s", element)
+		}
+
+		insert := "_r[#_r + 1] = " + element + compiler.terminator()
+		body := node
+		tail := ""
+		switch {
+		case test != "" && len(node.Nodes) > 0:
+			return "", fmt.Errorf("filter command cannot have both a 'test' attribute and child elements")
+		case test != "":
+			tail = fmt.Sprintf("if %s then %s end", test, insert)
+		default:
+			var err error
+			if body, err = insertIntoIfs(node, insert); err != nil {
+				return "", err
+			}
+		}
+
+		code, err := compileCollector(body, compiler, source, element, tail)
+		if err != nil {
+			return "", err
+		}
+
+		return compileAssignment("filter", node, compiler, code)
+	})
+
+	// <reduce> command - folds a table into a single accumulated value
+	c.RegisterWithSpec("reduce", HandlerSpec{
+		Attributes: []string{"table", "initial", "accumulator", "element", "var", "local"},
+		Required:   []string{"table", "initial"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		source := GetAttr(node, "table")
+		initial := GetAttr(node, "initial")
+		acc := GetAttrWithDefault(node, "accumulator", "acc")
+		element := GetAttrWithDefault(node, "element", "value")
+		expr := strings.TrimSpace(node.Content)
+
+		if source == "" {
+			return "", fmt.Errorf("reduce command requires 'table' attribute")
+		}
+		if initial == "" {
+			return "", fmt.Errorf("reduce command requires 'initial' attribute")
+		}
+		for _, name := range []string{acc, element} {
+			if !IsValidIdentifier(name) {
+				return "", fmt.Errorf("invalid variable name: %s", name)
+			}
+		}
+		if expr == "" && len(node.Nodes) == 0 {
+			return "", fmt.Errorf("reduce command requires a body updating '%s'", acc)
+		}
+
+		// Content is shorthand for assigning the next accumulator value
+		tail := ""
+		if expr != "" {
+			tail = acc + " = " + expr + compiler.terminator()
+		}
+
+		code, err := compileIIFE(compiler, func() (string, error) {
+			loop, err := compileElementLoop(node, compiler, source, element, tail)
+			if err != nil {
+				return "", err
+			}
+			indent, end := compiler.getIndent(), compiler.terminator()
+			return fmt.Sprintf("%slocal %s = %s%s\n%s\n%sreturn %s%s\n", indent, acc, initial, end, loop, indent, acc, end), nil
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return compileAssignment("reduce", node, compiler, code)
+	})
+}
+
+// insertIntoIfs returns a copy of node whose top-level <if> children end
+// their then branch with the insert statement, so elements passing any of
+// the tests are kept
+func insertIntoIfs(node Node, insert string) (Node, error) {
+	found := false
+	children := make([]Node, len(node.Nodes))
+	for i, child := range node.Nodes {
+		if child.XMLName.Local == "if" {
+			found = true
+			raw := Node{XMLName: xml.Name{Local: "raw"}, Content: insert}
+
+			// The then branch ends where the first elseif or else begins
+			end := len(child.Nodes)
+			for k, n := range child.Nodes {
+				if n.XMLName.Local == "elseif" || n.XMLName.Local == "else" {
+					end = k
+					break
+				}
+			}
+			nodes := append(append([]Node(nil), child.Nodes[:end]...), raw)
+			child.Nodes = append(nodes, child.Nodes[end:]...)
+		}
+		children[i] = child
+	}
+	if !found {
+		return Node{}, fmt.Errorf("%s command requires a 'test' attribute or an <if> child", node.XMLName.Local)
+	}
+
+	node.Nodes = children
+	return node, nil
+}
+
+// compileCollector emits an IIFE that loops over source, compiling node's
+// children and tail for each element, and returns the _r table they fill
+func compileCollector(node Node, compiler *Compiler, source, element, tail string) (string, error) {
+	return compileIIFE(compiler, func() (string, error) {
+		loop, err := compileElementLoop(node, compiler, source, element, tail)
+		if err != nil {
+			return "", err
+		}
+		indent, end := compiler.getIndent(), compiler.terminator()
+		return indent + "local _r = {}" + end + "\n" + loop + "\n" + indent + "return _r" + end + "\n", nil
+	})
+}
+
+// compileIIFE wraps the statements produced by body in an immediately
+// invoked function expression so they can be used as a single value.
+// body runs one indent level deeper and must end its output with a newline.
+func compileIIFE(compiler *Compiler, body func() (string, error)) (string, error) {
+	compiler.indent++
+	code, err := body()
+	compiler.indent--
+	if err != nil {
+		return "", err
+	}
+
+	return "(function()\n" + code + compiler.getIndent() + "end)()", nil
+}
+
+// compileElementLoop emits an ipairs loop over source binding element,
+// compiling node's children as the loop body followed by tail
+func compileElementLoop(node Node, compiler *Compiler, source, element, tail string) (string, error) {
+	result := fmt.Sprintf("%sfor _, %s in ipairs(%s) do\n", compiler.getIndent(), element, source)
+
+	compiler.indent++
+	compiler.loopDepth++
+	compiler.pushScope()
+	body, err := compiler.CompileChildren(node)
+	if err != nil {
+		return "", err
+	}
+	if body != "" {
+		result += body + "\n"
+	}
+	if tail != "" {
+		result += compiler.getIndent() + tail + "\n"
+	}
+	compiler.popScope()
+	compiler.loopDepth--
+	compiler.indent--
+
+	result += compiler.getIndent() + "end"
+	return result, nil
+}
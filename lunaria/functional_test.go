@@ -0,0 +1,271 @@
+package lunaria
+
+import "testing"
+
+func TestMap(t *testing.T) {
+	xml := `<map var="doubled" local="true" table="numbers" element="n">n * 2</map>`
+
+	expected := `local doubled = (function()
+    local _r = {}
+    for _, n in ipairs(numbers) do
+        _r[#_r + 1] = n * 2
+    end
+    return _r
+end)()`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestMapWithStatements(t *testing.T) {
+	xml := `<script>
+  <function name="clamp" params="scores">
+    <map var="clamped" local="true" table="scores" element="score">
+      <if test="score < 0">
+        <set var="score">0</set>
+      </if>
+      score
+    </map>
+    <return>clamped</return>
+  </function>
+</script>`
+
+	expected := `function clamp(scores)
+    local clamped = (function()
+        local _r = {}
+        for _, score in ipairs(scores) do
+            if score < 0 then
+                score = 0
+            end
+            _r[#_r + 1] = score
+        end
+        return _r
+    end)()
+    return clamped
+end`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestMapErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Missing table", `<map var="r">value</map>`},
+		{"Missing body", `<map var="r" table="items"></map>`},
+		{"Invalid element", `<map var="r" table="items" element="1x">x</map>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Test attribute",
+			xml:  `<filter var="adults" local="true" table="people" element="p" test="p.age >= 18"/>`,
+			expected: `local adults = (function()
+    local _r = {}
+    for _, p in ipairs(people) do
+        if p.age >= 18 then _r[#_r + 1] = p end
+    end
+    return _r
+end)()`,
+		},
+		{
+			name: "If children",
+			xml: `<filter var="picked" table="items" element="item">
+  <if test="item.rare">
+    <print>found {{item.name}}</print>
+  </if>
+  <if test="item.price < 5"></if>
+</filter>`,
+			expected: `picked = (function()
+    local _r = {}
+    for _, item in ipairs(items) do
+        if item.rare then
+            print("found " .. tostring(item.name) .. "")
+            _r[#_r + 1] = item
+        end
+        if item.price < 5 then
+            _r[#_r + 1] = item
+        end
+    end
+    return _r
+end)()`,
+		},
+		{
+			name: "If with else branch",
+			xml: `<filter var="kept" table="items">
+  <if test="value.ok">
+    <else><warn>"dropped"</warn></else>
+  </if>
+</filter>`,
+			expected: `kept = (function()
+    local _r = {}
+    for _, value in ipairs(items) do
+        if value.ok then
+            _r[#_r + 1] = value
+        else
+            warn("dropped")
+        end
+    end
+    return _r
+end)()`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestFilterEmptyResult(t *testing.T) {
+	// Nothing passing the test is not an error; the result is simply {}
+	xml := `<filter var="none" local="true" table="{}" test="false"/>`
+
+	expected := `local none = (function()
+    local _r = {}
+    for _, value in ipairs({}) do
+        if false then _r[#_r + 1] = value end
+    end
+    return _r
+end)()`
+
+	result, err := CompileString(xml)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Missing table", `<filter var="r" test="x"/>`},
+		{"Missing test", `<filter var="r" table="items"><print>hi</print></filter>`},
+		{"Both forms", `<filter var="r" table="items" test="x"><if test="y"></if></filter>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+}
+
+func TestReduce(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Sum",
+			xml: `<reduce var="total" local="true" table="numbers" initial="0" accumulator="sum" element="n">
+  <set var="sum">sum + n</set>
+</reduce>`,
+			expected: `local total = (function()
+    local sum = 0
+    for _, n in ipairs(numbers) do
+        sum = sum + n
+    end
+    return sum
+end)()`,
+		},
+		{
+			name:     "String concatenation shorthand",
+			xml:      `<reduce var="csv" table="names" initial='""' element="name">acc .. name .. ","</reduce>`,
+			expected: "csv = (function()\n    local acc = \"\"\n    for _, name in ipairs(names) do\n        acc = acc .. name .. \",\"\n    end\n    return acc\nend)()",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestReduceErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Missing table", `<reduce var="r" initial="0">acc + value</reduce>`},
+		{"Missing initial", `<reduce var="r" table="items">acc + value</reduce>`},
+		{"Missing body", `<reduce var="r" table="items" initial="0"></reduce>`},
+		{"Invalid accumulator", `<reduce var="r" table="items" initial="0" accumulator="a-b">a</reduce>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+}
+
+func BenchmarkFunctionalCompilation(b *testing.B) {
+	xml := `<script>
+  <map var="doubled" local="true" table="numbers" element="n">n * 2</map>
+  <filter var="even" local="true" table="doubled" element="n" test="n % 4 == 0"/>
+  <reduce var="total" local="true" table="even" initial="0" element="n">acc + n</reduce>
+</script>`
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := CompileString(xml)
+		if err != nil {
+			b.Fatalf("Compilation failed: %v", err)
+		}
+	}
+}
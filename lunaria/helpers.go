@@ -2,9 +2,11 @@
 package lunaria
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // GetAttr retrieves an attribute value by name from a Node
@@ -41,13 +43,125 @@ func GetBoolAttr(node Node, name string) bool {
 	return value == "true" || value == "1" || value == "yes"
 }
 
-// Interpolate replaces {{var}} patterns with Luau string concatenation
+// placeholders finds the interpolation placeholders of one pair of
+// delimiters: open expr close, and the raw form open{expr}close, which for
+// the default delimiters is {{{expr}}}
+type placeholders struct {
+	Delimiters
+	pattern *regexp.Regexp
+}
+
+// defaultPlaceholders finds {{expr}} and {{{expr}}} placeholders
+var defaultPlaceholders = newPlaceholders(DefaultDelimiters)
+
+// newPlaceholders builds the pattern for d, matching raw placeholders in
+// group 1 and others in group 2. Expressions cannot contain the first
+// character of either delimiter, so that with the default delimiters a
+// triple brace is never mistaken for a double one.
+func newPlaceholders(d Delimiters) *placeholders {
+	first, _ := utf8.DecodeRuneInString(d.Open)
+	last, _ := utf8.DecodeRuneInString(d.Close)
+	expr := fmt.Sprintf(`([^\x{%x}\x{%x}]+)`, first, last)
+	open, close := regexp.QuoteMeta(d.Open), regexp.QuoteMeta(d.Close)
+	return &placeholders{
+		Delimiters: d,
+		pattern:    regexp.MustCompile(open + `\{` + expr + `\}` + close + `|` + open + expr + close),
+	}
+}
+
+// in reports whether text may hold a placeholder
+func (p *placeholders) in(text string) bool {
+	return strings.Contains(text, p.Open)
+}
+
+// placeholderExpr returns the expression of a placeholders match and
+// whether it used the raw form
+func placeholderExpr(text string, m []int) (string, bool) {
+	if m[2] >= 0 {
+		return strings.TrimSpace(text[m[2]:m[3]]), true
+	}
+	return strings.TrimSpace(text[m[4]:m[5]]), false
+}
+
+// Interpolate replaces {{var}} patterns with Luau string concatenation.
+// {{{expr}}} splices in an expression that is already a string without
+// wrapping it in tostring, as does {{expr}} when expr is a string literal
+// or a tostring or string.format call. The text around placeholders is
+// escaped, so the result is safe to wrap in double quotes even when it
+// contains quotes or newlines.
 func Interpolate(text string) string {
-	re := regexp.MustCompile(`\{\{([^}]+)\}\}`)
-	return re.ReplaceAllStringFunc(text, func(match string) string {
-		varName := strings.TrimSpace(match[2 : len(match)-2])
-		return `" .. tostring(` + varName + `) .. "`
-	})
+	return defaultPlaceholders.interpolate(text)
+}
+
+// interpolate is Interpolate for the delimiters of p
+func (p *placeholders) interpolate(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range p.pattern.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(EscapeString(text[last:m[0]]))
+		if expr, raw := placeholderExpr(text, m); raw {
+			b.WriteString(`" .. (` + expr + `) .. "`)
+		} else if isStringExpression(expr) {
+			b.WriteString(`" .. ` + expr + ` .. "`)
+		} else {
+			b.WriteString(`" .. tostring(` + expr + `) .. "`)
+		}
+		last = m[1]
+	}
+	b.WriteString(EscapeString(text[last:]))
+	return b.String()
+}
+
+// isStringExpression reports whether expr is a single string literal or a
+// tostring or string.format call, which need no tostring to concatenate
+func isStringExpression(expr string) bool {
+	if open := finalCallParen(expr); open > 0 {
+		head := strings.TrimSpace(expr[:open])
+		return head == "tostring" || head == "string.format"
+	}
+
+	switch {
+	case expr == "":
+		return false
+	case expr[0] == '[':
+		return longBracketEnd(expr) == len(expr)
+	case expr[0] != '"' && expr[0] != '\'':
+		return false
+	}
+	for i := 1; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			i++
+		case expr[0]:
+			return i == len(expr)-1
+		}
+	}
+	return false
+}
+
+// backtickEscaper escapes the literal parts of a Luau interpolated string
+var backtickEscaper = strings.NewReplacer("\\", "\\\\", "`", "\\`", "{", "\\{", "\n", "\\n", "\t", "\\t")
+
+// InterpolateBackticks converts {{expr}} and {{{expr}}} placeholders to
+// the {expr} form of Luau's backtick strings, escaping backslashes,
+// backticks and braces in the surrounding text. The result does not
+// include the backticks.
+func InterpolateBackticks(text string) string {
+	return defaultPlaceholders.interpolateBackticks(text)
+}
+
+// interpolateBackticks is InterpolateBackticks for the delimiters of p
+func (p *placeholders) interpolateBackticks(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range p.pattern.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(backtickEscaper.Replace(text[last:m[0]]))
+		expr, _ := placeholderExpr(text, m)
+		b.WriteString("{" + expr + "}")
+		last = m[1]
+	}
+	b.WriteString(backtickEscaper.Replace(text[last:]))
+	return b.String()
 }
 
 // ParseNumber safely converts a string to a number, defaulting to 0
@@ -76,6 +190,66 @@ func EscapeString(s string) string {
 	return s
 }
 
+// EscapeLiterals escapes newlines, tabs and carriage returns inside the
+// quoted string literals of the Luau expression expr, leaving the rest of
+// it and any escapes already present untouched. Content decoded from
+// entities such as &#10; can then be used in an expression without
+// breaking a literal across lines. Long bracket strings may span lines and
+// are copied as they are.
+func EscapeLiterals(expr string) string {
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0 && c == '\\' && i+1 < len(expr):
+			b.WriteByte(c)
+			i++
+			c = expr[i]
+		case quote != 0 && c == '\n':
+			b.WriteString("\\n")
+			continue
+		case quote != 0 && c == '\t':
+			b.WriteString("\\t")
+			continue
+		case quote != 0 && c == '\r':
+			b.WriteString("\\r")
+			continue
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '[':
+			if end := longBracketEnd(expr[i:]); end != -1 {
+				b.WriteString(expr[i : i+end])
+				i += end - 1
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// longBracketEnd returns the length of the long bracket string [==[...]==]
+// at the start of s, or -1 if s does not start with a complete one
+func longBracketEnd(s string) int {
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level >= len(s) || s[level] != '[' {
+		return -1
+	}
+
+	closing := "]" + strings.Repeat("=", level-1) + "]"
+	end := strings.Index(s[level+1:], closing)
+	if end == -1 {
+		return -1
+	}
+	return level + 1 + end + len(closing)
+}
+
 // IsValidIdentifier checks if a string is a valid Luau identifier
 func IsValidIdentifier(s string) bool {
 	if s == "" {
@@ -112,6 +286,88 @@ func IsValidIdentifier(s string) bool {
 	return true
 }
 
+// isFieldPath reports whether s is an identifier followed by any number of
+// field accesses, such as player.Character.Name, with no indexing or calls
+func isFieldPath(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if !IsValidIdentifier(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidLValue checks if a string is a valid assignment target: an
+// identifier optionally followed by field accesses (a.b) and bracket
+// indexing (a[1], a["key"]). Method syntax (a:b) is not an lvalue.
+func IsValidLValue(s string) bool {
+	end := strings.IndexAny(s, ".[")
+	if end == -1 {
+		return IsValidIdentifier(s)
+	}
+	if !IsValidIdentifier(s[:end]) {
+		return false
+	}
+
+	rest := s[end:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			next := strings.IndexAny(rest, ".[")
+			if next == -1 {
+				next = len(rest)
+			}
+			if !IsValidIdentifier(rest[:next]) {
+				return false
+			}
+			rest = rest[next:]
+		case '[':
+			closing := matchingBracket(rest)
+			if closing == -1 || strings.TrimSpace(rest[1:closing]) == "" {
+				return false
+			}
+			rest = rest[closing+1:]
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchingBracket returns the index of the ']' closing the '[' at s[0],
+// skipping brackets nested inside the index expression or string literals
+func matchingBracket(s string) int {
+	var inString bool
+	var stringChar byte
+	depth := 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == stringChar {
+				inString = false
+			}
+		case c == '"' || c == '\'':
+			inString = true
+			stringChar = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
 // SplitParameters splits a parameter string into individual parameters
 func SplitParameters(params string) []string {
 	if params == "" {
@@ -188,6 +444,43 @@ func IndentLines(text string, indent string) string {
 	return strings.Join(result, "\n")
 }
 
+// DedentLines drops leading and trailing blank lines from text and removes
+// the whitespace prefix shared by every remaining non-blank line, keeping
+// the lines' indentation relative to each other
+func DedentLines(text string) string {
+	lines := strings.Split(text, "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	prefix, found := "", false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if !found {
+			prefix, found = indent, true
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = strings.TrimPrefix(line, prefix)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 // FormatComment formats a string as a Luau comment
 func FormatComment(text string) string {
 	if text == "" {
@@ -209,6 +502,34 @@ func FormatComment(text string) string {
 	return strings.Join(result, "\n")
 }
 
+// longestBracketLevel returns the lowest level of long bracket, the number
+// of '=' between the brackets, whose closing delimiter ]=...=] does not
+// appear in s, so that s can be wrapped in --[==[ ... ]==]
+func longestBracketLevel(s string) int {
+	used := map[int]bool{}
+	for i := strings.IndexByte(s, ']'); i != -1; {
+		n := 0
+		for i+1+n < len(s) && s[i+1+n] == '=' {
+			n++
+		}
+		if i+1+n < len(s) && s[i+1+n] == ']' {
+			used[n] = true
+		}
+
+		next := strings.IndexByte(s[i+1:], ']')
+		if next == -1 {
+			break
+		}
+		i += 1 + next
+	}
+
+	level := 0
+	for used[level] {
+		level++
+	}
+	return level
+}
+
 // GenerateVariableName generates a unique variable name with a prefix
 func GenerateVariableName(prefix string, counter int) string {
 	if prefix == "" {
@@ -0,0 +1,192 @@
+package lunaria
+
+import "testing"
+
+func TestIsValidIdentifier(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected bool
+	}{
+		{"x", true},
+		{"_private", true},
+		{"camelCase2", true},
+		{"", false},
+		{"1abc", false},
+		{"local", false},
+		{"a.b", false},
+		{"a.b.c", false},
+		{"a:b", false},
+		{"a[1]", false},
+		{"a]", false},
+		{"a b", false},
+	}
+
+	for _, tc := range testCases {
+		if got := IsValidIdentifier(tc.input); got != tc.expected {
+			t.Errorf("IsValidIdentifier(%q) = %v, expected %v", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestIsValidLValue(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected bool
+	}{
+		{"x", true},
+		{"a.b", true},
+		{"a.b.c", true},
+		{"a[1]", true},
+		{`a["key"]`, true},
+		{`a["]"]`, true},
+		{"a[b[1]].c", true},
+		{"cache[key]", true},
+		{"", false},
+		{"a:b", false},
+		{"a.", false},
+		{".a", false},
+		{"a..b", false},
+		{"a[]", false},
+		{"a[1", false},
+		{"a.1", false},
+		{"a.end", false},
+		{"a b", false},
+		{"f()", false},
+		{"1[2]", false},
+	}
+
+	for _, tc := range testCases {
+		if got := IsValidLValue(tc.input); got != tc.expected {
+			t.Errorf("IsValidLValue(%q) = %v, expected %v", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"{{x}}", `" .. tostring(x) .. "`},
+		{"a {{ b.c }} d", `a " .. tostring(b.c) .. " d`},
+		{`"{{x}}"`, `\"" .. tostring(x) .. "\"`},
+		{"one\n{{x}}", `one\n" .. tostring(x) .. "`},
+		{`back\slash {{x}}`, `back\\slash " .. tostring(x) .. "`},
+		{"{{{name}}}", `" .. (name) .. "`},
+		{"a {{{ s }}} {{n}}", `a " .. (s) .. " " .. tostring(n) .. "`},
+		{"{{{a}}}{{b}}", `" .. (a) .. "" .. tostring(b) .. "`},
+		{"{{{x}}", `{" .. tostring(x) .. "`},
+		{"{{x}}}", `" .. tostring(x) .. "}`},
+		{`a {{ 'b' }}`, `a " .. 'b' .. "`},
+		{`{{"q\"s"}}`, `" .. "q\"s" .. "`},
+		{"{{[[long]]}}", `" .. [[long]] .. "`},
+		{"{{tostring(n)}}", `" .. tostring(n) .. "`},
+		{`{{string.format("%d", n)}}`, `" .. string.format("%d", n) .. "`},
+		{`{{"a" == "b"}}`, `" .. tostring("a" == "b") .. "`},
+		{"{{tostring(a) .. tostring(b)}}", `" .. tostring(tostring(a) .. tostring(b)) .. "`},
+		{"{{tostring(a)[1]}}", `" .. tostring(tostring(a)[1]) .. "`},
+		{"{{f(tostring(a))}}", `" .. tostring(f(tostring(a))) .. "`},
+	}
+
+	for _, tc := range testCases {
+		if got := Interpolate(tc.input); got != tc.expected {
+			t.Errorf("Interpolate(%q) = %q, expected %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestDedentLines(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"  \n\t\n", ""},
+		{"x = 1", "x = 1"},
+		{"\n    a\n      b\n    c\n  ", "a\n  b\nc"},
+		{"\n\t\ta\n\n\t\t\tb\n", "a\n\n\tb"},
+		{"    a\n  b", "  a\nb"},
+		{"a\n    b", "a\n    b"},
+		{"\t a\n\t  b", "a\n b"},
+	}
+
+	for _, tc := range testCases {
+		if got := DedentLines(tc.input); got != tc.expected {
+			t.Errorf("DedentLines(%q) = %q, expected %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestInterpolateBackticks(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"{{ x }}", "{x}"},
+		{"a {{b.c}} d", "a {b.c} d"},
+		{"{set} {{x}}", `\{set} {x}`},
+		{"{{{raw}}} {{x}}", "{raw} {x}"},
+		{"`tick`", "\\`tick\\`"},
+		{`"quotes" stay`, `"quotes" stay`},
+		{"one\ntwo", `one\ntwo`},
+		{`back\slash`, `back\\slash`},
+	}
+
+	for _, tc := range testCases {
+		if got := InterpolateBackticks(tc.input); got != tc.expected {
+			t.Errorf("InterpolateBackticks(%q) = %q, expected %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestLongestBracketLevel(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int
+	}{
+		{"plain text", 0},
+		{"a[1] and ]", 0},
+		{"t[a[1]]", 1},
+		{"]]", 1},
+		{"]=]", 0},
+		{"]] and ]=]", 2},
+		{"]==]", 0},
+		{"]] ]=] ]==]", 3},
+		{"]] ]==]", 1},
+		{"]=", 0},
+		{"]]]", 1},
+	}
+
+	for _, tc := range testCases {
+		if got := longestBracketLevel(tc.input); got != tc.expected {
+			t.Errorf("longestBracketLevel(%q) = %d, expected %d", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestEscapeLiterals(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"x", "x"},
+		{"\"a\nb\"", `"a\nb"`},
+		{"'a\tb'", `'a\tb'`},
+		{"\"a\r\nb\"", `"a\r\nb"`},
+		{`"already\nescaped"`, `"already\nescaped"`},
+		{`"quote \" inside` + "\n" + `"`, `"quote \" inside\n"`},
+		{"f(\"a\nb\",\n  c)", "f(\"a\\nb\",\n  c)"},
+		{`"it's"` + "\t", `"it's"` + "\t"},
+		{"[[long\nstring]] .. \"x\ty\"", "[[long\nstring]] .. \"x\\ty\""},
+		{"[==[it's ]] \n]==]", "[==[it's ]] \n]==]"},
+		{"t[\"k\ney\"]", `t["k\ney"]`},
+	}
+
+	for _, tc := range testCases {
+		if got := EscapeLiterals(tc.input); got != tc.expected {
+			t.Errorf("EscapeLiterals(%q) = %q, expected %q", tc.input, got, tc.expected)
+		}
+	}
+}
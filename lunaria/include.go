@@ -0,0 +1,83 @@
+package lunaria
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// registerIncludeCommands registers the <include> command
+func (c *Compiler) registerIncludeCommands() {
+	// <include> command - compiles another XML file inline
+	c.RegisterWithSpec("include", HandlerSpec{
+		Attributes: []string{"src"},
+		Required:   []string{"src"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		src := GetAttr(node, "src")
+		if src == "" {
+			return "", fmt.Errorf("include command requires 'src' attribute")
+		}
+		return compiler.compileInclude(src)
+	})
+}
+
+// includeDir returns the directory relative include paths resolve against:
+// that of the file being included, or BaseDir at the top level
+func (c *Compiler) includeDir() string {
+	if len(c.includes) > 0 {
+		return filepath.Dir(c.includes[len(c.includes)-1])
+	}
+	return c.options.BaseDir
+}
+
+// compileInclude compiles the file at src, relative to includeDir, at the
+// current indentation. Errors are prefixed with the included file's path.
+func (c *Compiler) compileInclude(src string) (string, error) {
+	path := src
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.includeDir(), path)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("include %s: %w", src, err)
+	}
+
+	for i, included := range c.includes {
+		if included == path {
+			chain := append(append([]string(nil), c.includes[i:]...), path)
+			for k := range chain {
+				chain[k] = filepath.Base(chain[k])
+			}
+			return "", &CompileError{Tag: "include", Message: "include cycle: " + strings.Join(chain, " -> ")}
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("include %s: %w", src, err)
+	}
+	root, err := c.parse(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	// The output depends on files outside the document, so it must not be
+	// cached by the document's content alone
+	c.uncacheable = true
+
+	c.includes = append(c.includes, path)
+	defer func() { c.includes = c.includes[:len(c.includes)-1] }()
+
+	nodes := []Node{root}
+	if isScriptRoot(root.XMLName.Local) {
+		nodes = root.Nodes
+	}
+
+	result, err := c.compileStatements(nodes)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,138 @@
+package lunaria
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files, keyed by slash-separated relative path, in a
+// temporary directory and returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"common.xml":     `<script><set var="a" local="true">1</set><print>a</print></script>`,
+		"single.xml":     `<print>"single"</print>`,
+		"lib/outer.xml":  `<script><include src="inner.xml"/><set var="outer">true</set></script>`,
+		"lib/inner.xml":  `<set var="inner">true</set>`,
+		"lib/twice.xml":  `<script><include src="inner.xml"/><include src="inner.xml"/></script>`,
+		"lib/parent.xml": `<include src="../single.xml"/>`,
+	})
+
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Script fragment", `<script><include src="common.xml"/><print>"done"</print></script>`, "local a = 1\nprint(a)\nprint(\"done\")"},
+		{"Single command", `<include src="single.xml"/>`, `print("single")`},
+		{"Indented", `<if test="x"><include src="common.xml"/></if>`, "if x then\n    local a = 1\n    print(a)\nend"},
+		{"Nested relative to includer", `<include src="lib/outer.xml"/>`, "inner = true\nouter = true"},
+		{"Same file twice", `<include src="lib/twice.xml"/>`, "inner = true\ninner = true"},
+		{"Parent directory", `<include src="lib/parent.xml"/>`, `print("single")`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{BaseDir: dir})
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.xml":      `<include src="b.xml"/>`,
+		"b.xml":      `<script><print>1</print><include src="a.xml"/></script>`,
+		"self.xml":   `<include src="self.xml"/>`,
+		"bad.xml":    `<script><bogus/></script>`,
+		"broken.xml": `<script>`,
+	})
+
+	testCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Missing src", `<include/>`, "include command requires 'src' attribute"},
+		{"Missing file", `<include src="missing.xml"/>`, "include missing.xml"},
+		{"Cycle", `<include src="a.xml"/>`, "include cycle: a.xml -> b.xml -> a.xml"},
+		{"Self include", `<include src="self.xml"/>`, "include cycle: self.xml -> self.xml"},
+		{"Error names included file", `<include src="bad.xml"/>`, filepath.Join(dir, "bad.xml") + ": unknown tag: bogus"},
+		{"Parse error names included file", `<include src="broken.xml"/>`, filepath.Join(dir, "broken.xml") + ": XML parse error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{BaseDir: dir})
+			_, err := compiler.CompileFromString(tc.xml)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestIncludeFromFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/main.xml":   `<script><include src="util.xml"/></script>`,
+		"src/util.xml":   `<set var="x" local="true">1</set>`,
+		"other/util.xml": `<set var="y" local="true">2</set>`,
+	})
+
+	compiler := NewCompiler()
+	result, err := compiler.CompileFromFile(filepath.Join(dir, "src", "main.xml"))
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if expected := "local x = 1"; result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+	if compiler.Options().BaseDir != "" {
+		t.Errorf("Expected BaseDir to be restored, got %q", compiler.Options().BaseDir)
+	}
+
+	// An explicit BaseDir takes precedence over the file's directory
+	compiler = NewCompilerWithOptions(CompileOptions{BaseDir: filepath.Join(dir, "other")})
+	if result, err := compiler.CompileFromFile(filepath.Join(dir, "src", "main.xml")); err != nil || result != "local y = 2" {
+		t.Errorf("Expected include from BaseDir, got %q (error: %v)", result, err)
+	}
+}
+
+func TestIncludeIsNotCached(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"part.xml": `<set var="x">1</set>`})
+
+	compiler := NewCompilerWithOptions(CompileOptions{BaseDir: dir}).WithCache(NewMemoryCache())
+	if _, err := compiler.CompileFromString(`<include src="part.xml"/>`); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "part.xml"), []byte(`<set var="x">2</set>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := compiler.CompileFromString(`<include src="part.xml"/>`); err != nil || result != "x = 2" {
+		t.Errorf("Expected the changed include to be recompiled, got %q (error: %v)", result, err)
+	}
+}
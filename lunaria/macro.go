@@ -0,0 +1,136 @@
+package lunaria
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// macro is a reusable XML snippet declared with <define>
+type macro struct {
+	params []string
+	body   []Node
+}
+
+// registerMacroCommands registers the <define> and <use> commands
+func (c *Compiler) registerMacroCommands() {
+	// <define> command - stores its children as a macro; emits nothing
+	c.RegisterWithSpec("define", HandlerSpec{
+		Attributes: []string{"name", "params"},
+		Required:   []string{"name"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		name := GetAttr(node, "name")
+		if name == "" {
+			return "", fmt.Errorf("define command requires 'name' attribute")
+		}
+		if !IsValidIdentifier(name) {
+			return "", fmt.Errorf("invalid macro name: %s", name)
+		}
+
+		params := SplitParameters(GetAttr(node, "params"))
+		for _, param := range params {
+			if !IsValidIdentifier(param) || param == "macro" {
+				return "", fmt.Errorf("invalid macro parameter: %s", param)
+			}
+		}
+
+		if _, exists := compiler.macros[name]; exists {
+			compiler.warn("define", "macro '%s' is redefined", name)
+		}
+		compiler.macros[name] = macro{params: params, body: node.Nodes}
+		return "", nil
+	})
+
+	// <use> command - expands a macro, passing its parameters as attributes
+	c.RegisterWithSpec("use", HandlerSpec{
+		Attributes: []string{"macro"},
+		Required:   []string{"macro"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		name := GetAttr(node, "macro")
+		if name == "" {
+			return "", fmt.Errorf("use command requires 'macro' attribute")
+		}
+		return compiler.expandMacro(name, node)
+	})
+}
+
+// expandMacro compiles the body of macro name at the current indentation,
+// with each {{param}} replaced by the value of the param attribute on node
+func (c *Compiler) expandMacro(name string, node Node) (string, error) {
+	m, exists := c.macros[name]
+	if !exists {
+		return "", &CompileError{Tag: "use", Message: "undefined macro: " + name}
+	}
+
+	values := make(map[string]string, len(m.params))
+	for _, param := range m.params {
+		if !HasAttr(node, param) {
+			return "", &CompileError{Tag: "use", Message: fmt.Sprintf("macro %s requires parameter '%s'", name, param)}
+		}
+		values[param] = GetAttr(node, param)
+	}
+	for _, attr := range node.Attrs {
+		if _, ok := values[attr.Name.Local]; !ok && attr.Name.Local != "macro" {
+			return "", &CompileError{Tag: "use", Message: fmt.Sprintf("macro %s has no parameter '%s'", name, attr.Name.Local)}
+		}
+	}
+
+	for _, expanding := range c.expanding {
+		if expanding == name {
+			return "", &CompileError{Tag: "use", Message: fmt.Sprintf("macro %s expands itself", name)}
+		}
+	}
+	c.expanding = append(c.expanding, name)
+	defer func() { c.expanding = c.expanding[:len(c.expanding)-1] }()
+
+	body := make([]Node, len(m.body))
+	for i, child := range m.body {
+		body[i] = substituteParams(child, values, c.interpolation())
+	}
+	result, err := c.compileStatements(body)
+	if err != nil {
+		return "", fmt.Errorf("macro %s: %w", name, err)
+	}
+	return result, nil
+}
+
+// substituteParams returns a copy of node with parameter placeholders in
+// its attributes and content, and those of its descendants, filled in from
+// values. Attributes, and content that is a single placeholder, take the
+// value as written. A placeholder inside other text keeps interpolating,
+// with the value as its expression, so <print>Hi {{who}}</print> prints
+// the value of who. Placeholders for other names are left as they are.
+func substituteParams(node Node, values map[string]string, p *placeholders) Node {
+	replace := func(text string, interpolate bool) string {
+		return p.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			param, raw := placeholderExpr(match, p.pattern.FindStringSubmatchIndex(match))
+			value, ok := values[param]
+			switch {
+			case !ok:
+				return match
+			case interpolate && strings.TrimSpace(text) != match && raw:
+				return p.Open + "{" + value + "}" + p.Close
+			case interpolate && strings.TrimSpace(text) != match:
+				return p.Open + value + p.Close
+			default:
+				return value
+			}
+		})
+	}
+
+	node.Content = replace(node.Content, true)
+	attrs := make([]xml.Attr, len(node.Attrs))
+	for i, attr := range node.Attrs {
+		attr.Value = replace(attr.Value, false)
+		attrs[i] = attr
+	}
+	node.Attrs = attrs
+
+	children := make([]Node, len(node.Nodes))
+	for i, child := range node.Nodes {
+		children[i] = substituteParams(child, values, p)
+	}
+	node.Nodes = children
+	return node
+}
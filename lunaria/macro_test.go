@@ -0,0 +1,109 @@
+package lunaria
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMacros(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name: "Define and use",
+			xml: `<script>
+  <define name="logDebug" params="msg"><warn>[DEBUG] {{msg}}</warn></define>
+  <use macro="logDebug" msg="'hi'"/>
+</script>`,
+			expected: `warn("[DEBUG] " .. 'hi' .. "")`,
+		},
+		{
+			name: "Attributes and other placeholders",
+			xml: `<script>
+  <define name="counter" params="name, start">
+    <set var="{{name}}" local="true">{{start}}</set>
+    <print>{{name}} = {{count}}</print>
+  </define>
+  <use macro="counter" name="hits" start="0"/>
+  <use macro="counter" name="misses" start="10"/>
+</script>`,
+			expected: "local hits = 0\nprint(\"\" .. tostring(hits) .. \" = \" .. tostring(count) .. \"\")\nlocal misses = 10\nprint(\"\" .. tostring(misses) .. \" = \" .. tostring(count) .. \"\")",
+		},
+		{
+			name:     "Indented inside a block",
+			xml:      `<script><define name="stop"><break/></define><while test="true"><use macro="stop"/></while></script>`,
+			expected: "while true do\n    break\nend",
+		},
+		{
+			name:     "Nested macros",
+			xml:      `<script><define name="inner" params="v"><print>{{v}}</print></define><define name="outer" params="v"><use macro="inner" v="{{v}}"/><use macro="inner" v="'again'"/></define><use macro="outer" v="x"/></script>`,
+			expected: "print(x)\nprint('again')",
+		},
+		{
+			name:     "Raw placeholder",
+			xml:      `<script><define name="say" params="who"><print>Hi {{{who}}}</print></define><use macro="say" who="name"/></script>`,
+			expected: `print("Hi " .. (name) .. "")`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompiler()
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != 0 {
+				t.Errorf("Expected no warnings, got: %v", warnings)
+			}
+		})
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Undefined macro", `<use macro="missing"/>`, "undefined macro: missing"},
+		{"Missing parameter", `<script><define name="m" params="a, b"><print>{{a}}</print></define><use macro="m" a="1"/></script>`, "macro m requires parameter 'b'"},
+		{"Unknown parameter", `<script><define name="m"><print>1</print></define><use macro="m" x="1"/></script>`, "macro m has no parameter 'x'"},
+		{"Used before definition", `<script><use macro="m"/><define name="m"><print>1</print></define></script>`, "undefined macro: m"},
+		{"Recursive", `<script><define name="m"><use macro="m"/></define><use macro="m"/></script>`, "macro m expands itself"},
+		{"Error inside body", `<script><define name="m"><bogus/></define><use macro="m"/></script>`, "macro m: unknown tag: bogus"},
+		{"Missing name", `<define><print>1</print></define>`, "define command requires 'name' attribute"},
+		{"Invalid parameter", `<define name="m" params="1a"/>`, "invalid macro parameter: 1a"},
+		{"Missing macro attribute", `<use/>`, "use command requires 'macro' attribute"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := CompileString(tc.xml)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestMacrosDoNotOutliveCompilation(t *testing.T) {
+	compiler := NewCompiler()
+	if _, err := compiler.CompileFromString(`<define name="m"><print>1</print></define>`); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	if _, err := compiler.CompileFromString(`<use macro="m"/>`); err == nil {
+		t.Error("Expected macros to be forgotten between compilations")
+	}
+
+	compiler.CompileFromString(`<script><define name="m"><print>1</print></define><define name="m"><print>2</print></define></script>`)
+	if warnings := compiler.Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0].Message, "macro 'm' is redefined") {
+		t.Errorf("Expected a redefinition warning, got: %v", warnings)
+	}
+}
@@ -0,0 +1,48 @@
+package lunaria
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registerModuleCommands registers commands for writing ModuleScripts
+func (c *Compiler) registerModuleCommands() {
+	// <export> command - return var, return expr or return { entries }
+	c.RegisterWithSpec("export", HandlerSpec{
+		Attributes: []string{"var", "module"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		if compiler.atScriptRoot() {
+			if compiler.exported {
+				return "", &CompileError{Tag: "export", Message: "duplicate export: a module can only export once"}
+			}
+			compiler.exported = true
+		} else if GetBoolAttr(node, "module") {
+			return "", &CompileError{Tag: "export", Message: "module export must be at the script root"}
+		} else {
+			compiler.warn("export", "export outside the script root returns from the enclosing block")
+		}
+
+		varName := GetAttr(node, "var")
+		content := strings.TrimSpace(node.Content)
+		switch {
+		case varName != "" && (content != "" || len(node.Nodes) > 0):
+			return "", fmt.Errorf("export command cannot have both 'var' and a value")
+		case varName != "":
+			if !IsValidLValue(varName) {
+				return "", fmt.Errorf("invalid variable name: %s", varName)
+			}
+			return fmt.Sprintf("%sreturn %s", compiler.getIndent(), varName), nil
+		case content != "" && len(node.Nodes) > 0:
+			return "", fmt.Errorf("export command cannot have both content and table entries")
+		case content != "":
+			return fmt.Sprintf("%sreturn %s", compiler.getIndent(), content), nil
+		}
+
+		body, err := compileTableBody(node, compiler)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%sreturn %s", compiler.getIndent(), body), nil
+	})
+}
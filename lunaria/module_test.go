@@ -0,0 +1,105 @@
+package lunaria
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		options  CompileOptions
+		expected string
+	}{
+		{
+			name:     "Variable",
+			xml:      `<script><table var="M" local="true"/><export var="M" module="true"/></script>`,
+			expected: "local M = {}\nreturn M",
+		},
+		{
+			name:     "Expression",
+			xml:      `<export>setmetatable({}, Base)</export>`,
+			expected: "return setmetatable({}, Base)",
+		},
+		{
+			name:     "Inline table",
+			xml:      `<export><entry key="greet">greet</entry><entry key="VERSION">"1.0"</entry></export>`,
+			expected: "return {\n    greet = greet,\n    VERSION = \"1.0\",\n}",
+		},
+		{
+			name:     "Empty table",
+			xml:      `<export/>`,
+			expected: "return {}",
+		},
+		{
+			name:     "Wrapped module",
+			xml:      `<script><set var="M" local="true">{}</set><export var="M" module="true"/></script>`,
+			options:  CompileOptions{WrapScript: WrapFunction},
+			expected: "return (function(...)\n    local M = {}\n    return M\nend)(...)",
+		},
+		{
+			name:     "Comment after export",
+			xml:      `<script><export var="M"/><comment>end of module</comment></script>`,
+			expected: "return M\n-- end of module",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(tc.options)
+			result, err := compiler.CompileFromString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+			if warnings := compiler.Warnings(); len(warnings) != 0 {
+				t.Errorf("Expected no warnings, got: %v", warnings)
+			}
+		})
+	}
+}
+
+func TestExportOutsideRootWarns(t *testing.T) {
+	compiler := NewCompiler()
+	result, err := compiler.CompileFromString(`<script><if test="cached"><export var="cached"/></if><export var="M"/></script>`)
+	if err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	if expected := "if cached then\n    return cached\nend\nreturn M"; result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+	warnings := compiler.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "export outside the script root") {
+		t.Errorf("Expected one warning about the nested export, got: %v", warnings)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		errorMsg string
+	}{
+		{"Duplicate", `<script><export var="A"/><export var="B"/></script>`, "duplicate export"},
+		{"Nested module export", `<function name="f"><export var="M" module="true"/></function>`, "module export must be at the script root"},
+		{"Var and entries", `<export var="M"><entry key="a">1</entry></export>`, "cannot have both 'var' and a value"},
+		{"Content and entries", `<export>M<entry key="a">1</entry></export>`, "cannot have both content and table entries"},
+		{"Invalid var", `<export var="1M"/>`, "invalid variable name"},
+		{"Statement after export", `<script><export var="M"/><print>"hi"</print></script>`, "statement after the module export"},
+		{"Once after export", `<script><export var="M"/><once><print>"hi"</print></once></script>`, "statement after the module export"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := CompileString(tc.xml)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
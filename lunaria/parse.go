@@ -0,0 +1,288 @@
+package lunaria
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parse decodes a Lunaria document into its root Node, checking any
+// version requirement declared in the prolog
+func (c *Compiler) parse(r io.Reader) (Node, error) {
+	d := newDecoder(r)
+	start, err := c.readProlog(d)
+	if err != nil {
+		return Node{}, err
+	}
+
+	var root Node
+	if err := d.DecodeElement(&root, &start); err != nil {
+		return Node{}, fmt.Errorf("XML parse error: %w", err)
+	}
+	return root, nil
+}
+
+// newDecoder returns an XML decoder for a Lunaria document. Besides the five
+// predefined XML entities and numeric character references, it accepts the
+// HTML named entities such as &nbsp; and &copy;.
+func newDecoder(r io.Reader) *xml.Decoder {
+	d := xml.NewDecoder(newAttrEscaper(r))
+	d.Entity = xml.HTMLEntity
+	return d
+}
+
+// readProlog skips to the document's root element, handling processing
+// instructions such as <?lunaria-version "1.0"?> along the way
+func (c *Compiler) readProlog(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, fmt.Errorf("XML parse error: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.ProcInst:
+			if t.Target == "lunaria-version" {
+				if err := c.checkVersion(string(t.Inst)); err != nil {
+					return xml.StartElement{}, err
+				}
+			}
+		}
+	}
+}
+
+// checkVersion compares the version a document requires with Version,
+// failing when the document needs a newer compiler and warning when it
+// targets an older one
+func (c *Compiler) checkVersion(inst string) error {
+	required := strings.Trim(strings.TrimSpace(inst), `"'`)
+
+	cmp, err := compareVersions(required, Version)
+	if err != nil {
+		return &CompileError{Tag: "lunaria-version", Message: err.Error()}
+	}
+
+	switch {
+	case cmp > 0:
+		return &CompileError{
+			Tag:     "lunaria-version",
+			Message: fmt.Sprintf("document requires Lunaria %s but this is %s", required, Version),
+		}
+	case cmp < 0:
+		c.warn("lunaria-version", "document targets Lunaria %s; some features might be unavailable", required)
+	}
+	return nil
+}
+
+// compareVersions compares dotted numeric versions, treating missing
+// components as zero. It returns -1, 0 or 1.
+func compareVersions(a, b string) (int, error) {
+	aParts, err := versionParts(a)
+	if err != nil {
+		return 0, err
+	}
+	bParts, err := versionParts(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// versionParts splits a version such as "1.2.0" into its numeric components
+func versionParts(v string) ([]int, error) {
+	if v == "" {
+		return nil, fmt.Errorf("invalid version: %q", v)
+	}
+
+	var parts []int
+	for _, part := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version: %q", v)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// Scanner states used by attrEscaper
+const (
+	scanText = iota
+	scanTag
+	scanQuote
+	scanSkip
+)
+
+// attrEscaper rewrites unescaped '<' characters inside quoted attribute
+// values to "&lt;". Strict XML forbids them, but conditions such as
+// test="x < 10" are far too common in scripts to require escaping by hand.
+// Likewise a '&' in text or attributes that does not start an entity or
+// character reference, as in x ~= 0 && y, is rewritten to "&amp;".
+// Comments, CDATA sections, processing instructions and directives are
+// passed through untouched.
+type attrEscaper struct {
+	r       *bufio.Reader
+	state   int
+	quote   byte
+	closing string
+	tail    []byte
+	pending []byte
+}
+
+// newAttrEscaper wraps r with an attrEscaper
+func newAttrEscaper(r io.Reader) *attrEscaper {
+	return &attrEscaper{r: bufio.NewReader(r)}
+}
+
+// Read implements io.Reader
+func (e *attrEscaper) Read(p []byte) (int, error) {
+	for len(e.pending) < len(p) {
+		if len(e.pending) > 0 && e.r.Buffered() == 0 {
+			break
+		}
+		b, err := e.r.ReadByte()
+		if err != nil {
+			if len(e.pending) > 0 {
+				break
+			}
+			return 0, err
+		}
+		e.step(b)
+	}
+
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// step advances the scanner by one input byte
+func (e *attrEscaper) step(b byte) {
+	switch e.state {
+	case scanText:
+		if b == '<' {
+			e.enterMarkup()
+		} else if b == '&' && !e.atReference() {
+			e.pending = append(e.pending, "&amp;"...)
+			return
+		}
+	case scanTag:
+		switch b {
+		case '"', '\'':
+			e.state = scanQuote
+			e.quote = b
+		case '>':
+			e.state = scanText
+		}
+	case scanQuote:
+		if b == e.quote {
+			e.state = scanTag
+		} else if b == '<' {
+			e.pending = append(e.pending, "&lt;"...)
+			return
+		} else if b == '&' && !e.atReference() {
+			e.pending = append(e.pending, "&amp;"...)
+			return
+		}
+	case scanSkip:
+		e.tail = append(e.tail, b)
+		if len(e.tail) > len(e.closing) {
+			e.tail = e.tail[1:]
+		}
+		if string(e.tail) == e.closing {
+			e.state = scanText
+			e.tail = e.tail[:0]
+		}
+	}
+	e.pending = append(e.pending, b)
+}
+
+// enterMarkup decides how to scan the markup following a '<'
+func (e *attrEscaper) enterMarkup() {
+	next, _ := e.r.Peek(8)
+	switch {
+	case hasBytePrefix(next, "!--"):
+		e.skipUntil("-->")
+	case hasBytePrefix(next, "![CDATA["):
+		e.skipUntil("]]>")
+	case hasBytePrefix(next, "!"):
+		e.skipUntil(">")
+	case hasBytePrefix(next, "?"):
+		e.skipUntil("?>")
+	default:
+		e.state = scanTag
+	}
+}
+
+// maxReferenceLength bounds the lookahead used to recognise a reference
+const maxReferenceLength = 32
+
+// atReference reports whether the input following a '&' forms an entity
+// reference (&name;) or a character reference (&#65; or &#x41;)
+func (e *attrEscaper) atReference() bool {
+	next, _ := e.r.Peek(maxReferenceLength)
+	end := bytes.IndexByte(next, ';')
+	if end <= 0 {
+		return false
+	}
+	ref := next[:end]
+
+	if ref[0] == '#' {
+		digits, isDigit := ref[1:], func(b byte) bool { return b >= '0' && b <= '9' }
+		if len(digits) > 0 && digits[0] == 'x' {
+			digits, isDigit = digits[1:], func(b byte) bool {
+				return b >= '0' && b <= '9' || b >= 'a' && b <= 'f' || b >= 'A' && b <= 'F'
+			}
+		}
+		if len(digits) == 0 {
+			return false
+		}
+		for _, b := range digits {
+			if !isDigit(b) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i, b := range ref {
+		letter := b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '_'
+		if !letter && (i == 0 || !(b >= '0' && b <= '9' || b == '.' || b == '-')) {
+			return false
+		}
+	}
+	return true
+}
+
+// skipUntil passes input through unchanged until closing has been seen
+func (e *attrEscaper) skipUntil(closing string) {
+	e.state = scanSkip
+	e.closing = closing
+	e.tail = e.tail[:0]
+}
+
+// hasBytePrefix reports whether b begins with prefix
+func hasBytePrefix(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && string(b[:len(prefix)]) == prefix
+}
@@ -0,0 +1,210 @@
+package lunaria
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestVersionProcessingInstruction(t *testing.T) {
+	testCases := []struct {
+		name     string
+		version  string
+		wantErr  bool
+		warnings int
+	}{
+		{"Matching", `"1.0"`, false, 0},
+		{"Matching full", `"1.0.0"`, false, 0},
+		{"Newer required", `"1.1"`, true, 0},
+		{"Newer major required", `"2"`, true, 0},
+		{"Older required", `"0.9"`, false, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			xml := `<?xml version="1.0"?>
+<?lunaria-version ` + tc.version + `?>
+<script><set var="x">1</set></script>`
+
+			compiler := NewCompiler()
+			result, batchErr := compiler.CompileFromString(xml)
+			batchWarnings := len(compiler.Warnings())
+
+			var out strings.Builder
+			streamErr := compiler.CompileStream(strings.NewReader(xml), &out)
+			streamWarnings := len(compiler.Warnings())
+
+			for mode, err := range map[string]error{"batch": batchErr, "stream": streamErr} {
+				if !tc.wantErr {
+					if err != nil {
+						t.Errorf("%s: unexpected error: %v", mode, err)
+					}
+					continue
+				}
+				var compileErr *CompileError
+				if !errors.As(err, &compileErr) || compileErr.Tag != "lunaria-version" {
+					t.Errorf("%s: expected lunaria-version error, got: %v", mode, err)
+				}
+			}
+
+			if batchWarnings != tc.warnings || streamWarnings != tc.warnings {
+				t.Errorf("Expected %d warnings, got batch=%d stream=%d", tc.warnings, batchWarnings, streamWarnings)
+			}
+			if !tc.wantErr && (result != "x = 1" || out.String() != "x = 1") {
+				t.Errorf("Unexpected output: batch=%q stream=%q", result, out.String())
+			}
+		})
+	}
+}
+
+func TestInvalidVersionProcessingInstruction(t *testing.T) {
+	_, err := CompileString(`<?lunaria-version "one"?><set var="x">1</set>`)
+	if err == nil || !strings.Contains(err.Error(), "invalid version") {
+		t.Errorf("Expected invalid version error, got: %v", err)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0.0", 0},
+		{"1.2", "1.10", -1},
+		{"2.0", "1.9.9", 1},
+		{"1", "1.0.1", -1},
+	}
+
+	for _, tc := range testCases {
+		got, err := compareVersions(tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("compareVersions(%q, %q) failed: %v", tc.a, tc.b, err)
+		}
+		if got != tc.expected {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestEntities(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name:     "Comparison in content",
+			xml:      `<set var="ok">x &lt; 10 and y &gt;= 2</set>`,
+			expected: "ok = x < 10 and y >= 2",
+		},
+		{
+			name:     "Comparison in attribute",
+			xml:      `<while test="n &gt; 0 and n &lt;= limit"><break/></while>`,
+			expected: "while n > 0 and n <= limit do\n    break\nend",
+		},
+		{
+			name:     "Escaped ampersands",
+			xml:      `<set var="s">"a &amp;&amp; b"</set>`,
+			expected: `s = "a && b"`,
+		},
+		{
+			name:     "Bare ampersands",
+			xml:      `<raw>local s = "R&D && Q&amp;A"</raw>`,
+			expected: `local s = "R&D && Q&A"`,
+		},
+		{
+			name:     "Bare ampersand in attribute",
+			xml:      `<if test='name == "R&D"'><return/></if>`,
+			expected: "if name == \"R&D\" then\n    return\nend",
+		},
+		{
+			name:     "Numeric references",
+			xml:      `<raw>local s = "&#65;&#x42;&#X43;"</raw>`,
+			expected: `local s = "AB&#X43;"`,
+		},
+		{
+			name:     "HTML entities",
+			xml:      `<raw>-- &copy; 2024&nbsp;Lunaria</raw>`,
+			expected: "-- \u00a9 2024\u00a0Lunaria",
+		},
+		{
+			name:     "Raw with quotes and apostrophes",
+			xml:      `<raw>if a &lt; b then print(&quot;it&apos;s&quot;) end</raw>`,
+			expected: `if a < b then print("it's") end`,
+		},
+		{
+			name:     "Raw keeps entities inside CDATA",
+			xml:      `<raw><![CDATA[x = "&lt;" .. a]]></raw>`,
+			expected: `x = "&lt;" .. a`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+
+			var streamed strings.Builder
+			if err := NewCompiler().CompileStream(strings.NewReader(tc.xml), &streamed); err != nil {
+				t.Fatalf("Streaming failed: %v", err)
+			}
+			if streamed.String() != result {
+				t.Errorf("Expected streamed output to match:\n%s\nGot:\n%s", result, streamed.String())
+			}
+		})
+	}
+}
+
+func TestUnknownEntity(t *testing.T) {
+	if _, err := CompileString(`<raw>x = "&bogus;"</raw>`); err == nil {
+		t.Error("Expected error for unknown entity")
+	}
+}
+
+func TestAttrEscaper(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Less than in double quotes", `<if test="x < 10">`, `<if test="x &lt; 10">`},
+		{"Less than in single quotes", `<if test='x <= "a"'>`, `<if test='x &lt;= "a"'>`},
+		{"Greater than in quotes", `<if test="x > 1"><a/></if>`, `<if test="x > 1"><a/></if>`},
+		{"Bare ampersand in attribute", `<if test="a && b">`, `<if test="a &amp;&amp; b">`},
+		{"Bare ampersand in text", `<raw>R&D</raw>`, `<raw>R&amp;D</raw>`},
+		{"References kept", `<raw a="&lt;&#65;&#x4a;">&amp;&nbsp;</raw>`, `<raw a="&lt;&#65;&#x4a;">&amp;&nbsp;</raw>`},
+		{"Unterminated reference", `<raw>&#x; &</raw>`, `<raw>&amp;#x; &amp;</raw>`},
+		{"Comment untouched", `<!-- a="<" & --><a/>`, `<!-- a="<" & --><a/>`},
+		{"CDATA untouched", `<raw><![CDATA[a < b && "<"]]></raw>`, `<raw><![CDATA[a < b && "<"]]></raw>`},
+		{"Processing instruction untouched", `<?lunaria-version "1.0" & "<"?><a/>`, `<?lunaria-version "1.0" & "<"?><a/>`},
+		{"Directive untouched", `<!DOCTYPE a "<"><a/>`, `<!DOCTYPE a "<"><a/>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Reading a byte at a time exercises the lookahead at every
+			// buffer boundary
+			for _, r := range []io.Reader{strings.NewReader(tc.input), iotest.OneByteReader(strings.NewReader(tc.input))} {
+				result, err := io.ReadAll(newAttrEscaper(r))
+				if err != nil {
+					t.Fatalf("Reading failed: %v", err)
+				}
+				if string(result) != tc.expected {
+					t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+				}
+			}
+		})
+	}
+
+	result, err := CompileString(`<while test="i < n && ok"><break/></while>`)
+	if expected := "while i < n && ok do\n    break\nend"; err != nil || result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s (error: %v)", expected, result, err)
+	}
+}
@@ -0,0 +1,16 @@
+package lunaria
+
+// Plugin is a set of custom handlers distributed separately from the
+// compiler, such as a team's own tags. The plugin subpackage loads them
+// from Go plugins.
+type Plugin interface {
+	Register(c *Compiler)
+}
+
+// PluginFunc adapts a function registering handlers to the Plugin interface
+type PluginFunc func(c *Compiler)
+
+// Register calls f(c)
+func (f PluginFunc) Register(c *Compiler) {
+	f(c)
+}
@@ -0,0 +1,42 @@
+// Package plugin loads lunaria handlers from Go plugins. It is kept apart
+// from the lunaria package so that programs embedding the compiler do not
+// link the Go plugin runtime unless they load plugins.
+package plugin
+
+import (
+	"fmt"
+	goplugin "plugin"
+
+	"lunaria/lunaria"
+)
+
+// Symbol is the symbol Load looks up in a Go plugin: either a
+// func(*lunaria.Compiler) or a variable whose value implements
+// lunaria.Plugin
+const Symbol = "RegisterHandlers"
+
+// Load opens the Go plugin built with -buildmode=plugin at path and returns
+// its Symbol as a lunaria.Plugin. Go plugins are only supported on some
+// platforms, and must be built with the same Go version and lunaria package
+// as the program loading them; on other platforms Load returns an error.
+func Load(path string) (lunaria.Plugin, error) {
+	p, err := goplugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading plugin %s: %w", path, err)
+	}
+	sym, err := p.Lookup(Symbol)
+	if err != nil {
+		return nil, fmt.Errorf("loading plugin %s: %w", path, err)
+	}
+
+	switch sym := sym.(type) {
+	case func(*lunaria.Compiler):
+		return lunaria.PluginFunc(sym), nil
+	case lunaria.Plugin:
+		return sym, nil
+	case *lunaria.Plugin:
+		return *sym, nil
+	default:
+		return nil, fmt.Errorf("loading plugin %s: %s is a %T, not a func(*lunaria.Compiler) or lunaria.Plugin", path, Symbol, sym)
+	}
+}
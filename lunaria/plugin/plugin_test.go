@@ -0,0 +1,13 @@
+package plugin
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMissing(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.so")); err == nil || !strings.Contains(err.Error(), "loading plugin") {
+		t.Errorf("Expected plugin load error, got: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package lunaria
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registerRobloxCommands registers commands for the Roblox Instance API
+func (c *Compiler) registerRobloxCommands() {
+	// <find-first-child> command - parent:FindFirstChild("name", recursive)
+	c.RegisterWithSpec("find-first-child", HandlerSpec{
+		Attributes: []string{"parent", "name", "recursive", "var", "local"},
+		Required:   []string{"parent", "name"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		parent, name, err := childLookup("find-first-child", node)
+		if err != nil {
+			return "", err
+		}
+
+		args := `"` + EscapeString(name) + `"`
+		if GetBoolAttr(node, "recursive") {
+			args += ", true"
+		}
+		return compileAssignment("find-first-child", node, compiler, fmt.Sprintf("%s:FindFirstChild(%s)", parent, args))
+	})
+
+	// <wait-for-child> command - parent:WaitForChild("name", timeout)
+	c.RegisterWithSpec("wait-for-child", HandlerSpec{
+		Attributes: []string{"parent", "name", "timeout", "var", "local"},
+		Required:   []string{"parent", "name"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		parent, name, err := childLookup("wait-for-child", node)
+		if err != nil {
+			return "", err
+		}
+
+		args := `"` + EscapeString(name) + `"`
+		if timeout := GetAttr(node, "timeout"); timeout != "" {
+			args += ", " + timeout
+		}
+		return compileAssignment("wait-for-child", node, compiler, fmt.Sprintf("%s:WaitForChild(%s)", parent, args))
+	})
+
+	// <get-property> command - instance.Property
+	c.RegisterWithSpec("get-property", HandlerSpec{
+		Attributes: []string{"instance", "property", "var", "local"},
+		Required:   []string{"instance", "property"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		access, err := propertyAccess("get-property", node)
+		if err != nil {
+			return "", err
+		}
+		return compileAssignment("get-property", node, compiler, access)
+	})
+
+	// <set-property> command - instance.Property = value
+	c.RegisterWithSpec("set-property", HandlerSpec{
+		Attributes: []string{"instance", "property"},
+		Required:   []string{"instance", "property"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		access, err := propertyAccess("set-property", node)
+		if err != nil {
+			return "", err
+		}
+
+		value, err := compileValue(node, compiler)
+		if err != nil {
+			return "", err
+		}
+		if value == "" {
+			return "", fmt.Errorf("set-property command requires a value")
+		}
+		return fmt.Sprintf("%s%s = %s", compiler.getIndent(), access, value), nil
+	})
+
+	// <vector3> command - Vector3.new(x, y, z)
+	c.RegisterWithSpec("vector3", HandlerSpec{
+		Attributes: []string{"x", "y", "z", "var", "local"},
+		Required:   []string{"x", "y", "z"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		args, err := constructorArgs("vector3", node, "x", "y", "z")
+		if err != nil {
+			return "", err
+		}
+		return compileAssignment("vector3", node, compiler, "Vector3.new("+args+")")
+	})
+
+	// <color3> command - Color3.new(r, g, b) from 0-1 components,
+	// Color3.fromRGB(r, g, b) from 0-255 ones with mode="rgb", or
+	// Color3.fromHSV(h, s, v) with mode="hsv"
+	c.RegisterWithSpec("color3", HandlerSpec{
+		Attributes: []string{"mode", "r", "g", "b", "h", "s", "v", "var", "local"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		var ctor string
+		var components []string
+		switch mode := GetAttrWithDefault(node, "mode", "new"); mode {
+		case "new":
+			ctor, components = "new", []string{"r", "g", "b"}
+		case "rgb":
+			ctor, components = "fromRGB", []string{"r", "g", "b"}
+		case "hsv":
+			ctor, components = "fromHSV", []string{"h", "s", "v"}
+		default:
+			return "", fmt.Errorf("invalid color3 mode: %s (expected new, rgb or hsv)", mode)
+		}
+
+		args, err := constructorArgs("color3", node, components...)
+		if err != nil {
+			return "", err
+		}
+		return compileAssignment("color3", node, compiler, "Color3."+ctor+"("+args+")")
+	})
+
+	// <udim2> command - UDim2.new(xs, xo, ys, yo), the scale and offset of
+	// each axis
+	c.RegisterWithSpec("udim2", HandlerSpec{
+		Attributes: []string{"xs", "xo", "ys", "yo", "var", "local"},
+		Required:   []string{"xs", "xo", "ys", "yo"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		args, err := constructorArgs("udim2", node, "xs", "xo", "ys", "yo")
+		if err != nil {
+			return "", err
+		}
+		return compileAssignment("udim2", node, compiler, "UDim2.new("+args+")")
+	})
+}
+
+// constructorArgs joins the named attributes of a data type constructor
+// command into its argument list. Each attribute is required.
+func constructorArgs(tag string, node Node, names ...string) (string, error) {
+	args := make([]string, len(names))
+	for i, name := range names {
+		args[i] = GetAttr(node, name)
+		if args[i] == "" {
+			return "", fmt.Errorf("%s command requires '%s' attribute", tag, name)
+		}
+	}
+	return strings.Join(args, ", "), nil
+}
+
+// propertyAccess returns the instance.Property expression of a
+// <get-property> or <set-property> command
+func propertyAccess(tag string, node Node) (string, error) {
+	instance := GetAttr(node, "instance")
+	property := GetAttr(node, "property")
+	if instance == "" {
+		return "", fmt.Errorf("%s command requires 'instance' attribute", tag)
+	}
+	if property == "" {
+		return "", fmt.Errorf("%s command requires 'property' attribute", tag)
+	}
+	if !IsValidIdentifier(property) {
+		return "", fmt.Errorf("invalid property name: %s", property)
+	}
+	return prefixExpression(instance) + "." + property, nil
+}
+
+// childLookup returns the parent expression and child name of a
+// <find-first-child> or <wait-for-child> command
+func childLookup(tag string, node Node) (string, string, error) {
+	parent := GetAttr(node, "parent")
+	name := GetAttr(node, "name")
+	if parent == "" {
+		return "", "", fmt.Errorf("%s command requires 'parent' attribute", tag)
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("%s command requires 'name' attribute", tag)
+	}
+	return prefixExpression(parent), name, nil
+}
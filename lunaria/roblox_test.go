@@ -0,0 +1,155 @@
+package lunaria
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChildLookup(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Find", `<find-first-child parent="character" name="Humanoid" var="humanoid" local="true"/>`, `local humanoid = character:FindFirstChild("Humanoid")`},
+		{"Find recursive", `<find-first-child parent="workspace" name="Spawn" recursive="true" var="spawn"/>`, `spawn = workspace:FindFirstChild("Spawn", true)`},
+		{"Find expression", `<find-first-child parent="model" name="Door"/>`, `model:FindFirstChild("Door")`},
+		{"Find field parent", `<find-first-child parent="player.Character" name="Head" var="head"/>`, `head = player.Character:FindFirstChild("Head")`},
+		{"Find call parent", `<find-first-child parent="game:GetService(&quot;Players&quot;)" name="Bob" var="bob" local="true"/>`, `local bob = game:GetService("Players"):FindFirstChild("Bob")`},
+		{"Find chained parent", `<find-first-child parent="script.Parent:FindFirstChild(&quot;Config&quot;)" name="Speed"/>`, `script.Parent:FindFirstChild("Config"):FindFirstChild("Speed")`},
+		{"Find operator parent", `<find-first-child parent="a or b" name="X"/>`, `(a or b):FindFirstChild("X")`},
+		{"Wait", `<wait-for-child parent="player" name="PlayerGui" var="gui" local="true"/>`, `local gui = player:WaitForChild("PlayerGui")`},
+		{"Wait timeout", `<wait-for-child parent="player" name="leaderstats" timeout="5" var="stats" local="true"/>`, `local stats = player:WaitForChild("leaderstats", 5)`},
+		{"Wait escapes name", `<wait-for-child parent="folder" name="Bob's &quot;Part&quot;"/>`, `folder:WaitForChild("Bob's \"Part\"")`},
+		{"Wait inside call", `<call name="print"><arg><wait-for-child parent="x" name="Y"/></arg></call>`, `print(x:WaitForChild("Y"))`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestChildLookupErrors(t *testing.T) {
+	testCases := []struct {
+		xml      string
+		errorMsg string
+	}{
+		{`<find-first-child name="X"/>`, "find-first-child command requires 'parent' attribute"},
+		{`<find-first-child parent="p"/>`, "find-first-child command requires 'name' attribute"},
+		{`<wait-for-child name="X"/>`, "wait-for-child command requires 'parent' attribute"},
+		{`<wait-for-child parent="p" name="X" var="1x"/>`, "invalid variable name"},
+	}
+
+	for _, tc := range testCases {
+		if _, err := CompileString(tc.xml); err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+			t.Errorf("Expected error containing %q for %s, got: %v", tc.errorMsg, tc.xml, err)
+		}
+	}
+}
+
+func TestProperties(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Get", `<get-property instance="part" property="Position" var="pos" local="true"/>`, `local pos = part.Position`},
+		{"Get expression", `<call name="print"><arg><get-property instance="part" property="Name"/></arg></call>`, `print(part.Name)`},
+		{"Get nested instance", `<get-property instance="workspace.Map.Door" property="Transparency" var="t"/>`, `t = workspace.Map.Door.Transparency`},
+		{"Get from call", `<get-property instance="game:GetService(&quot;Lighting&quot;)" property="ClockTime" var="time" local="true"/>`, `local time = game:GetService("Lighting").ClockTime`},
+		{"Set", `<set-property instance="part" property="Position">Vector3.new(0, 1, 0)</set-property>`, `part.Position = Vector3.new(0, 1, 0)`},
+		{"Set nested instance", `<set-property instance="player.Character.Humanoid" property="WalkSpeed">32</set-property>`, `player.Character.Humanoid.WalkSpeed = 32`},
+		{"Set from child", `<set-property instance="part" property="Anchored"><not>falling</not></set-property>`, `part.Anchored = not (falling)`},
+		{"Set in block", `<if test="part"><set-property instance="part" property="Transparency">1</set-property></if>`, "if part then\n    part.Transparency = 1\nend"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestPropertyErrors(t *testing.T) {
+	testCases := []struct {
+		xml      string
+		errorMsg string
+	}{
+		{`<get-property instance="part" var="p"/>`, "get-property command requires 'property' attribute"},
+		{`<set-property instance="part">1</set-property>`, "set-property command requires 'property' attribute"},
+		{`<get-property property="Name"/>`, "get-property command requires 'instance' attribute"},
+		{`<set-property instance="part" property="Size"></set-property>`, "set-property command requires a value"},
+		{`<get-property instance="part" property="Bad Name"/>`, "invalid property name: Bad Name"},
+	}
+
+	for _, tc := range testCases {
+		if _, err := CompileString(tc.xml); err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+			t.Errorf("Expected error containing %q for %s, got: %v", tc.errorMsg, tc.xml, err)
+		}
+	}
+}
+
+func TestDataTypeConstructors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Vector3", `<vector3 x="0" y="1" z="0" var="pos" local="true"/>`, `local pos = Vector3.new(0, 1, 0)`},
+		{"Vector3 expressions", `<vector3 x="a.X" y="h / 2" z="-1"/>`, `Vector3.new(a.X, h / 2, -1)`},
+		{"Color3 default", `<color3 r="1" g="0.5" b="0" var="c"/>`, `c = Color3.new(1, 0.5, 0)`},
+		{"Color3 new", `<color3 r="0" g="0" b="1" mode="new"/>`, `Color3.new(0, 0, 1)`},
+		{"Color3 RGB", `<color3 r="255" g="0" b="0" mode="rgb" var="c" local="true"/>`, `local c = Color3.fromRGB(255, 0, 0)`},
+		{"Color3 HSV", `<color3 h="hue" s="1" v="1" mode="hsv" var="c" local="true"/>`, `local c = Color3.fromHSV(hue, 1, 1)`},
+		{"UDim2", `<udim2 xs="0.5" xo="0" ys="0" yo="20" var="size" local="true"/>`, `local size = UDim2.new(0.5, 0, 0, 20)`},
+		{"Set property value", `<set-property instance="frame" property="Position"><udim2 xs="0" xo="10" ys="1" yo="-10"/></set-property>`, `frame.Position = UDim2.new(0, 10, 1, -10)`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestDataTypeConstructorErrors(t *testing.T) {
+	testCases := []struct {
+		xml      string
+		errorMsg string
+	}{
+		{`<vector3 x="0" y="1"/>`, "vector3 command requires 'z' attribute"},
+		{`<color3 g="0" b="0"/>`, "color3 command requires 'r' attribute"},
+		{`<color3 r="1" g="0" b="0" mode="hsv"/>`, "color3 command requires 'h' attribute"},
+		{`<color3 r="1" g="0" b="0" mode="hex"/>`, "invalid color3 mode: hex"},
+		{`<udim2 xs="0" xo="0" ys="0"/>`, "udim2 command requires 'yo' attribute"},
+	}
+
+	for _, tc := range testCases {
+		if _, err := CompileString(tc.xml); err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+			t.Errorf("Expected error containing %q for %s, got: %v", tc.errorMsg, tc.xml, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package lunaria
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// SafeCompiler wraps a Compiler for concurrent use. A plain Compiler keeps
+// per-compilation state such as indentation and warnings on itself, so it
+// must not be shared between goroutines. SafeCompiler instead guards its
+// handlers with a read-write lock and compiles each document on a fresh
+// Clone, so handlers can be registered while other goroutines compile.
+// A compilation that has already started keeps the handlers it began with.
+type SafeCompiler struct {
+	mu   sync.RWMutex
+	base *Compiler
+}
+
+// NewSafeCompiler creates a SafeCompiler using base as its template, or a
+// new Compiler when base is nil. The SafeCompiler takes ownership of base,
+// which should not be used directly afterwards.
+func NewSafeCompiler(base *Compiler) *SafeCompiler {
+	if base == nil {
+		base = NewCompiler()
+	}
+	return &SafeCompiler{base: base}
+}
+
+// Register adds a custom handler for a specific XML tag
+func (s *SafeCompiler) Register(tag string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base.Register(tag, handler)
+}
+
+// RegisterWithSpec adds a handler and its spec, as Compiler.RegisterWithSpec does
+func (s *SafeCompiler) RegisterWithSpec(tag string, spec HandlerSpec, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base.RegisterWithSpec(tag, spec, handler)
+}
+
+// Spec returns the spec a tag was registered with, as Compiler.Spec does
+func (s *SafeCompiler) Spec(tag string) (HandlerSpec, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.base.Spec(tag)
+}
+
+// Use adds middleware that wraps every handler, as Compiler.Use does
+func (s *SafeCompiler) Use(mw Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base.Use(mw)
+}
+
+// Options returns the compiler's current options
+func (s *SafeCompiler) Options() CompileOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.base.Options()
+}
+
+// SetOptions replaces the compiler's options
+func (s *SafeCompiler) SetOptions(opts CompileOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base.SetOptions(opts)
+}
+
+// Clone returns a snapshot of the current handlers, middleware and options
+// as a plain Compiler, e.g. to read its Warnings after compiling
+func (s *SafeCompiler) Clone() *Compiler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.base.Clone()
+}
+
+// CompileFromString compiles an XML string to Luau code
+func (s *SafeCompiler) CompileFromString(src string) (string, error) {
+	return s.Clone().CompileFromString(src)
+}
+
+// CompileFromStringContext compiles an XML string to Luau code, stopping
+// early when ctx is cancelled
+func (s *SafeCompiler) CompileFromStringContext(ctx context.Context, src string) (string, error) {
+	return s.Clone().CompileFromStringContext(ctx, src)
+}
+
+// CompileFromReader compiles XML from an io.Reader to Luau code
+func (s *SafeCompiler) CompileFromReader(r io.Reader) (string, error) {
+	return s.Clone().CompileFromReader(r)
+}
+
+// CompileStream compiles XML from r and writes Luau to w
+func (s *SafeCompiler) CompileStream(r io.Reader, w io.Writer) error {
+	return s.Clone().CompileStream(r, w)
+}
@@ -0,0 +1,177 @@
+package lunaria
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registerStringCommands registers commands wrapping the string library
+func (c *Compiler) registerStringCommands() {
+	// <string-match> command - string.match(str, pattern, init)
+	c.RegisterWithSpec("string-match", HandlerSpec{
+		Attributes: []string{"str", "pattern", "init", "var", "local"},
+		Required:   []string{"str", "pattern"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		args, err := patternArgs("string-match", node)
+		if err != nil {
+			return "", err
+		}
+
+		return compileCaptures("string-match", node, compiler, fmt.Sprintf("string.match(%s)", args))
+	})
+
+	// <string-find> command - string.find(str, pattern, init, plain)
+	c.RegisterWithSpec("string-find", HandlerSpec{
+		Attributes: []string{"str", "pattern", "init", "plain", "var", "local"},
+		Required:   []string{"str", "pattern"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		args, err := patternArgs("string-find", node)
+		if err != nil {
+			return "", err
+		}
+
+		// plain is the fourth argument, so it needs an init to follow
+		if GetBoolAttr(node, "plain") {
+			if !HasAttr(node, "init") {
+				args += ", 1"
+			}
+			args += ", true"
+		}
+
+		return compileCaptures("string-find", node, compiler, fmt.Sprintf("string.find(%s)", args))
+	})
+
+	// <string-gmatch> command - loops over every match of a pattern
+	c.RegisterWithSpec("string-gmatch", HandlerSpec{
+		Attributes: []string{"var", "str", "pattern", "init"},
+		Required:   []string{"var", "str", "pattern"},
+		Body:       true,
+	}, func(node Node, compiler *Compiler) (string, error) {
+		names := SplitParameters(GetAttr(node, "var"))
+		if len(names) == 0 {
+			return "", fmt.Errorf("string-gmatch command requires 'var' attribute")
+		}
+		for _, name := range names {
+			if !IsValidIdentifier(name) {
+				return "", fmt.Errorf("invalid variable name: %s", name)
+			}
+		}
+
+		args, err := patternArgs("string-gmatch", node)
+		if err != nil {
+			return "", err
+		}
+
+		result := fmt.Sprintf("%sfor %s in string.gmatch(%s) do\n", compiler.getIndent(), strings.Join(names, ", "), args)
+
+		compiler.indent++
+		compiler.loopDepth++
+		compiler.pushScope()
+		body, err := compiler.CompileChildren(node)
+		if err != nil {
+			return "", err
+		}
+		if body != "" {
+			result += body + "\n"
+		}
+		compiler.popScope()
+		compiler.loopDepth--
+		compiler.indent--
+
+		result += compiler.getIndent() + "end"
+		return result, nil
+	})
+
+	// <string-split> command - str:split(sep), Roblox's string.split
+	c.RegisterWithSpec("string-split", HandlerSpec{
+		Attributes: []string{"str", "sep", "var", "local"},
+		Required:   []string{"str"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		str := GetAttr(node, "str")
+		if str == "" {
+			return "", fmt.Errorf("string-split command requires 'str' attribute")
+		}
+		if compiler.targetDialect() == TargetLua51 {
+			return "", &CompileError{Tag: "string-split", Message: "string.split is not available in Lua 5.1"}
+		}
+
+		expr := prefixExpression(str) + ":split(" + separatorArg(node) + ")"
+		return compileAssignment("string-split", node, compiler, expr)
+	})
+
+	// <string-join> command - table.concat(table, sep)
+	c.RegisterWithSpec("string-join", HandlerSpec{
+		Attributes: []string{"table", "sep", "var", "local"},
+		Required:   []string{"table"},
+	}, func(node Node, compiler *Compiler) (string, error) {
+		table := GetAttr(node, "table")
+		if table == "" {
+			return "", fmt.Errorf("string-join command requires 'table' attribute")
+		}
+
+		args := table
+		if sep := separatorArg(node); sep != "" {
+			args += ", " + sep
+		}
+		return compileAssignment("string-join", node, compiler, fmt.Sprintf("table.concat(%s)", args))
+	})
+}
+
+// separatorArg returns the 'sep' attribute of <string-split> or
+// <string-join> as an argument. A string literal or a variable is used as
+// written; anything else is plain text and is quoted, so sep="." splits on
+// dots.
+func separatorArg(node Node) string {
+	if !HasAttr(node, "sep") {
+		return ""
+	}
+	sep := GetAttr(node, "sep")
+	if IsStringLiteral(sep) || IsValidLValue(sep) {
+		return sep
+	}
+	return `"` + EscapeString(sep) + `"`
+}
+
+// patternArgs builds the str, pattern and optional init arguments shared by
+// the string pattern commands. The pattern is written as plain text and
+// quoted here, so XML entities such as &lt; can be used in it.
+func patternArgs(tag string, node Node) (string, error) {
+	str := GetAttr(node, "str")
+	if str == "" {
+		return "", fmt.Errorf("%s command requires 'str' attribute", tag)
+	}
+	if !HasAttr(node, "pattern") {
+		return "", fmt.Errorf("%s command requires 'pattern' attribute", tag)
+	}
+
+	args := fmt.Sprintf(`%s, "%s"`, str, EscapeString(GetAttr(node, "pattern")))
+	if init := GetAttr(node, "init"); init != "" {
+		args += ", " + init
+	}
+	return args, nil
+}
+
+// compileCaptures assigns expr to the comma-separated names in 'var', one
+// per capture, or returns expr bare when 'var' is absent
+func compileCaptures(tag string, node Node, compiler *Compiler, expr string) (string, error) {
+	names := SplitParameters(GetAttr(node, "var"))
+	if len(names) == 0 {
+		return expr, nil
+	}
+
+	for _, name := range names {
+		if !IsValidIdentifier(name) {
+			return "", fmt.Errorf("invalid variable name: %s", name)
+		}
+	}
+
+	prefix := ""
+	if GetBoolAttr(node, "local") {
+		prefix = "local "
+		for _, name := range names {
+			compiler.declareLocal(tag, name)
+		}
+	}
+
+	return fmt.Sprintf("%s%s%s = %s", compiler.getIndent(), prefix, strings.Join(names, ", "), expr), nil
+}
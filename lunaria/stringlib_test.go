@@ -0,0 +1,159 @@
+package lunaria
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringPatternCommands(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{
+			name:     "Match capture",
+			xml:      `<string-match var="digits" local="true" str="line" pattern="%d+"/>`,
+			expected: `local digits = string.match(line, "%d+")`,
+		},
+		{
+			name:     "Match multiple captures",
+			xml:      `<string-match var="key, value" local="true" str="pair" pattern="(%w+)=(%w+)" init="2"/>`,
+			expected: `local key, value = string.match(pair, "(%w+)=(%w+)", 2)`,
+		},
+		{
+			name:     "Match expression",
+			xml:      `<string-match str="name" pattern="^%s*(.-)%s*$"/>`,
+			expected: `string.match(name, "^%s*(.-)%s*$")`,
+		},
+		{
+			name:     "Entities and quotes in pattern",
+			xml:      `<string-match var="tag" str="html" pattern="&lt;(%w+)&gt; &quot;"/>`,
+			expected: `tag = string.match(html, "<(%w+)> \"")`,
+		},
+		{
+			name:     "Find",
+			xml:      `<string-find var="i, j" local="true" str="text" pattern="needle"/>`,
+			expected: `local i, j = string.find(text, "needle")`,
+		},
+		{
+			name:     "Find plain",
+			xml:      `<string-find var="i" local="true" str="text" pattern="a.b" plain="true"/>`,
+			expected: `local i = string.find(text, "a.b", 1, true)`,
+		},
+		{
+			name:     "Find plain with init",
+			xml:      `<string-find var="i" str="text" pattern="." init="5" plain="true"/>`,
+			expected: `i = string.find(text, ".", 5, true)`,
+		},
+		{
+			name: "Gmatch loop",
+			xml: `<string-gmatch var="word" str="sentence" pattern="%a+">
+  <print>{{word}}</print>
+  <if test="word == &quot;stop&quot;"><break/></if>
+</string-gmatch>`,
+			expected: `for word in string.gmatch(sentence, "%a+") do
+    print("" .. tostring(word) .. "")
+    if word == "stop" then
+        break
+    end
+end`,
+		},
+		{
+			name:     "Gmatch captures",
+			xml:      `<string-gmatch var="k, v" str="query" pattern="(%w+)=(%w+)"></string-gmatch>`,
+			expected: "for k, v in string.gmatch(query, \"(%w+)=(%w+)\") do\nend",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestStringPatternErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		xml  string
+	}{
+		{"Missing str", `<string-match var="m" pattern="x"/>`},
+		{"Missing pattern", `<string-find var="i" str="s"/>`},
+		{"Invalid capture name", `<string-match var="a, 1b" str="s" pattern="x"/>`},
+		{"Gmatch without var", `<string-gmatch str="s" pattern="x"></string-gmatch>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CompileString(tc.xml); err == nil {
+				t.Errorf("Expected error for %s", tc.xml)
+			}
+		})
+	}
+}
+
+func TestStringSplitJoin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		expected string
+	}{
+		{"Split literal", `<string-split var="parts" local="true" str="line" sep=","/>`, `local parts = line:split(",")`},
+		{"Split dot", `<string-split var="parts" local="true" str="path" sep="."/>`, `local parts = path:split(".")`},
+		{"Split space", `<string-split var="words" str="text" sep=" "/>`, `words = text:split(" ")`},
+		{"Split quoted", `<string-split var="parts" str="csv" sep='"\t"'/>`, `parts = csv:split("\t")`},
+		{"Split identifier", `<string-split var="parts" local="true" str="line" sep="delimiter"/>`, `local parts = line:split(delimiter)`},
+		{"Split field", `<string-split var="parts" str="msg.Text" sep="config.sep"/>`, `parts = msg.Text:split(config.sep)`},
+		{"Split quote escaped", `<string-split var="parts" str="s" sep='a"b'/>`, `parts = s:split("a\"b")`},
+		{"Split default", `<string-split var="parts" str="s"/>`, `parts = s:split()`},
+		{"Split expression", `<string-split str="a .. b" sep=";"/>`, `(a .. b):split(";")`},
+		{"Join literal", `<string-join var="joined" local="true" table="parts" sep=", "/>`, `local joined = table.concat(parts, ", ")`},
+		{"Join identifier", `<string-join var="joined" table="parts" sep="sep"/>`, `joined = table.concat(parts, sep)`},
+		{"Join default", `<string-join var="s" local="true" table="chars"/>`, `local s = table.concat(chars)`},
+		{"Join empty separator", `<string-join table="chars" sep=""/>`, `table.concat(chars, "")`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := CompileString(tc.xml)
+			if err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestStringSplitJoinErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xml      string
+		target   Target
+		errorMsg string
+	}{
+		{"Split without str", `<string-split var="p" sep=","/>`, TargetLuau, "string-split command requires 'str' attribute"},
+		{"Join without table", `<string-join var="s" sep=","/>`, TargetLuau, "string-join command requires 'table' attribute"},
+		{"Split in Lua 5.1", `<string-split var="p" str="s" sep=","/>`, TargetLua51, "string.split is not available in Lua 5.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := NewCompilerWithOptions(CompileOptions{Target: tc.target})
+			_, err := compiler.CompileFromString(tc.xml)
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("Expected error containing %q, got: %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -8,56 +11,181 @@ import (
 	"strings"
 
 	"lunaria/lunaria"
-)
-
-const (
-	version = "1.0.0"
+	"lunaria/lunaria/plugin"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		showHelp()
-		return
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the CLI with the given arguments and standard streams and
+// returns its exit code
+func run(args []string, in io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("lunaria", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprintln(stderr, "Run 'lunaria --help' for usage.") }
+
+	var output, target string
+	var pluginPaths []string
+	var minify, strict, emitLua51, write, noColor, help, version bool
+	fs.StringVar(&output, "o", "", "write output to `file`")
+	fs.StringVar(&output, "output", "", "write output to `file`")
+	fs.BoolVar(&minify, "minify", false, "strip comments and indentation")
+	fs.BoolVar(&strict, "strict", false, "prepend --!strict to scripts")
+	fs.StringVar(&target, "target", string(lunaria.TargetLuau), "output `dialect` (luau or lua51)")
+	fs.BoolVar(&emitLua51, "emit-lua51", false, "shorthand for --target lua51")
+	fs.Func("plugin", "load custom handlers from the Go plugin `file` (repeatable)", func(path string) error {
+		pluginPaths = append(pluginPaths, path)
+		return nil
+	})
+	fs.BoolVar(&write, "w", false, "fmt: rewrite the file in place")
+	fs.BoolVar(&noColor, "no-color", false, "disable colored output")
+	fs.BoolVar(&help, "h", false, "show help")
+	fs.BoolVar(&help, "help", false, "show help")
+	fs.BoolVar(&version, "v", false, "show version")
+	fs.BoolVar(&version, "version", false, "show version")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	colorWriters = map[io.Writer]bool{
+		stdout: colorSupported(noColor, stdout),
+		stderr: colorSupported(noColor, stderr),
+	}
+	options := lunaria.DefaultCompileOptions()
+	options.Minify = minify
+	if strict {
+		options.StrictMode = lunaria.StrictModeStrict
+	}
+	if emitLua51 {
+		target = string(lunaria.TargetLua51)
+	}
+	if options.Target, err = lunaria.ParseTarget(target); err != nil {
+		printError(stderr, "%v", err)
+		return 2
+	}
+
+	switch {
+	case help:
+		showHelp(stdout)
+		return 0
+	case version:
+		fmt.Fprintf(stdout, "Lunaria %s\n", lunaria.Version)
+		return 0
+	case len(positional) == 0:
+		showHelp(stdout)
+		return 0
+	case len(positional) > 1 && positional[0] != "diff" && positional[0] != "fmt" && positional[0] != "batch":
+		printError(stderr, "unexpected argument '%s' (use -o to choose an output file)", positional[1])
+		return 2
 	}
 
-	switch os.Args[1] {
-	case "-h", "--help", "help":
-		showHelp()
-	case "-v", "--version", "version":
-		fmt.Printf("Lunaria %s\n", version)
+	var plugins []lunaria.Plugin
+	for _, path := range pluginPaths {
+		p, err := plugin.Load(path)
+		if err != nil {
+			printError(stderr, "%v", err)
+			return 1
+		}
+		plugins = append(plugins, p)
+	}
+
+	switch positional[0] {
+	case "help":
+		showHelp(stdout)
+	case "version":
+		fmt.Fprintf(stdout, "Lunaria %s\n", lunaria.Version)
 	case "examples":
-		showExamples()
+		showExamples(stdout)
+	case "diff":
+		if len(positional) != 2 {
+			printError(stderr, "diff requires exactly one input file")
+			return 2
+		}
+		return diffFromFile(positional[1], output, options, plugins, stdout, stderr)
+	case "batch":
+		if len(positional) != 2 {
+			printError(stderr, "batch requires exactly one file pattern")
+			return 2
+		}
+		return batchFromPattern(positional[1], options, plugins, stdout, stderr)
+	case "fmt":
+		if len(positional) != 2 {
+			printError(stderr, "fmt requires exactly one input file")
+			return 2
+		}
+		if write {
+			output = positional[1]
+		}
+		return formatFile(positional[1], output, in, stdout, stderr)
 	case "-":
-		compileFromStdin()
+		return compileFromStdin(in, output, options, plugins, stdout, stderr)
 	default:
-		compileFromFile(os.Args[1])
+		return compileFromFile(positional[0], output, options, plugins, stdout, stderr)
+	}
+	return 0
+}
+
+// parseInterspersed parses fs from args, allowing flags to appear after
+// positional arguments, and returns the positional arguments in order
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
 }
 
-func showHelp() {
-	fmt.Println("Lunaria XML-to-Luau Compiler")
-	fmt.Printf("Version: %s\n\n", version)
-	fmt.Println("USAGE:")
-	fmt.Println("    lunaria [OPTIONS] [FILE]")
-	fmt.Println()
-	fmt.Println("ARGS:")
-	fmt.Println("    <FILE>    XML file to compile (use '-' for stdin)")
-	fmt.Println()
-	fmt.Println("OPTIONS:")
-	fmt.Println("    -h, --help       Show this help message")
-	fmt.Println("    -v, --version    Show version information")
-	fmt.Println("    examples         Show usage examples")
-	fmt.Println()
-	fmt.Println("EXAMPLES:")
-	fmt.Println("    lunaria script.xml    # Compile script.xml to Luau")
-	fmt.Println("    lunaria -             # Read from stdin")
-	fmt.Println("    cat script.xml | lunaria -")
+func showHelp(w io.Writer) {
+	fmt.Fprintln(w, "Lunaria XML-to-Luau Compiler")
+	fmt.Fprintf(w, "Version: %s\n\n", lunaria.Version)
+	fmt.Fprintln(w, "USAGE:")
+	fmt.Fprintln(w, "    lunaria [OPTIONS] [FILE]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "ARGS:")
+	fmt.Fprintln(w, "    <FILE>    XML file to compile (use '-' for stdin)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "OPTIONS:")
+	fmt.Fprintln(w, "    -h, --help             Show this help message")
+	fmt.Fprintln(w, "    -v, --version          Show version information")
+	fmt.Fprintln(w, "    -o, --output <FILE>    Write the compiled Luau to FILE instead of stdout")
+	fmt.Fprintln(w, "    --minify               Strip comments and indentation from the output")
+	fmt.Fprintln(w, "    --strict               Begin <script> output with --!strict")
+	fmt.Fprintln(w, "    --target <DIALECT>     Output dialect: luau (default) or lua51")
+	fmt.Fprintln(w, "    --emit-lua51           Shorthand for --target lua51")
+	fmt.Fprintln(w, "    --plugin <FILE>        Load custom tag handlers from a Go plugin (repeatable)")
+	fmt.Fprintln(w, "    --no-color             Disable colored output (also honours NO_COLOR)")
+	fmt.Fprintln(w, "    examples               Show usage examples")
+	fmt.Fprintln(w, "    diff <FILE>            Show how FILE's compiled output differs from its .lua")
+	fmt.Fprintln(w, "                           file (or -o FILE); exits 1 if it is out of date")
+	fmt.Fprintln(w, "    batch <PATTERN>        Compile every XML file matching the quoted glob")
+	fmt.Fprintln(w, "                           PATTERN to the .lua file beside it")
+	fmt.Fprintln(w, "    fmt <FILE>             Reformat the XML source of FILE to stdout, -o FILE,")
+	fmt.Fprintln(w, "                           or in place with -w")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "EXAMPLES:")
+	fmt.Fprintln(w, "    lunaria script.xml    # Compile script.xml to Luau")
+	fmt.Fprintln(w, "    lunaria -             # Read from stdin")
+	fmt.Fprintln(w, "    cat script.xml | lunaria -")
+	fmt.Fprintln(w, "    lunaria --minify script.xml -o out.lua")
+	fmt.Fprintln(w, "    lunaria --target lua51 script.xml")
+	fmt.Fprintln(w, "    lunaria diff script.xml    # Check script.lua is up to date")
+	fmt.Fprintln(w, "    lunaria fmt -w script.xml  # Tidy script.xml")
+	fmt.Fprintln(w, "    lunaria batch 'src/*.xml'  # Compile each file in src")
 }
 
-func showExamples() {
-	fmt.Println("Lunaria Examples")
-	fmt.Println("================")
-	fmt.Println()
+func showExamples(w io.Writer) {
+	fmt.Fprintln(w, "Lunaria Examples")
+	fmt.Fprintln(w, "================")
+	fmt.Fprintln(w)
 
 	examples := []struct {
 		title       string
@@ -133,158 +261,297 @@ end
 	}
 
 	for i, example := range examples {
-		fmt.Printf("%d. %s\n", i+1, example.title)
-		fmt.Printf("   %s\n\n", example.description)
-		fmt.Printf("   XML:\n")
-		printIndented(example.xml, "   ")
-		fmt.Println()
+		fmt.Fprintf(w, "%d. %s\n", i+1, example.title)
+		fmt.Fprintf(w, "   %s\n\n", example.description)
+		fmt.Fprintf(w, "   XML:\n")
+		printIndented(w, example.xml, "   ")
+		fmt.Fprintln(w)
 
 		// Show compiled output
 		if result, err := lunaria.CompileString(example.xml); err == nil {
-			fmt.Printf("   Compiles to:\n")
-			printIndented(result, "   ")
+			fmt.Fprintf(w, "   Compiles to:\n")
+			printIndented(w, result, "   ")
 		} else {
-			fmt.Printf("   Error: %v\n", err)
+			fmt.Fprintf(w, "   Error: %v\n", err)
 		}
 
-		fmt.Println()
-		fmt.Println(strings.Repeat("-", 60))
-		fmt.Println()
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, strings.Repeat("-", 60))
+		fmt.Fprintln(w)
 	}
 }
 
-func printIndented(text, indent string) {
+func printIndented(w io.Writer, text, indent string) {
 	lines := strings.Split(text, "\n")
 	for _, line := range lines {
 		if strings.TrimSpace(line) != "" {
-			fmt.Printf("%s%s\n", indent, line)
+			fmt.Fprintf(w, "%s%s\n", indent, line)
 		} else {
-			fmt.Println()
+			fmt.Fprintln(w)
 		}
 	}
 }
 
-func compileFromStdin() {
-	result, err := lunaria.CompileReader(os.Stdin)
+func compileFromStdin(in io.Reader, output string, options lunaria.CompileOptions, plugins []lunaria.Plugin, stdout, stderr io.Writer) int {
+	data, err := io.ReadAll(in)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		printError(stderr, "reading stdin: %v", err)
+		return 1
+	}
+	return compileSource("stdin", string(data), output, options, plugins, stdout, stderr)
+}
+
+func printWarnings(compiler *lunaria.Compiler, stderr io.Writer) {
+	for _, w := range compiler.Warnings() {
+		fmt.Fprintln(stderr, colorize(stderr, "warning", fmt.Sprintf("Warning: %s", w)))
 	}
-	fmt.Println(result)
 }
 
-func compileFromFile(filename string) {
+func compileFromFile(filename, output string, options lunaria.CompileOptions, plugins []lunaria.Plugin, stdout, stderr io.Writer) int {
 	// Check if file exists
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: File '%s' does not exist\n", filename)
-		os.Exit(1)
+		printError(stderr, "file '%s' does not exist", colorize(stderr, "bold", filename))
+		return 1
 	}
 
-	file, err := os.Open(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
-		os.Exit(1)
+		printError(stderr, "opening file: %v", err)
+		return 1
+	}
+	options.BaseDir = filepath.Dir(filename)
+	return compileSource(filename, string(data), output, options, plugins, stdout, stderr)
+}
+
+// newCompiler creates a compiler with options and the handlers of plugins,
+// the handler sets loaded with --plugin
+func newCompiler(options lunaria.CompileOptions, plugins []lunaria.Plugin) *lunaria.Compiler {
+	compiler := lunaria.NewCompilerWithOptions(options)
+	for _, p := range plugins {
+		p.Register(compiler)
 	}
-	defer file.Close()
+	return compiler
+}
 
-	result, err := lunaria.CompileReader(file)
+// compileSource compiles source, read from name, and writes the result
+func compileSource(name, source, output string, options lunaria.CompileOptions, plugins []lunaria.Plugin, stdout, stderr io.Writer) int {
+	compiler := newCompiler(options, plugins)
+	result, err := compiler.CompileFromString(source)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Compilation error in %s: %v\n", filename, err)
-		os.Exit(1)
+		reportCompileError(stderr, name, source, err)
+		return 1
 	}
+	printWarnings(compiler, stderr)
+	return writeResult(name, output, result, stdout, stderr)
+}
+
+// printError prints a message prefixed with a red "error:"
+func printError(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, "%s %s\n", colorize(w, "error", "error:"), fmt.Sprintf(format, args...))
+}
 
-	// If output filename is not specified, print to stdout
-	if len(os.Args) == 2 {
-		fmt.Println(result)
+// reportCompileError prints err against the name of the source it came
+// from. Errors that carry a line number, such as XML syntax errors, are
+// followed by the offending source line with a caret beneath it.
+func reportCompileError(w io.Writer, name, source string, err error) {
+	printError(w, "%s: %v", colorize(w, "bold", name), err)
+
+	var syntaxErr *xml.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return
+	}
+	lines := strings.Split(source, "\n")
+	if syntaxErr.Line < 1 || syntaxErr.Line > len(lines) {
 		return
 	}
 
-	// Optional: Save to file if a third argument is provided
-	if len(os.Args) >= 3 {
-		outputFile := os.Args[2]
-		if err := saveToFile(outputFile, result); err != nil {
-			fmt.Fprintf(os.Stderr, "Error saving to file: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("Compiled %s -> %s\n", filename, outputFile)
+	line := strings.TrimRight(lines[syntaxErr.Line-1], "\r")
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	gutter := fmt.Sprintf("%5d | ", syntaxErr.Line)
+	fmt.Fprintf(w, "%s%s\n", gutter, line)
+	fmt.Fprintf(w, "%s| %s%s\n", strings.Repeat(" ", len(gutter)-2), indent, colorize(w, "error", "^"))
+}
+
+// diffFromFile compiles filename and prints a unified diff against the
+// existing output file (output, or the .lua file beside the input). Like
+// diff(1) it exits 1 when they differ and 2 on errors, so CI can check that
+// generated code is up to date.
+func diffFromFile(filename, output string, options lunaria.CompileOptions, plugins []lunaria.Plugin, stdout, stderr io.Writer) int {
+	if output == "" {
+		output = getOutputFilename(filename)
 	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		printError(stderr, "opening file: %v", err)
+		return 2
+	}
+
+	options.BaseDir = filepath.Dir(filename)
+	compiler := newCompiler(options, plugins)
+	result, err := compiler.CompileFromString(string(data))
+	if err != nil {
+		reportCompileError(stderr, filename, string(data), err)
+		return 2
+	}
+	printWarnings(compiler, stderr)
+
+	fromName := output
+	existing, err := os.ReadFile(output)
+	if os.IsNotExist(err) {
+		fromName = "/dev/null"
+	} else if err != nil {
+		printError(stderr, "reading output file: %v", err)
+		return 2
+	}
+
+	toName := fmt.Sprintf("%s (compiled from %s)", output, filename)
+	diff := unifiedDiff(fromName, toName, splitLines(string(existing)), splitLines(result), 3)
+	if diff == "" {
+		return 0
+	}
+	fmt.Fprint(stdout, diff)
+	return 1
 }
 
-func saveToFile(filename, content string) error {
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(filename)
-	if dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return err
-		}
+// formatFile reformats the XML source in filename ('-' for stdin) and
+// prints it, or saves it to output when one was given
+func formatFile(filename, output string, in io.Reader, stdout, stderr io.Writer) int {
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = io.ReadAll(in)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		printError(stderr, "opening file: %v", err)
+		return 1
 	}
 
-	file, err := os.Create(filename)
+	result, err := lunaria.FormatString(string(data))
 	if err != nil {
-		return err
+		reportCompileError(stderr, filename, string(data), err)
+		return 1
 	}
-	defer file.Close()
 
-	_, err = io.WriteString(file, content)
-	return err
+	if output == "" {
+		fmt.Fprint(stdout, result)
+		return 0
+	}
+	if err := saveToFile(output, result); err != nil {
+		printError(stderr, "saving to file: %v", err)
+		return 1
+	}
+	return 0
 }
 
-// Additional CLI utilities
+// writeResult prints result to stdout, or saves it to output when one was given
+func writeResult(source, output, result string, stdout, stderr io.Writer) int {
+	if output == "" {
+		fmt.Fprintln(stdout, result)
+		return 0
+	}
 
-func isXMLFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".xml" || ext == ".lunaria"
+	if err := saveToFile(output, result); err != nil {
+		printError(stderr, "saving to file: %v", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, colorize(stdout, "success", fmt.Sprintf("Compiled %s -> %s", source, output)))
+	return 0
 }
 
-func getOutputFilename(inputFile string) string {
-	ext := filepath.Ext(inputFile)
-	base := strings.TrimSuffix(inputFile, ext)
-	return base + ".lua"
+// saveToFile writes content to filename, creating its directory if needed
+func saveToFile(filename, content string) error {
+	return lunaria.WriteFile(filename, content)
 }
 
-// Advanced CLI features (can be extended)
+// Terminal colors
 
-func compileBatch(pattern string) error {
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return err
+// colorWriters holds the output streams colorize emits ANSI escape codes
+// for, so that errors keep their color on a terminal while the output is
+// redirected and vice versa
+var colorWriters map[io.Writer]bool
+
+// ansiColors maps message levels to ANSI escape codes
+var ansiColors = map[string]string{
+	"error":   "\033[31m",
+	"warning": "\033[33m",
+	"success": "\033[32m",
+	"bold":    "\033[1m",
+}
+
+const ansiReset = "\033[0m"
+
+// colorize wraps msg in the ANSI color for level when color output is
+// enabled for w, the stream msg is written to
+func colorize(w io.Writer, level, msg string) string {
+	code, ok := ansiColors[level]
+	if !colorWriters[w] || !ok {
+		return msg
 	}
+	return code + msg + ansiReset
+}
 
-	if len(matches) == 0 {
-		return fmt.Errorf("no files match pattern: %s", pattern)
+// colorSupported reports whether colored output should be used on w: not
+// disabled by flag or NO_COLOR (see no-color.org), and w is a terminal
+func colorSupported(noColor bool, w io.Writer) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
 	}
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f)
+}
 
-	for _, filename := range matches {
-		if !isXMLFile(filename) {
-			continue
-		}
+// isTerminal reports whether f refers to a character device such as a TTY
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
-		fmt.Printf("Compiling %s...", filename)
+// Additional CLI utilities
 
-		file, err := os.Open(filename)
-		if err != nil {
-			fmt.Printf(" ERROR: %v\n", err)
-			continue
-		}
+func isXMLFile(filename string) bool {
+	return lunaria.IsLunariaFile(filename)
+}
 
-		result, err := lunaria.CompileReader(file)
-		file.Close()
+func getOutputFilename(inputFile string) string {
+	return lunaria.OutputFilename(inputFile)
+}
 
-		if err != nil {
-			fmt.Printf(" ERROR: %v\n", err)
-			continue
-		}
+// compileBatch compiles every XML file matching the glob pattern to the
+// .lua file beside it and returns the outcome of each, in file name order
+func compileBatch(pattern string, options lunaria.CompileOptions, plugins []lunaria.Plugin) ([]lunaria.BatchResult, error) {
+	return newCompiler(options, plugins).CompileGlob(pattern)
+}
 
-		outputFile := getOutputFilename(filename)
-		if err := saveToFile(outputFile, result); err != nil {
-			fmt.Printf(" ERROR saving: %v\n", err)
+// batchFromPattern compiles the files matching pattern and reports the
+// outcome of each. It exits 1 when any file fails.
+func batchFromPattern(pattern string, options lunaria.CompileOptions, plugins []lunaria.Plugin, stdout, stderr io.Writer) int {
+	results, err := compileBatch(pattern, options, plugins)
+	if err != nil {
+		printError(stderr, "%v", err)
+		return 1
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			printError(stderr, "%s: %v", colorize(stderr, "bold", result.File), result.Err)
 			continue
 		}
-
-		fmt.Printf(" -> %s\n", outputFile)
+		fmt.Fprintln(stdout, colorize(stdout, "success", fmt.Sprintf("Compiled %s -> %s", result.File, result.Output)))
 	}
 
-	return nil
+	if failed > 0 {
+		printError(stderr, "%d of %d files failed to compile", failed, len(results))
+		return 1
+	}
+	return 0
 }
 
 // Watch mode (placeholder for future implementation)
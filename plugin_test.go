@@ -0,0 +1,44 @@
+//go:build plugin
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildPlugin builds testdata/plugin as a Go plugin and returns its path.
+// The plugin must be built against the same lunaria package as the test
+// binary, which is why this test lives outside the lunaria package.
+func buildPlugin(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "greet.so")
+	out, err := exec.Command("go", "build", "-buildmode=plugin", "-o", path, "./testdata/plugin").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Building plugin failed: %v\n%s", err, out)
+	}
+	return path
+}
+
+func TestRunPlugin(t *testing.T) {
+	path := buildPlugin(t)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"--plugin", path, "-"}, strings.NewReader(`<if test="ok"><greet name="World"/></if>`), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if expected := "if ok then\n    print(\"Hello, World\")\nend\n"; stdout.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, stdout.String())
+	}
+
+	// Plugins only apply to the run that loads them
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"-"}, strings.NewReader(`<greet name="World"/>`), &stdout, &stderr); code == 0 {
+		t.Error("Expected the plugin's tag to be unknown without --plugin")
+	}
+}
@@ -0,0 +1,18 @@
+// Package main is a Go plugin registering a <greet> tag, loaded by the
+// plugin tests
+package main
+
+import (
+	"fmt"
+
+	"lunaria/lunaria"
+)
+
+// RegisterHandlers registers the plugin's handlers
+func RegisterHandlers(c *lunaria.Compiler) {
+	c.Register("greet", func(node lunaria.Node, compiler *lunaria.Compiler) (string, error) {
+		return fmt.Sprintf("%sprint(\"Hello, %s\")", compiler.CurrentIndent(), lunaria.EscapeString(lunaria.GetAttr(node, "name"))), nil
+	})
+}
+
+func main() {}